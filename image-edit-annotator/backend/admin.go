@@ -0,0 +1,131 @@
+package main
+
+import (
+	"net/http"
+	"runtime"
+	"strconv"
+)
+
+func schemaVersionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	applied, err := getAppliedMigrations()
+	if err != nil {
+		writeError(w, r, "Failed to get schema version", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to get applied migrations", err)
+		return
+	}
+
+	version := 0
+	for _, m := range applied {
+		version = max(version, m.Version)
+	}
+	if applied == nil {
+		applied = []SchemaMigration{}
+	}
+
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"version":       version,
+		"latestVersion": migrations[len(migrations)-1].version, // Newest migration this build knows about
+		"migrations":    applied,
+	})
+}
+
+// configHandler reports the effective configuration with secrets redacted
+func configHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, effectiveConfig())
+}
+
+// listActiveJobsHandler reports what the server is busy with in the background.
+// Auto-caption session usage against the server-wide limit is sent in headers
+// so the body stays a plain list.
+func listActiveJobsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	active, limit := autoCaptionManager.SessionCounts()
+	w.Header().Set("X-Auto-Caption-Sessions", strconv.Itoa(active))
+	w.Header().Set("X-Auto-Caption-Session-Limit", strconv.Itoa(limit))
+
+	writeJSON(w, r, http.StatusOK, listActiveJobs())
+}
+
+// dbStatsHandler reports connection pool usage alongside the configured limits,
+// so contention can be judged against the pool size
+func dbStatsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	stats := db.Stats()
+
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"maxOpenConnections": stats.MaxOpenConnections,
+		"openConnections":    stats.OpenConnections,
+		"inUse":              stats.InUse,
+		"idle":               stats.Idle,
+		"waitCount":          stats.WaitCount,
+		"waitDurationMs":     stats.WaitDuration.Milliseconds(),
+		"maxIdleClosed":      stats.MaxIdleClosed,
+		"maxIdleTimeClosed":  stats.MaxIdleTimeClosed,
+		"maxLifetimeClosed":  stats.MaxLifetimeClosed,
+		"config": map[string]interface{}{
+			"maxOpenConns":           appConfig.DBMaxOpenConns,
+			"maxIdleConns":           appConfig.DBMaxIdleConns,
+			"connMaxLifetimeSeconds": int(appConfig.DBConnMaxLifetime.Seconds()),
+		},
+		"guidance": "SQLite serialises writes, so extra connections mainly help concurrent reads. " +
+			"A growing waitCount while inUse equals maxOpenConnections means requests are queueing for a connection.",
+	})
+}
+
+// debugStatsHandler reports goroutine and memory usage along with the number
+// of registered SSE progress clients, to help confirm that goroutines and
+// channels are cleaned up
+func debugStatsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	progressMu.RLock()
+	uploadClients := len(progressClients)
+	progressMu.RUnlock()
+	exportProgressMu.RLock()
+	exportClients := len(exportProgressClients)
+	exportProgressMu.RUnlock()
+
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"goroutines": runtime.NumGoroutine(),
+		"memory": map[string]interface{}{
+			"allocBytes":      mem.Alloc,
+			"totalAllocBytes": mem.TotalAlloc,
+			"sysBytes":        mem.Sys,
+			"heapAllocBytes":  mem.HeapAlloc,
+			"heapInuseBytes":  mem.HeapInuse,
+			"heapObjects":     mem.HeapObjects,
+			"stackInuseBytes": mem.StackInuse,
+			"numGC":           mem.NumGC,
+			"pauseTotalNs":    mem.PauseTotalNs,
+		},
+		"progressClients": map[string]interface{}{
+			"upload":      uploadClients,
+			"autoCaption": autoCaptionManager.ProgressClientCount(),
+			"export":      exportClients,
+		},
+	})
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"crypto/subtle"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// withAdminAuth restricts an admin endpoint. When ADMIN_TOKEN is set the
+// request must carry it as "Authorization: Bearer <token>"; otherwise only
+// clients connecting from the loopback interface are allowed.
+func withAdminAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if appConfig.AdminToken != "" {
+			token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !found || subtle.ConstantTimeCompare([]byte(token), []byte(appConfig.AdminToken)) != 1 {
+				writeError(w, r, "Admin token required", http.StatusUnauthorized)
+				logInfo(r.Context(), "Admin request rejected",
+					slog.String("path", r.URL.Path),
+					slog.String("reason", "missing or invalid token"))
+				return
+			}
+			next(w, r)
+			return
+		}
+
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if ip := net.ParseIP(host); err != nil || ip == nil || !ip.IsLoopback() {
+			writeError(w, r, "Admin endpoints are only available from localhost unless ADMIN_TOKEN is set", http.StatusForbidden)
+			logInfo(r.Context(), "Admin request rejected",
+				slog.String("path", r.URL.Path),
+				slog.String("remote_addr", r.RemoteAddr))
+			return
+		}
+		next(w, r)
+	}
+}
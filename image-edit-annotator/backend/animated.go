@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+
+	"golang.org/x/image/webp"
+)
+
+// decodeFirstFrame decodes an image, explicitly taking the first frame of
+// animated GIF and WebP inputs so hashing and previews are consistent. It
+// reports the detected format and whether the input was animated.
+func decodeFirstFrame(content []byte) (image.Image, string, bool, error) {
+	if bytes.HasPrefix(content, []byte("GIF8")) {
+		return decodeGIFFirstFrame(content)
+	}
+
+	if isAnimatedWebP(content) {
+		frame, err := extractWebPFirstFrame(content)
+		if err != nil {
+			return nil, "webp", true, err
+		}
+		img, err := webp.Decode(bytes.NewReader(frame))
+		if err != nil {
+			return nil, "webp", true, fmt.Errorf("failed to decode first frame: %v", err)
+		}
+		return img, "webp", true, nil
+	}
+
+	img, format, err := image.Decode(bytes.NewReader(content))
+	return img, format, false, err
+}
+
+// decodeGIFFirstFrame composites the first frame onto the GIF's logical screen,
+// since frames may be smaller than the canvas they are drawn on
+func decodeGIFFirstFrame(content []byte) (image.Image, string, bool, error) {
+	decoded, err := gif.DecodeAll(bytes.NewReader(content))
+	if err != nil {
+		return nil, "gif", false, err
+	}
+	if len(decoded.Image) == 0 {
+		return nil, "gif", false, fmt.Errorf("gif contains no frames")
+	}
+
+	animated := len(decoded.Image) > 1
+	frame := decoded.Image[0]
+
+	width, height := decoded.Config.Width, decoded.Config.Height
+	if width == 0 || height == 0 {
+		return frame, "gif", animated, nil
+	}
+
+	canvas := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+	return canvas, "gif", animated, nil
+}
+
+type riffChunk struct {
+	fourCC  string
+	payload []byte
+}
+
+// readRIFFChunks splits a RIFF body into chunks, honouring the even-byte padding
+func readRIFFChunks(data []byte) ([]riffChunk, error) {
+	var chunks []riffChunk
+	for len(data) >= 8 {
+		fourCC := string(data[:4])
+		size := int(binary.LittleEndian.Uint32(data[4:8]))
+		if 8+size > len(data) {
+			return nil, fmt.Errorf("truncated %s chunk", fourCC)
+		}
+		chunks = append(chunks, riffChunk{fourCC: fourCC, payload: data[8 : 8+size]})
+		next := 8 + size + size%2
+		if next > len(data) {
+			break
+		}
+		data = data[next:]
+	}
+	return chunks, nil
+}
+
+func isAnimatedWebP(content []byte) bool {
+	// RIFF header (12 bytes) followed by a VP8X chunk whose flags carry the animation bit
+	if len(content) < 21 || string(content[:4]) != "RIFF" || string(content[8:12]) != "WEBP" || string(content[12:16]) != "VP8X" {
+		return false
+	}
+	const animationFlag = 0x02
+	return content[20]&animationFlag != 0
+}
+
+// extractWebPFirstFrame rebuilds the first ANMF frame of an animated WebP as a
+// standalone still WebP that the x/image decoder can handle
+func extractWebPFirstFrame(content []byte) ([]byte, error) {
+	chunks, err := readRIFFChunks(content[12:])
+	if err != nil {
+		return nil, err
+	}
+
+	for _, chunk := range chunks {
+		if chunk.fourCC != "ANMF" {
+			continue
+		}
+		// Frame header: X, Y, width-1, height-1, duration (24 bits each) and a flags byte
+		if len(chunk.payload) < 16 {
+			return nil, fmt.Errorf("truncated ANMF chunk")
+		}
+		widthMinusOne := chunk.payload[6:9]
+		heightMinusOne := chunk.payload[9:12]
+
+		frameChunks, err := readRIFFChunks(chunk.payload[16:])
+		if err != nil {
+			return nil, err
+		}
+
+		var body bytes.Buffer
+		hasAlpha := false
+		for _, frameChunk := range frameChunks {
+			if frameChunk.fourCC == "ALPH" {
+				hasAlpha = true
+			}
+		}
+		if hasAlpha {
+			// Alpha-carrying VP8 frames need an extended header to be decodable
+			vp8x := make([]byte, 10)
+			vp8x[0] = 0x10
+			copy(vp8x[4:7], widthMinusOne)
+			copy(vp8x[7:10], heightMinusOne)
+			writeRIFFChunk(&body, "VP8X", vp8x)
+		}
+		for _, frameChunk := range frameChunks {
+			switch frameChunk.fourCC {
+			case "ALPH", "VP8 ", "VP8L":
+				writeRIFFChunk(&body, frameChunk.fourCC, frameChunk.payload)
+			}
+		}
+
+		var out bytes.Buffer
+		out.WriteString("RIFF")
+		binary.Write(&out, binary.LittleEndian, uint32(4+body.Len()))
+		out.WriteString("WEBP")
+		out.Write(body.Bytes())
+		return out.Bytes(), nil
+	}
+
+	return nil, fmt.Errorf("animated webp contains no frames")
+}
+
+func writeRIFFChunk(buf *bytes.Buffer, fourCC string, payload []byte) {
+	buf.WriteString(fourCC)
+	binary.Write(buf, binary.LittleEndian, uint32(len(payload)))
+	buf.Write(payload)
+	if len(payload)%2 == 1 {
+		buf.WriteByte(0)
+	}
+}
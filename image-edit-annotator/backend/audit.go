@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+)
+
+// auditRedactedFields are JSON fields whose values must never be written to the audit log
+var auditRedactedFields = map[string]bool{
+	"captionApi": true, // contains the provider API key
+}
+
+type auditFieldChange struct {
+	Old interface{} `json:"old"`
+	New interface{} `json:"new"`
+}
+
+// recordAudit writes an audit log entry describing a mutation. before is nil for
+// creations and after is nil for deletions. Failures are logged rather than
+// returned so auditing never blocks the mutation itself.
+func recordAudit(ctx context.Context, projectID, action, entityType, entityID string, before, after interface{}) {
+	diff, err := auditDiff(before, after)
+	if err != nil {
+		logger.Warn("Failed to compute audit diff", "error", err, "entity_type", entityType, "entity_id", entityID)
+		return
+	}
+
+	entry := &AuditLogEntry{
+		ProjectID:  projectID,
+		Action:     action,
+		EntityType: entityType,
+		EntityID:   entityID,
+		RequestID:  getRequestID(ctx),
+		Diff:       diff,
+	}
+	if err := createAuditLogEntry(entry); err != nil {
+		logger.Warn("Failed to write audit log entry", "error", err, "entity_type", entityType, "entity_id", entityID)
+	}
+}
+
+// auditDiff returns a JSON object mapping each changed field to its old and new value
+func auditDiff(before, after interface{}) (json.RawMessage, error) {
+	beforeFields, err := auditFields(before)
+	if err != nil {
+		return nil, err
+	}
+	afterFields, err := auditFields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := make(map[string]auditFieldChange)
+	for key, newValue := range afterFields {
+		oldValue := beforeFields[key]
+		if !reflect.DeepEqual(oldValue, newValue) {
+			changes[key] = auditFieldChange{Old: oldValue, New: newValue}
+		}
+	}
+	for key, oldValue := range beforeFields {
+		if _, exists := afterFields[key]; !exists && oldValue != nil {
+			changes[key] = auditFieldChange{Old: oldValue, New: nil}
+		}
+	}
+
+	for key, change := range changes {
+		if auditRedactedFields[key] {
+			if change.Old != nil {
+				change.Old = "[redacted]"
+			}
+			if change.New != nil {
+				change.New = "[redacted]"
+			}
+			changes[key] = change
+		}
+	}
+
+	return json.Marshal(changes)
+}
+
+func auditFields(entity interface{}) (map[string]interface{}, error) {
+	fields := make(map[string]interface{})
+	if entity == nil {
+		return fields, nil
+	}
+	if value := reflect.ValueOf(entity); value.Kind() == reflect.Ptr && value.IsNil() {
+		return fields, nil
+	}
+
+	data, err := json.Marshal(entity)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
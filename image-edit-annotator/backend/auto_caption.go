@@ -3,8 +3,9 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"path/filepath"
+	"math"
 	"sync"
 	"time"
 )
@@ -26,8 +27,29 @@ type AutoCaptionSession struct {
 	Tasks           []CaptionTask
 	CurrentIndex    int
 	mutex           sync.RWMutex
+
+	// Timing observations behind the progress metrics, guarded by mutex
+	startedAt       time.Time
+	taskTime        time.Duration // Total time spent in processTaskWithRetries
+	recentFinishes  []time.Time   // Task completion times within the last rpmWindow
+
+	// resume is non-nil while the session is paused and is closed to resume it.
+	// Guarded by mutex.
+	resume          chan struct{}
+	pausedAt        time.Time
 }
 
+// errTooManyAutoCaptionSessions is returned by StartAutoCaptioning when
+// appConfig.MaxAutoCaptionSessions sessions are already active
+var errTooManyAutoCaptionSessions = errors.New("too many active auto captioning sessions")
+
+// rpmWindow is the span over which currentRPM is measured
+const rpmWindow = time.Minute
+
+// defaultMaxConsecutiveFailures stops a run whose provider keeps failing, e.g.
+// after its API key is revoked, instead of retrying every remaining task
+const defaultMaxConsecutiveFailures = 5
+
 var autoCaptionManager *AutoCaptionManager
 
 func init() {
@@ -37,8 +59,25 @@ func init() {
 	}
 }
 
+// validate rejects rates that would break the request delay calculation and
+// clamps the rate to the server's ceiling
+func (config *AutoCaptionConfig) validate() error {
+	if config.RPM <= 0 {
+		return fmt.Errorf("rpm must be a positive number of requests per minute, got %d", config.RPM)
+	}
+	if config.RPM > appConfig.MaxCaptionRPM {
+		config.RPM = appConfig.MaxCaptionRPM
+	}
+	return nil
+}
+
 // StartAutoCaptioning begins the auto captioning process for a project
 func (acm *AutoCaptionManager) StartAutoCaptioning(projectID string, config AutoCaptionConfig) error {
+	// The request delay is derived from RPM, so it must be checked before anything runs
+	if err := config.validate(); err != nil {
+		return err
+	}
+
 	acm.mutex.Lock()
 	defer acm.mutex.Unlock()
 
@@ -47,6 +86,13 @@ func (acm *AutoCaptionManager) StartAutoCaptioning(projectID string, config Auto
 		return fmt.Errorf("auto captioning already running for project %s", projectID)
 	}
 
+	// Sessions leave activeProjects when their goroutine exits, so the map
+	// doubles as the server-wide slot count. Paused sessions keep their slot.
+	if len(acm.activeProjects) >= appConfig.MaxAutoCaptionSessions {
+		return fmt.Errorf("%w (%d of %d); wait for one to finish or cancel one", errTooManyAutoCaptionSessions,
+			len(acm.activeProjects), appConfig.MaxAutoCaptionSessions)
+	}
+
 	// Get project to validate and check API configuration
 	project, err := getProject(projectID)
 	if err != nil {
@@ -88,6 +134,7 @@ func (acm *AutoCaptionManager) StartAutoCaptioning(projectID string, config Auto
 		Config:     config,
 		CancelFunc: cancel,
 		Tasks:      pendingTasks,
+		startedAt:  time.Now(),
 		Progress: AutoCaptionProgress{
 			ProjectID: projectID,
 			Status:    "running",
@@ -127,6 +174,79 @@ func (acm *AutoCaptionManager) CancelAutoCaptioning(projectID string) error {
 	return nil
 }
 
+// PauseAutoCaptioning stops a session from starting new tasks. A task already
+// being captioned finishes, and the session stays active so it can be resumed.
+func (acm *AutoCaptionManager) PauseAutoCaptioning(projectID string) error {
+	acm.mutex.RLock()
+	session, exists := acm.activeProjects[projectID]
+	acm.mutex.RUnlock()
+	if !exists {
+		return fmt.Errorf("no active auto captioning session for project %s", projectID)
+	}
+
+	session.mutex.Lock()
+	if session.Progress.Status != "running" {
+		status := session.Progress.Status
+		session.mutex.Unlock()
+		return fmt.Errorf("auto captioning for project %s is %s, not running", projectID, status)
+	}
+	session.resume = make(chan struct{})
+	session.pausedAt = time.Now()
+	session.Progress.Status = "paused"
+	session.Progress.PausedAt = session.pausedAt.Format(time.RFC3339)
+	session.Progress.EstimatedCompletionAt = ""
+	progress := session.Progress
+	session.mutex.Unlock()
+
+	acm.sendProgressUpdate(projectID, progress)
+	return nil
+}
+
+// ResumeAutoCaptioning continues a paused session from the task it stopped at
+func (acm *AutoCaptionManager) ResumeAutoCaptioning(projectID string) error {
+	acm.mutex.RLock()
+	session, exists := acm.activeProjects[projectID]
+	acm.mutex.RUnlock()
+	if !exists {
+		return fmt.Errorf("no active auto captioning session for project %s", projectID)
+	}
+
+	session.mutex.Lock()
+	if session.resume == nil {
+		session.mutex.Unlock()
+		return fmt.Errorf("auto captioning for project %s is not paused", projectID)
+	}
+	close(session.resume)
+	session.resume = nil
+	// Time spent paused doesn't count towards the pace behind the ETA
+	session.startedAt = session.startedAt.Add(time.Since(session.pausedAt))
+	session.Progress.Status = "running"
+	session.Progress.PausedAt = ""
+	progress := session.Progress
+	session.mutex.Unlock()
+
+	acm.sendProgressUpdate(projectID, progress)
+	return nil
+}
+
+// waitWhilePaused blocks while the session is paused. It returns false if
+// the session is cancelled first.
+func (session *AutoCaptionSession) waitWhilePaused(ctx context.Context) bool {
+	session.mutex.RLock()
+	resume := session.resume
+	session.mutex.RUnlock()
+	if resume == nil {
+		return true
+	}
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-resume:
+		return true
+	}
+}
+
 // GetAutoCaptionStatus returns the current status of auto captioning for a project
 func (acm *AutoCaptionManager) GetAutoCaptionStatus(projectID string) (*AutoCaptionStatusResponse, error) {
 	acm.mutex.RLock()
@@ -135,7 +255,9 @@ func (acm *AutoCaptionManager) GetAutoCaptionStatus(projectID string) (*AutoCapt
 	session, exists := acm.activeProjects[projectID]
 	if !exists {
 		return &AutoCaptionStatusResponse{
-			IsActive: false,
+			IsActive:       false,
+			ActiveSessions: len(acm.activeProjects),
+			MaxSessions:    appConfig.MaxAutoCaptionSessions,
 		}, nil
 	}
 
@@ -144,11 +266,34 @@ func (acm *AutoCaptionManager) GetAutoCaptionStatus(projectID string) (*AutoCapt
 	session.mutex.RUnlock()
 
 	return &AutoCaptionStatusResponse{
-		Progress: &progress,
-		IsActive: true,
+		Progress:       &progress,
+		IsActive:       true,
+		ActiveSessions: len(acm.activeProjects),
+		MaxSessions:    appConfig.MaxAutoCaptionSessions,
 	}, nil
 }
 
+// SessionCounts returns how many sessions are active and how many may be
+func (acm *AutoCaptionManager) SessionCounts() (int, int) {
+	acm.mutex.RLock()
+	defer acm.mutex.RUnlock()
+	return len(acm.activeProjects), appConfig.MaxAutoCaptionSessions
+}
+
+// ActiveSessions returns the progress of every running auto captioning session
+func (acm *AutoCaptionManager) ActiveSessions() []AutoCaptionProgress {
+	acm.mutex.RLock()
+	defer acm.mutex.RUnlock()
+
+	sessions := make([]AutoCaptionProgress, 0, len(acm.activeProjects))
+	for _, session := range acm.activeProjects {
+		session.mutex.RLock()
+		sessions = append(sessions, session.Progress)
+		session.mutex.RUnlock()
+	}
+	return sessions
+}
+
 // processAutoCaptioning handles the actual captioning process
 func (acm *AutoCaptionManager) processAutoCaptioning(ctx context.Context, session *AutoCaptionSession, project *Project) {
 	defer func() {
@@ -175,10 +320,13 @@ func (acm *AutoCaptionManager) processAutoCaptioning(ctx context.Context, sessio
 	requestDelay := time.Duration(60000/session.Config.RPM) * time.Millisecond
 
 	// Get system prompt
-	systemPrompt := "Describe this image in detail for training a diffusion model. Focus on the visual elements, composition, style, and any notable features."
-	if project.SystemPrompt != nil && *project.SystemPrompt != "" {
-		systemPrompt = *project.SystemPrompt
+	systemPrompt := buildSystemPrompt(project)
+
+	maxConsecutiveFailures := session.Config.MaxConsecutiveFailures
+	if maxConsecutiveFailures <= 0 {
+		maxConsecutiveFailures = defaultMaxConsecutiveFailures
 	}
+	consecutiveFailures := 0
 
 	// Process each task
 	for i, task := range session.Tasks {
@@ -188,6 +336,11 @@ func (acm *AutoCaptionManager) processAutoCaptioning(ctx context.Context, sessio
 		default:
 		}
 
+		// A paused session holds here, at the next task to caption
+		if !session.waitWhilePaused(ctx) {
+			return
+		}
+
 		session.mutex.Lock()
 		session.CurrentIndex = i
 		session.Progress.CurrentTask = task.ID
@@ -197,7 +350,8 @@ func (acm *AutoCaptionManager) processAutoCaptioning(ctx context.Context, sessio
 		acm.sendProgressUpdate(session.ProjectID, session.Progress)
 
 		// Process task with retries
-		success := acm.processTaskWithRetries(ctx, task, session, captioningService, systemPrompt, project.ID)
+		taskStart := time.Now()
+		success := acm.processTaskWithRetries(ctx, task, session, captioningService, systemPrompt, project)
 		
 		session.mutex.Lock()
 		if success {
@@ -205,8 +359,31 @@ func (acm *AutoCaptionManager) processAutoCaptioning(ctx context.Context, sessio
 		} else {
 			session.Progress.Failed++
 		}
+		session.Progress.Processed = i + 1
+		session.recordTaskTiming(i+1, time.Since(taskStart))
+		progress := session.Progress
 		session.mutex.Unlock()
 
+		acm.sendProgressUpdate(session.ProjectID, progress)
+
+		// Circuit breaker: a run of failures points at the provider, not the tasks
+		if success {
+			consecutiveFailures = 0
+		} else if consecutiveFailures++; consecutiveFailures >= maxConsecutiveFailures {
+			logger.Warn("Stopping auto captioning after consecutive failures",
+				"project_id", session.ProjectID,
+				"failures", consecutiveFailures,
+			)
+			session.mutex.Lock()
+			session.Progress.CurrentTask = ""
+			session.Progress.EstimatedCompletionAt = ""
+			session.mutex.Unlock()
+			acm.updateProgress(session, "error", fmt.Sprintf(
+				"Stopped after %d consecutive failed tasks; the caption API may be unavailable or misconfigured (see the server log for details)",
+				consecutiveFailures))
+			return
+		}
+
 		// Apply rate limiting delay (except for last task)
 		if i < len(session.Tasks)-1 {
 			select {
@@ -222,15 +399,43 @@ func (acm *AutoCaptionManager) processAutoCaptioning(ctx context.Context, sessio
 	session.Progress.Status = "completed"
 	session.Progress.Processed = len(session.Tasks)
 	session.Progress.CurrentTask = ""
+	session.Progress.PausedAt = ""
 	session.Progress.CompletedAt = time.Now().Format(time.RFC3339)
+	session.Progress.EstimatedCompletionAt = ""
 	finalProgress := session.Progress
 	session.mutex.Unlock()
 
 	acm.sendProgressUpdate(session.ProjectID, finalProgress)
 }
 
+// recordTaskTiming updates the throughput metrics after the finished-th task
+// completes in taskTime. The caller must hold session.mutex.
+func (session *AutoCaptionSession) recordTaskTiming(finished int, taskTime time.Duration) {
+	now := time.Now()
+	session.taskTime += taskTime
+	session.Progress.AverageMsPerTask = (session.taskTime / time.Duration(finished)).Milliseconds()
+
+	// The ETA uses wall clock time per task, so the rate limiting delay is included
+	elapsed := now.Sub(session.startedAt)
+	remaining := len(session.Tasks) - finished
+	perTask := elapsed / time.Duration(finished)
+	session.Progress.EstimatedCompletionAt = now.Add(perTask * time.Duration(remaining)).Format(time.RFC3339)
+
+	session.recentFinishes = append(session.recentFinishes, now)
+	cutoff := now.Add(-rpmWindow)
+	for len(session.recentFinishes) > 0 && session.recentFinishes[0].Before(cutoff) {
+		session.recentFinishes = session.recentFinishes[1:]
+	}
+	// Early in a run the window isn't full yet, so measure over the time elapsed
+	window := min(elapsed, rpmWindow)
+	if window > 0 {
+		rpm := float64(len(session.recentFinishes)) / window.Minutes()
+		session.Progress.CurrentRPM = math.Round(rpm*10) / 10
+	}
+}
+
 // processTaskWithRetries handles a single task with retry logic
-func (acm *AutoCaptionManager) processTaskWithRetries(ctx context.Context, task CaptionTask, session *AutoCaptionSession, service CaptioningService, systemPrompt, projectID string) bool {
+func (acm *AutoCaptionManager) processTaskWithRetries(ctx context.Context, task CaptionTask, session *AutoCaptionSession, service CaptioningService, systemPrompt string, project *Project) bool {
 	maxRetries := session.Config.MaxRetries
 	if maxRetries <= 0 {
 		maxRetries = 3
@@ -265,7 +470,7 @@ func (acm *AutoCaptionManager) processTaskWithRetries(ctx context.Context, task
 		}
 
 		// Convert image to base64
-		imagePath := filepath.Join("data", "projects", projectID, image.Path)
+		imagePath := imageFilePath(image)
 		imageBase64, err := ImageToBase64(imagePath)
 		if err != nil {
 			logger.Error("Failed to encode image for auto captioning", "error", err, "path", imagePath)
@@ -279,7 +484,9 @@ func (acm *AutoCaptionManager) processTaskWithRetries(ctx context.Context, task
 		// Generate caption
 		caption, err := service.GenerateCaption(imageBase64, systemPrompt)
 		if err != nil {
-			logger.Error("Failed to generate caption", "error", err, "task_id", task.ID, "attempt", attempt+1)
+			// A hung provider surfaces as a timeout, retried with the same backoff
+			logger.Error("Failed to generate caption", "error", err, "task_id", task.ID, "attempt", attempt+1,
+				"timed_out", errors.Is(err, errProviderTimeout))
 			if attempt == maxRetries {
 				return false
 			}
@@ -288,11 +495,11 @@ func (acm *AutoCaptionManager) processTaskWithRetries(ctx context.Context, task
 		}
 
 		// Update task in database
-		task.Caption.String = caption
+		task.Caption.String = applyCaptionTemplate(project, stripCaption(project, caption))
 		task.Caption.Valid = true
 		task.Status = "auto_generated"
 
-		if err := updateCaptionTask(&task); err != nil {
+		if err := updateCaptionTask(&task, "auto_generated"); err != nil {
 			logger.Error("Failed to update caption task", "error", err, "task_id", task.ID)
 			if attempt == maxRetries {
 				return false
@@ -300,6 +507,7 @@ func (acm *AutoCaptionManager) processTaskWithRetries(ctx context.Context, task
 			time.Sleep(baseDelay * time.Duration(attempt+1))
 			continue
 		}
+		invalidateProjectStats(project.ID)
 
 		logger.Info("Successfully generated auto caption", "task_id", task.ID, "caption_length", len(caption))
 		return true
@@ -337,6 +545,13 @@ func (acm *AutoCaptionManager) sendProgressUpdate(projectID string, progress Aut
 	}
 }
 
+// ProgressClientCount returns how many SSE clients are registered
+func (acm *AutoCaptionManager) ProgressClientCount() int {
+	acm.progressClientsMu.RLock()
+	defer acm.progressClientsMu.RUnlock()
+	return len(acm.progressClients)
+}
+
 // AddProgressClient adds a progress update client for a project
 func (acm *AutoCaptionManager) AddProgressClient(projectID string, client chan AutoCaptionProgress) {
 	acm.progressClientsMu.Lock()
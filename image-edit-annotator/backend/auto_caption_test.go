@@ -0,0 +1,40 @@
+package main
+
+import "testing"
+
+func TestAutoCaptionConfigValidateRPM(t *testing.T) {
+	previous := appConfig.MaxCaptionRPM
+	appConfig.MaxCaptionRPM = 120
+	t.Cleanup(func() { appConfig.MaxCaptionRPM = previous })
+
+	tests := []struct {
+		name    string
+		rpm     int
+		wantErr bool
+		wantRPM int
+	}{
+		{name: "zero", rpm: 0, wantErr: true},
+		{name: "negative", rpm: -30, wantErr: true},
+		{name: "within limit", rpm: 60, wantRPM: 60},
+		{name: "at limit", rpm: 120, wantRPM: 120},
+		{name: "above limit", rpm: 10000, wantRPM: 120},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := AutoCaptionConfig{RPM: test.rpm}
+			err := config.validate()
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error for rpm %d", test.rpm)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if config.RPM != test.wantRPM {
+				t.Fatalf("rpm = %d, want %d", config.RPM, test.wantRPM)
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// With content-addressed storage enabled, uploaded files are written once to
+// data/blobs under their SHA-256 and images rows reference them by blob_hash.
+// A blob's references are the images rows that carry its hash, so it is
+// removed once the last of them is deleted.
+
+// blobMu keeps a blob from being removed while an upload that references it
+// is between writing the blob and inserting its images row. Uploads hold the
+// read side from their first storeBlob until their rows are inserted;
+// releaseBlobs takes the write side.
+var blobMu sync.RWMutex
+
+// blobReadLock takes blobMu's read side the first time an upload stores a
+// blob, so fetching and decoding the files before it don't hold up deletes
+type blobReadLock struct {
+	held bool
+}
+
+func (l *blobReadLock) acquire() {
+	if !l.held {
+		blobMu.RLock()
+		l.held = true
+	}
+}
+
+func (l *blobReadLock) release() {
+	if l.held {
+		blobMu.RUnlock()
+		l.held = false
+	}
+}
+
+func blobPath(hash string) string {
+	return filepath.Join("data", "blobs", hash[:2], hash)
+}
+
+// imageFilePath returns where an image's bytes live on disk
+func imageFilePath(image *Image) string {
+	if image.BlobHash != "" {
+		return blobPath(image.BlobHash)
+	}
+	return filepath.Join("data", "projects", image.ProjectID, image.Path)
+}
+
+// hashContent returns the hex SHA-256 of a file's bytes
+func hashContent(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// storeBlob writes content under its SHA-256 unless an identical blob already
+// exists, and returns the hash
+func storeBlob(content []byte) (string, error) {
+	hash := hashContent(content)
+	path := blobPath(hash)
+
+	if _, err := os.Stat(path); err == nil {
+		return hash, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", fmt.Errorf("failed to create blob directory: %v", err)
+	}
+	// Write to a temp file first so a crash never leaves a truncated blob behind
+	tmpFile, err := os.CreateTemp(filepath.Dir(path), ".blob-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create blob file: %v", err)
+	}
+	if _, err := tmpFile.Write(content); err != nil {
+		tmpFile.Close()
+		os.Remove(tmpFile.Name())
+		return "", fmt.Errorf("failed to write blob: %v", err)
+	}
+	if err := tmpFile.Close(); err != nil {
+		os.Remove(tmpFile.Name())
+		return "", fmt.Errorf("failed to write blob: %v", err)
+	}
+	if err := os.Rename(tmpFile.Name(), path); err != nil {
+		os.Remove(tmpFile.Name())
+		return "", fmt.Errorf("failed to store blob: %v", err)
+	}
+	return hash, nil
+}
+
+// releaseBlobs removes each blob that no images row references any more.
+// Call it after the rows referencing the blobs have been deleted.
+func releaseBlobs(hashes []string) {
+	if len(hashes) == 0 {
+		return
+	}
+
+	blobMu.Lock()
+	defer blobMu.Unlock()
+
+	for _, hash := range hashes {
+		refs, err := countImagesByBlobHash(hash)
+		if err != nil {
+			logger.Error("Failed to count blob references", "error", err, "blob_hash", hash)
+			continue
+		}
+		if refs > 0 {
+			continue
+		}
+		if err := os.Remove(blobPath(hash)); err != nil && !os.IsNotExist(err) {
+			logger.Error("Failed to remove unreferenced blob", "error", err, "blob_hash", hash)
+		}
+	}
+}
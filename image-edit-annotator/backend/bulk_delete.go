@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// BulkDeleteResult reports the outcome of deleting one project in a bulk job
+type BulkDeleteResult struct {
+	ProjectID string `json:"projectId"`
+	Status    string `json:"status"` // "deleted" or "failed"
+	Error     string `json:"error,omitempty"`
+}
+
+// BulkDeleteJob tracks a background bulk project deletion. Finished jobs are
+// dropped once BulkDeleteJobTTL has passed since they completed.
+type BulkDeleteJob struct {
+	JobID       string             `json:"jobId"`
+	Status      string             `json:"status"` // "processing" or "completed"
+	Progress    int                `json:"progress"`
+	Total       int                `json:"total"`
+	Results     []BulkDeleteResult `json:"results"`
+	StartedAt   time.Time          `json:"startedAt"`
+	CompletedAt *time.Time         `json:"completedAt,omitempty"`
+}
+
+var (
+	bulkDeleteJobs            = make(map[string]*BulkDeleteJob)
+	bulkDeleteJobsMu          sync.RWMutex
+	bulkDeleteProgressClients = make(map[string]chan BulkDeleteJob)
+	bulkDeleteProgressMu      sync.RWMutex
+)
+
+// getBulkDeleteJob returns a copy of the job taken under the lock, so it can be encoded safely
+func getBulkDeleteJob(jobID string) *BulkDeleteJob {
+	bulkDeleteJobsMu.RLock()
+	defer bulkDeleteJobsMu.RUnlock()
+	job, exists := bulkDeleteJobs[jobID]
+	if !exists {
+		return nil
+	}
+	snapshot := *job
+	snapshot.Results = append([]BulkDeleteResult(nil), job.Results...)
+	return &snapshot
+}
+
+func sendBulkDeleteProgress(jobID string) {
+	job := getBulkDeleteJob(jobID)
+	if job == nil {
+		return
+	}
+
+	// Send under the lock so the handler can't drop the channel mid-send
+	bulkDeleteProgressMu.RLock()
+	defer bulkDeleteProgressMu.RUnlock()
+	if client, exists := bulkDeleteProgressClients[jobID]; exists {
+		select {
+		case client <- *job:
+		default:
+			// Client channel is full, skip this update
+		}
+	}
+}
+
+func bulkDeleteProjectsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var projectIDs []string
+	if err := json.NewDecoder(r.Body).Decode(&projectIDs); err != nil {
+		writeError(w, r, "Request body must be an array of project IDs", http.StatusBadRequest)
+		return
+	}
+	if len(projectIDs) == 0 {
+		writeError(w, r, "At least one project ID is required", http.StatusBadRequest)
+		return
+	}
+
+	job := &BulkDeleteJob{
+		JobID:     uuid.New().String(),
+		Status:    "processing",
+		Total:     len(projectIDs),
+		Results:   []BulkDeleteResult{},
+		StartedAt: time.Now(),
+	}
+	bulkDeleteJobsMu.Lock()
+	bulkDeleteJobs[job.JobID] = job
+	bulkDeleteJobsMu.Unlock()
+
+	logInfo(r.Context(), "Starting bulk project deletion",
+		slog.String("job_id", job.JobID),
+		slog.Int("project_count", len(projectIDs)))
+
+	// Keep the request ID for audit entries once the request has finished
+	go processBulkDelete(context.WithoutCancel(r.Context()), job.JobID, projectIDs)
+
+	writeJSON(w, r, http.StatusAccepted, map[string]interface{}{
+		"message": "Deletion started",
+		"jobId":   job.JobID,
+		"total":   job.Total,
+	})
+}
+
+func processBulkDelete(ctx context.Context, jobID string, projectIDs []string) {
+	for _, projectID := range projectIDs {
+		result := deleteProjectWithFiles(ctx, projectID)
+
+		bulkDeleteJobsMu.Lock()
+		job := bulkDeleteJobs[jobID]
+		job.Results = append(job.Results, result)
+		job.Progress++
+		if job.Progress == job.Total {
+			job.Status = "completed"
+			completedAt := time.Now()
+			job.CompletedAt = &completedAt
+		}
+		bulkDeleteJobsMu.Unlock()
+
+		sendBulkDeleteProgress(jobID)
+	}
+
+	logger.Info("Bulk project deletion completed", "job_id", jobID, "project_count", len(projectIDs))
+}
+
+// sweepBulkDeleteJobs forgets jobs that completed more than ttl ago. Jobs still
+// processing are kept however long they run.
+func sweepBulkDeleteJobs(ttl time.Duration) {
+	cutoff := time.Now().Add(-ttl)
+	removed := 0
+	bulkDeleteJobsMu.Lock()
+	for jobID, job := range bulkDeleteJobs {
+		if job.CompletedAt != nil && job.CompletedAt.Before(cutoff) {
+			delete(bulkDeleteJobs, jobID)
+			removed++
+		}
+	}
+	bulkDeleteJobsMu.Unlock()
+
+	if removed > 0 {
+		logger.Info("Removed expired bulk delete jobs", "count", removed)
+	}
+}
+
+// startBulkDeleteJobSweeper runs sweepBulkDeleteJobs in the background,
+// checking several times per TTL so jobs don't outlive it by much
+func startBulkDeleteJobSweeper(ttl time.Duration) {
+	interval := min(ttl/4, 10*time.Minute)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			<-ticker.C
+			sweepBulkDeleteJobs(ttl)
+		}
+	}()
+}
+
+// deleteProjectWithFiles removes a project's rows and its data directory
+func deleteProjectWithFiles(ctx context.Context, projectID string) BulkDeleteResult {
+	result := BulkDeleteResult{ProjectID: projectID, Status: "failed"}
+
+	project, err := getProject(projectID)
+	if err != nil {
+		result.Error = fmt.Sprintf("failed to get project: %v", err)
+		return result
+	}
+	if project == nil {
+		result.Error = "project not found"
+		return result
+	}
+
+	// Stop any captioning still writing to this project; errors just mean none was running
+	autoCaptionManager.CancelAutoCaptioning(projectID)
+
+	blobHashes, err := getProjectBlobHashes(projectID)
+	if err != nil {
+		result.Error = fmt.Sprintf("failed to get project blobs: %v", err)
+		return result
+	}
+
+	if err := deleteProject(projectID); err != nil {
+		result.Error = fmt.Sprintf("failed to delete project: %v", err)
+		logError(ctx, "Failed to delete project in bulk job", err, slog.String("project_id", projectID))
+		return result
+	}
+	releaseBlobs(blobHashes)
+	recordAudit(ctx, projectID, "delete", "project", projectID, project, nil)
+	invalidateProjectStats(projectID)
+
+	if err := os.RemoveAll(filepath.Join("data", "projects", projectID)); err != nil {
+		result.Error = fmt.Sprintf("project deleted but failed to remove files: %v", err)
+		logError(ctx, "Failed to remove project directory", err, slog.String("project_id", projectID))
+		return result
+	}
+
+	result.Status = "deleted"
+	return result
+}
+
+func getBulkDeleteJobHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	jobID := strings.TrimPrefix(r.URL.Path, "/projects/delete/")
+	job := getBulkDeleteJob(jobID)
+	if job == nil {
+		writeError(w, r, "Job not found", http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, job)
+}
+
+func bulkDeleteProgressHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	jobID := r.URL.Query().Get("jobId")
+	if jobID == "" {
+		writeError(w, r, "Job ID is required", http.StatusBadRequest)
+		return
+	}
+
+	// Set headers for SSE
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	// Create progress channel for this client
+	progressCh := make(chan BulkDeleteJob, 100)
+	bulkDeleteProgressMu.Lock()
+	bulkDeleteProgressClients[jobID] = progressCh
+	bulkDeleteProgressMu.Unlock()
+
+	// Clean up when client disconnects. The channel is left open: a send
+	// that raced the disconnect lands in its buffer instead of panicking.
+	defer func() {
+		bulkDeleteProgressMu.Lock()
+		if bulkDeleteProgressClients[jobID] == progressCh {
+			delete(bulkDeleteProgressClients, jobID)
+		}
+		bulkDeleteProgressMu.Unlock()
+	}()
+
+	// Start with the job's current state, so a client connecting after the
+	// last update (or after the job finished) isn't left waiting
+	if job := getBulkDeleteJob(jobID); job != nil {
+		progressCh <- *job
+	}
+
+	// Send events to client
+	for {
+		select {
+		case update := <-progressCh:
+			data, _ := json.Marshal(update)
+			fmt.Fprintf(w, "data: %s\n\n", data)
+			w.(http.Flusher).Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSweepBulkDeleteJobsEvictsOnlyExpiredFinishedJobs(t *testing.T) {
+	now := time.Now()
+	expired := now.Add(-2 * time.Hour)
+	recent := now.Add(-time.Minute)
+
+	bulkDeleteJobsMu.Lock()
+	previous := bulkDeleteJobs
+	bulkDeleteJobs = map[string]*BulkDeleteJob{
+		"expired":  {JobID: "expired", Status: "completed", StartedAt: expired, CompletedAt: &expired},
+		"recent":   {JobID: "recent", Status: "completed", StartedAt: recent, CompletedAt: &recent},
+		"long-run": {JobID: "long-run", Status: "processing", StartedAt: now.Add(-3 * time.Hour)},
+	}
+	bulkDeleteJobsMu.Unlock()
+	t.Cleanup(func() {
+		bulkDeleteJobsMu.Lock()
+		bulkDeleteJobs = previous
+		bulkDeleteJobsMu.Unlock()
+	})
+
+	sweepBulkDeleteJobs(time.Hour)
+
+	if getBulkDeleteJob("expired") != nil {
+		t.Error("expired job was kept")
+	}
+	if getBulkDeleteJob("recent") == nil {
+		t.Error("recently finished job was evicted")
+	}
+	if getBulkDeleteJob("long-run") == nil {
+		t.Error("job still processing was evicted")
+	}
+}
+
+func TestBulkDeleteProgressSendsCurrentStateAndSurvivesDisconnect(t *testing.T) {
+	bulkDeleteJobsMu.Lock()
+	previous := bulkDeleteJobs
+	bulkDeleteJobs = map[string]*BulkDeleteJob{
+		"job": {JobID: "job", Status: "processing", Progress: 2, Total: 5, StartedAt: time.Now()},
+	}
+	bulkDeleteJobsMu.Unlock()
+	t.Cleanup(func() {
+		bulkDeleteJobsMu.Lock()
+		bulkDeleteJobs = previous
+		bulkDeleteJobsMu.Unlock()
+	})
+
+	server := httptest.NewServer(http.HandlerFunc(bulkDeleteProgressHandler))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"?jobId=job", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	// The job's state arrives without waiting for its next update
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	var job BulkDeleteJob
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(strings.TrimSpace(line), "data: ")), &job); err != nil {
+		t.Fatalf("decode %q: %v", line, err)
+	}
+	if job.Progress != 2 || job.Total != 5 {
+		t.Errorf("first event = %+v, want progress 2 of 5", job)
+	}
+
+	// Updates sent while the client goes away must not panic
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			sendBulkDeleteProgress("job")
+		}
+	}()
+	cancel()
+	<-done
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		bulkDeleteProgressMu.RLock()
+		_, connected := bulkDeleteProgressClients["job"]
+		bulkDeleteProgressMu.RUnlock()
+		if !connected {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("client was not removed after disconnecting")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	sendBulkDeleteProgress("job")
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"sort"
+)
+
+// Candidate selection strategies for task generation
+const (
+	candidateStrategyNearest = "nearest" // The maxCandidates closest matches
+	candidateStrategyDiverse = "diverse" // Matches spread across the distance range
+)
+
+func validateCandidateStrategy(strategy string) error {
+	switch strategy {
+	case candidateStrategyNearest, candidateStrategyDiverse:
+		return nil
+	}
+	return fmt.Errorf("candidateStrategy must be %q or %q", candidateStrategyNearest, candidateStrategyDiverse)
+}
+
+// clampMaxCandidates caps a requested maxCandidates at MAX_STORED_CANDIDATES,
+// since every task stores a row per candidate
+func clampMaxCandidates(ctx context.Context, projectID string, maxCandidates int) int {
+	if maxCandidates <= appConfig.MaxStoredCandidates {
+		return maxCandidates
+	}
+	logWarn(ctx, "Clamped maxCandidates to the server ceiling",
+		slog.String("project_id", projectID),
+		slog.Int("requested", maxCandidates),
+		slog.Int("max_stored_candidates", appConfig.MaxStoredCandidates))
+	return appConfig.MaxStoredCandidates
+}
+
+// selectCandidates picks at most maxCandidates of candidates, which must be
+// sorted by distance. "nearest" keeps the closest ones. "diverse" spaces
+// maxCandidates target distances evenly from the closest match's distance up
+// to threshold and, for each target in turn, takes the unused candidate whose
+// distance is nearest to it (the closer one on a tie). The result stays
+// sorted by distance.
+func selectCandidates(candidates []SimilarImage, maxCandidates, threshold int, strategy string) []SimilarImage {
+	if len(candidates) <= maxCandidates {
+		return candidates
+	}
+	if strategy != candidateStrategyDiverse || maxCandidates <= 1 {
+		return candidates[:maxCandidates]
+	}
+
+	low := float64(candidates[0].Distance)
+	step := (float64(threshold) - low) / float64(maxCandidates-1)
+	used := make([]bool, len(candidates))
+	var picked []int
+	for k := 0; k < maxCandidates; k++ {
+		target := low + step*float64(k)
+		best := -1
+		for i, candidate := range candidates {
+			if used[i] {
+				continue
+			}
+			gap := math.Abs(float64(candidate.Distance) - target)
+			if best == -1 || gap < math.Abs(float64(candidates[best].Distance)-target) {
+				best = i
+			}
+		}
+		used[best] = true
+		picked = append(picked, best)
+	}
+
+	sort.Ints(picked)
+	selected := make([]SimilarImage, 0, len(picked))
+	for _, i := range picked {
+		selected = append(selected, candidates[i])
+	}
+	return selected
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const redactedValue = "REDACTED"
+
+// maxLoggedStringLength is the longest JSON string logged verbatim. Longer
+// ones are almost always base64 image data and are replaced by their length.
+const maxLoggedStringLength = 2048
+
+// credentialQueryParams and credentialHeaders carry API keys in provider requests
+var (
+	credentialQueryParams = []string{"key", "api_key"}
+	credentialHeaders     = []string{"Authorization", "X-Goog-Api-Key", "X-Api-Key"}
+)
+
+// redactURL hides credentials passed as query parameters
+func redactURL(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return redactedValue
+	}
+	query := parsed.Query()
+	for _, name := range credentialQueryParams {
+		if query.Has(name) {
+			query.Set(name, redactedValue)
+		}
+	}
+	parsed.RawQuery = query.Encode()
+	return parsed.String()
+}
+
+// sensitiveHeaderWords mark custom headers, such as a proxy's auth header,
+// whose values are hidden like the known credential headers
+var sensitiveHeaderWords = []string{"auth", "key", "token", "secret", "cookie", "password", "session"}
+
+// redactHeaders returns a copy of header with credentials hidden
+func redactHeaders(header http.Header) http.Header {
+	redacted := header.Clone()
+	for _, name := range credentialHeaders {
+		if redacted.Get(name) != "" {
+			redacted.Set(name, redactedValue)
+		}
+	}
+	for name := range redacted {
+		lower := strings.ToLower(name)
+		for _, word := range sensitiveHeaderWords {
+			if strings.Contains(lower, word) {
+				redacted.Set(name, redactedValue)
+				break
+			}
+		}
+	}
+	return redacted
+}
+
+// redactSecret removes secret from text, such as a transport error that
+// quotes the request URL
+func redactSecret(text, secret string) string {
+	if secret == "" {
+		return text
+	}
+	return strings.ReplaceAll(text, secret, redactedValue)
+}
+
+// summarizeBody returns a JSON body for logging with long strings elided.
+// Bodies that aren't JSON are logged as text, cut to maxLoggedStringLength.
+func summarizeBody(body []byte) string {
+	var value interface{}
+	if err := json.Unmarshal(body, &value); err != nil {
+		if len(body) > maxLoggedStringLength {
+			return fmt.Sprintf("%s... (%d bytes)", body[:maxLoggedStringLength], len(body))
+		}
+		return string(body)
+	}
+	summarized, err := json.Marshal(elideLongStrings(value))
+	if err != nil {
+		return fmt.Sprintf("<%d bytes>", len(body))
+	}
+	return string(summarized)
+}
+
+func elideLongStrings(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		if len(v) > maxLoggedStringLength {
+			return fmt.Sprintf("<%d characters elided>", len(v))
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = elideLongStrings(v[i])
+		}
+	case map[string]interface{}:
+		for key := range v {
+			v[key] = elideLongStrings(v[key])
+		}
+	}
+	return value
+}
+
+// logProviderExchange records one caption provider call at DEBUG level when
+// CAPTION_DEBUG is set. status is 0 and responseBody nil when no response
+// arrived. secret is scrubbed from everything logged.
+func logProviderExchange(provider, requestURL string, header http.Header, requestBody []byte, status int, responseBody []byte, elapsed time.Duration, secret string) {
+	if !appConfig.CaptionDebug {
+		return
+	}
+
+	args := []any{
+		"provider", provider,
+		"url", redactURL(requestURL),
+		"headers", redactHeaders(header),
+		"request_body", redactSecret(summarizeBody(requestBody), secret),
+		"duration_ms", elapsed.Milliseconds(),
+	}
+	if status != 0 {
+		args = append(args,
+			"status", status,
+			"response_body", redactSecret(summarizeBody(responseBody), secret))
+	}
+	logger.Debug("Caption provider exchange", args...)
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"archive/zip"
+	"database/sql"
+	"io"
+	"log/slog"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// maxCaptionFileBytes bounds each .txt read from an import archive
+const maxCaptionFileBytes = 1 << 20
+
+// CaptionImportResult summarises a sidecar caption import
+type CaptionImportResult struct {
+	Created   int      `json:"created"`
+	Updated   int      `json:"updated"`
+	Unmatched []string `json:"unmatched"` // .txt files with no image of the same basename
+	Ambiguous []string `json:"ambiguous"` // .txt files matching more than one image, e.g. a.jpg and a.png
+	Skipped   []string `json:"skipped"`   // Empty or unreadable .txt files
+}
+
+// importCaptionsHandler reads a ZIP of image.txt sidecar files, as written by
+// the ai-toolkit export, and stores each as the reviewed caption of the image
+// with the same basename
+func importCaptionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	projectID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/projects/"), "/import/captions")
+	if projectID == "" {
+		writeError(w, r, "Project ID is required", http.StatusBadRequest)
+		return
+	}
+
+	project, err := getProject(projectID)
+	if err != nil {
+		writeError(w, r, "Failed to get project", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to get project for caption import", err, slog.String("project_id", projectID))
+		return
+	}
+	if project == nil {
+		writeError(w, r, "Project not found", http.StatusNotFound)
+		return
+	}
+	if project.ProjectType != "caption" {
+		writeError(w, r, "Captions can only be imported into caption projects", http.StatusBadRequest)
+		return
+	}
+
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		writeError(w, r, "Error parsing multipart form", http.StatusBadRequest)
+		return
+	}
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		writeError(w, r, "A ZIP file is required in the \"file\" field", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	archive, err := zip.NewReader(file, header.Size)
+	if err != nil {
+		writeError(w, r, "File is not a valid ZIP archive", http.StatusBadRequest)
+		return
+	}
+
+	images, err := getImagesByProjectID(projectID)
+	if err != nil {
+		writeError(w, r, "Failed to get images", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to get images for caption import", err, slog.String("project_id", projectID))
+		return
+	}
+	imagesByBase := make(map[string][]Image)
+	for _, image := range images {
+		base := filepath.Base(image.Path)
+		base = strings.TrimSuffix(base, filepath.Ext(base))
+		imagesByBase[base] = append(imagesByBase[base], image)
+	}
+
+	tasks, err := getCaptionTasksByProjectID(projectID)
+	if err != nil {
+		writeError(w, r, "Failed to get caption tasks", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to get caption tasks for import", err, slog.String("project_id", projectID))
+		return
+	}
+	tasksByImage := make(map[string]CaptionTask, len(tasks))
+	for _, task := range tasks {
+		tasksByImage[task.ImageID] = task
+	}
+
+	result := CaptionImportResult{Unmatched: []string{}, Ambiguous: []string{}, Skipped: []string{}}
+	for _, entry := range archive.File {
+		// Archives made on macOS carry resource forks under __MACOSX
+		if entry.FileInfo().IsDir() || strings.HasPrefix(entry.Name, "__MACOSX/") ||
+			!strings.EqualFold(path.Ext(entry.Name), ".txt") {
+			continue
+		}
+
+		base := strings.TrimSuffix(path.Base(entry.Name), path.Ext(entry.Name))
+		matches := imagesByBase[base]
+		if len(matches) == 0 {
+			result.Unmatched = append(result.Unmatched, entry.Name)
+			continue
+		}
+		if len(matches) > 1 {
+			result.Ambiguous = append(result.Ambiguous, entry.Name)
+			continue
+		}
+
+		caption, err := readCaptionFile(entry)
+		if err != nil || caption == "" {
+			result.Skipped = append(result.Skipped, entry.Name)
+			continue
+		}
+
+		image := matches[0]
+		if existing, exists := tasksByImage[image.ID]; exists {
+			updated := existing
+			updated.Caption = sql.NullString{String: caption, Valid: true}
+			updated.Status = "reviewed"
+			if err := updateCaptionTask(&updated, "import"); err != nil {
+				writeError(w, r, "Failed to update caption task", http.StatusInternalServerError)
+				logError(r.Context(), "Failed to update caption task from import", err, slog.String("task_id", existing.ID))
+				return
+			}
+			recordAudit(r.Context(), projectID, "update", "caption_task", existing.ID, &existing, &updated)
+			tasksByImage[image.ID] = updated
+			result.Updated++
+			continue
+		}
+
+		task := CaptionTask{
+			ID:        uuid.New().String(),
+			ProjectID: projectID,
+			ImageID:   image.ID,
+			Caption:   sql.NullString{String: caption, Valid: true},
+			Status:    "reviewed",
+		}
+		if err := createCaptionTask(&task); err != nil {
+			writeError(w, r, "Failed to create caption task", http.StatusInternalServerError)
+			logError(r.Context(), "Failed to create caption task from import", err, slog.String("image_id", image.ID))
+			return
+		}
+		recordAudit(r.Context(), projectID, "create", "caption_task", task.ID, nil, &task)
+		tasksByImage[image.ID] = task
+		result.Created++
+	}
+	invalidateProjectStats(projectID)
+
+	logInfo(r.Context(), "Captions imported",
+		slog.String("project_id", projectID),
+		slog.Int("created", result.Created),
+		slog.Int("updated", result.Updated),
+		slog.Int("unmatched", len(result.Unmatched)))
+
+	writeJSON(w, r, http.StatusOK, result)
+}
+
+func readCaptionFile(entry *zip.File) (string, error) {
+	reader, err := entry.Open()
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+
+	content, err := io.ReadAll(io.LimitReader(reader, maxCaptionFileBytes))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(content)), nil
+}
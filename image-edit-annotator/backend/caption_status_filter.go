@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// captionTaskStatuses are the values of caption_tasks.status
+var captionTaskStatuses = []string{"pending", "auto_generated", "reviewed", "completed"}
+
+// parseCaptionStatusFilter reads the comma-separated status query parameter
+// of caption exports, e.g. status=reviewed,completed to leave out captions
+// nobody has checked. It returns nil when the parameter is absent, and
+// exports then include every captioned task whatever its status.
+func parseCaptionStatusFilter(r *http.Request) (map[string]bool, error) {
+	value := r.URL.Query().Get("status")
+	if value == "" {
+		return nil, nil
+	}
+
+	statuses := make(map[string]bool)
+	for _, part := range strings.Split(value, ",") {
+		status := strings.TrimSpace(part)
+		valid := false
+		for _, known := range captionTaskStatuses {
+			valid = valid || status == known
+		}
+		if !valid {
+			return nil, fmt.Errorf("Unknown caption status %q; use %s", status, strings.Join(captionTaskStatuses, ", "))
+		}
+		statuses[status] = true
+	}
+	return statuses, nil
+}
+
+// Orders in which caption exports list their tasks
+const (
+	captionExportOrderCreated = "created" // When each task was created, then task ID
+	captionExportOrderPath    = "path"    // Image path, then task ID, so exports of two dataset versions diff cleanly
+)
+
+// parseCaptionExportOrder reads the order query parameter of caption exports,
+// defaulting to creation order
+func parseCaptionExportOrder(r *http.Request) (string, error) {
+	switch order := r.URL.Query().Get("order"); order {
+	case "":
+		return captionExportOrderCreated, nil
+	case captionExportOrderCreated, captionExportOrderPath:
+		return order, nil
+	default:
+		return "", fmt.Errorf("order must be %q or %q", captionExportOrderCreated, captionExportOrderPath)
+	}
+}
@@ -2,25 +2,76 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"database/sql"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
-	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
 )
 
+const defaultSystemPrompt = "Describe this image in detail for training a diffusion model. Focus on the visual elements, composition, style, and any notable features."
+
+// defaultProviderRequestTimeout bounds each caption provider call when the
+// project's caption API config doesn't set requestTimeoutMs
+const defaultProviderRequestTimeout = 60 * time.Second
+
+// errProviderTimeout wraps provider calls that ran past their deadline, which
+// auto captioning retries like any other transient failure
+var errProviderTimeout = errors.New("caption provider request timed out")
+
+// appVersion identifies this build in the User-Agent sent to caption
+// providers; release builds set it with -ldflags "-X main.appVersion=..."
+var appVersion = "dev"
+
+// captionUserAgent is sent with provider calls unless the caption API
+// config's headers override it
+func captionUserAgent() string {
+	return "simple-dataset-tools-image-edit-annotator/" + appVersion + " (+https://github.com/felixowens/simple-dataset-tools)"
+}
+
+// reservedProviderHeaders are set from the request itself and can't be
+// overridden through the caption API config's headers
+var reservedProviderHeaders = map[string]bool{
+	"Content-Type":   true,
+	"Content-Length": true,
+	"Host":           true,
+}
+
+const defaultEditPromptSystemPrompt = "The first image is the source and the second image is the result of editing it. Write a single concise instruction, in the imperative, that would turn the source image into the result. Respond with the instruction only."
+
 type CaptioningService interface {
 	GenerateCaption(imageBase64 string, systemPrompt string) (string, error)
+	// GenerateEditInstruction describes how to turn the source image into the target image
+	GenerateEditInstruction(sourceBase64, targetBase64 string, systemPrompt string) (string, error)
+	// GenerateCaptionCandidates returns one caption per sampling temperature, in the same order
+	GenerateCaptionCandidates(imageBase64 string, systemPrompt string, temperatures []float64) ([]string, error)
 }
 
 type GeminiService struct {
-	APIKey string
+	APIKey     string
+	Generation GeminiGenerationConfig // Sampling settings sent with every request
+	Timeout    time.Duration          // Deadline for each request; defaultProviderRequestTimeout when zero
+	Headers    map[string]string      // Extra request headers, applied after the defaults
 }
 
 type GeminiRequest struct {
-	Contents []GeminiContent `json:"contents"`
+	Contents         []GeminiContent         `json:"contents"`
+	GenerationConfig *GeminiGenerationConfig `json:"generationConfig,omitempty"`
+}
+
+// GeminiGenerationConfig holds Gemini's sampling settings. Unset fields are
+// omitted so the model's own defaults apply.
+type GeminiGenerationConfig struct {
+	Temperature     *float64 `json:"temperature,omitempty"`
+	TopP            *float64 `json:"topP,omitempty"`
+	MaxOutputTokens *int     `json:"maxOutputTokens,omitempty"`
 }
 
 type GeminiContent struct {
@@ -56,18 +107,62 @@ func NewGeminiService(apiKey string) *GeminiService {
 }
 
 func (g *GeminiService) GenerateCaption(imageBase64 string, systemPrompt string) (string, error) {
-	if g.APIKey == "" {
-		return "", fmt.Errorf("Gemini API key not configured")
+	// Default system prompt if none provided
+	if systemPrompt == "" {
+		systemPrompt = defaultSystemPrompt
 	}
 
-	// Default system prompt if none provided
+	return g.generate([]GeminiPart{
+		{
+			Text: systemPrompt,
+		},
+		geminiImagePart(imageBase64),
+	}, nil)
+}
+
+func (g *GeminiService) GenerateCaptionCandidates(imageBase64 string, systemPrompt string, temperatures []float64) ([]string, error) {
 	if systemPrompt == "" {
-		systemPrompt = "Describe this image in detail for training a diffusion model. Focus on the visual elements, composition, style, and any notable features."
+		systemPrompt = defaultSystemPrompt
+	}
+
+	parts := []GeminiPart{
+		{
+			Text: systemPrompt,
+		},
+		geminiImagePart(imageBase64),
 	}
 
+	// Gemini applies one temperature per request, so each candidate is its own call
+	captions := make([]string, 0, len(temperatures))
+	for _, temperature := range temperatures {
+		caption, err := g.generate(parts, &GeminiGenerationConfig{Temperature: &temperature})
+		if err != nil {
+			return nil, fmt.Errorf("temperature %.2f: %v", temperature, err)
+		}
+		captions = append(captions, caption)
+	}
+	return captions, nil
+}
+
+func (g *GeminiService) GenerateEditInstruction(sourceBase64, targetBase64 string, systemPrompt string) (string, error) {
+	if systemPrompt == "" {
+		systemPrompt = defaultEditPromptSystemPrompt
+	}
+
+	// Images are sent in source, target order, which the prompt refers to
+	return g.generate([]GeminiPart{
+		{
+			Text: systemPrompt,
+		},
+		geminiImagePart(sourceBase64),
+		geminiImagePart(targetBase64),
+	}, nil)
+}
+
+func geminiImagePart(imageBase64 string) GeminiPart {
 	// Determine MIME type based on base64 data
 	mimeType := "image/jpeg"
-	if len(imageBase64) > 0 {
+	if len(imageBase64) >= 4 {
 		// Simple detection based on base64 header
 		if imageBase64[:4] == "iVBO" { // PNG signature in base64
 			mimeType = "image/png"
@@ -76,23 +171,45 @@ func (g *GeminiService) GenerateCaption(imageBase64 string, systemPrompt string)
 		}
 	}
 
+	return GeminiPart{
+		InlineData: &GeminiInlineData{
+			MimeType: mimeType,
+			Data:     imageBase64,
+		},
+	}
+}
+
+// generate sends one prompt made of parts to Gemini and returns the text of the
+// first candidate. Settings in override (which may be nil) take precedence over
+// the service's own.
+func (g *GeminiService) generate(parts []GeminiPart, override *GeminiGenerationConfig) (string, error) {
+	if g.APIKey == "" {
+		return "", fmt.Errorf("Gemini API key not configured")
+	}
+
+	config := g.Generation
+	if override != nil {
+		if override.Temperature != nil {
+			config.Temperature = override.Temperature
+		}
+		if override.TopP != nil {
+			config.TopP = override.TopP
+		}
+		if override.MaxOutputTokens != nil {
+			config.MaxOutputTokens = override.MaxOutputTokens
+		}
+	}
+
 	request := GeminiRequest{
 		Contents: []GeminiContent{
 			{
-				Parts: []GeminiPart{
-					{
-						Text: systemPrompt,
-					},
-					{
-						InlineData: &GeminiInlineData{
-							MimeType: mimeType,
-							Data:     imageBase64,
-						},
-					},
-				},
+				Parts: parts,
 			},
 		},
 	}
+	if config != (GeminiGenerationConfig{}) {
+		request.GenerationConfig = &config
+	}
 
 	requestBody, err := json.Marshal(request)
 	if err != nil {
@@ -102,16 +219,44 @@ func (g *GeminiService) GenerateCaption(imageBase64 string, systemPrompt string)
 	// Gemini Vision API endpoint
 	url := fmt.Sprintf("https://generativelanguage.googleapis.com/v1beta/models/gemini-2.5-pro:generateContent?key=%s", g.APIKey)
 
-	resp, err := http.Post(url, "application/json", bytes.NewBuffer(requestBody))
+	timeout := g.Timeout
+	if timeout <= 0 {
+		timeout = defaultProviderRequestTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	header := http.Header{"User-Agent": {captionUserAgent()}}
+	for name, value := range g.Headers {
+		header.Set(name, value)
+	}
+	header.Set("Content-Type", "application/json")
+	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBuffer(requestBody))
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %s", redactSecret(err.Error(), g.APIKey))
+	}
+	httpRequest.Header = header.Clone()
+
+	start := time.Now()
+	resp, err := http.DefaultClient.Do(httpRequest)
 	if err != nil {
-		return "", fmt.Errorf("failed to call Gemini API: %v", err)
+		logProviderExchange("gemini", url, header, requestBody, 0, nil, time.Since(start), g.APIKey)
+		if errors.Is(err, context.DeadlineExceeded) {
+			return "", fmt.Errorf("%w after %v", errProviderTimeout, timeout)
+		}
+		// Transport errors quote the URL, which carries the key
+		return "", fmt.Errorf("failed to call Gemini API: %s", redactSecret(err.Error(), g.APIKey))
 	}
 	defer resp.Body.Close()
 
 	responseBody, err := io.ReadAll(resp.Body)
 	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return "", fmt.Errorf("%w after %v reading the response", errProviderTimeout, timeout)
+		}
 		return "", fmt.Errorf("failed to read response: %v", err)
 	}
+	logProviderExchange("gemini", url, header, requestBody, resp.StatusCode, responseBody, time.Since(start), g.APIKey)
 
 	if resp.StatusCode != http.StatusOK {
 		return "", fmt.Errorf("Gemini API error (status %d): %s", resp.StatusCode, string(responseBody))
@@ -127,25 +272,200 @@ func (g *GeminiService) GenerateCaption(imageBase64 string, systemPrompt string)
 	}
 
 	if len(geminiResponse.Candidates) == 0 || len(geminiResponse.Candidates[0].Content.Parts) == 0 {
-		return "", fmt.Errorf("no caption generated by Gemini API")
+		return "", fmt.Errorf("no text generated by Gemini API")
 	}
 
 	return geminiResponse.Candidates[0].Content.Parts[0].Text, nil
 }
 
+// validate checks the optional sampling settings against the ranges every
+// provider accepts
+func (config *CaptionAPIConfig) validate() error {
+	if config.Temperature != nil && (*config.Temperature < 0 || *config.Temperature > 2) {
+		return fmt.Errorf("temperature must be between 0 and 2")
+	}
+	if config.TopP != nil && (*config.TopP <= 0 || *config.TopP > 1) {
+		return fmt.Errorf("topP must be greater than 0 and at most 1")
+	}
+	if config.MaxTokens != nil && *config.MaxTokens <= 0 {
+		return fmt.Errorf("maxTokens must be positive")
+	}
+	if config.RequestTimeoutMs != nil && *config.RequestTimeoutMs <= 0 {
+		return fmt.Errorf("requestTimeoutMs must be positive")
+	}
+	for name, value := range config.Headers {
+		if !validHeaderName(name) {
+			return fmt.Errorf("headers: %q is not a valid header name", name)
+		}
+		if reservedProviderHeaders[http.CanonicalHeaderKey(name)] {
+			return fmt.Errorf("headers: %s is set automatically and can't be overridden", http.CanonicalHeaderKey(name))
+		}
+		if strings.ContainsAny(value, "\r\n") {
+			return fmt.Errorf("headers: value of %s must not contain line breaks", name)
+		}
+	}
+	return nil
+}
+
+// validHeaderName reports whether name is an HTTP token, the only form a
+// header name may take
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if c > 0x7e || c <= ' ' || strings.ContainsRune(`"(),/:;<=>?@[\]{}`, c) {
+			return false
+		}
+	}
+	return true
+}
+
 func CreateCaptioningService(config *CaptionAPIConfig) (CaptioningService, error) {
 	if config == nil {
 		return nil, fmt.Errorf("caption API configuration is required")
 	}
 
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+
 	switch config.Provider {
 	case "gemini":
-		return NewGeminiService(config.APIKey), nil
+		service := NewGeminiService(config.APIKey)
+		// Gemini 2.5 counts thinking towards maxOutputTokens, so no token cap
+		// is applied unless one is configured
+		service.Generation = GeminiGenerationConfig{
+			Temperature:     config.Temperature,
+			TopP:            config.TopP,
+			MaxOutputTokens: config.MaxTokens,
+		}
+		if config.RequestTimeoutMs != nil {
+			service.Timeout = time.Duration(*config.RequestTimeoutMs) * time.Millisecond
+		}
+		service.Headers = config.Headers
+		return service, nil
 	default:
 		return nil, fmt.Errorf("unsupported caption API provider: %s", config.Provider)
 	}
 }
 
+// captionTemplatePlaceholders are the names a project's caption template may use
+var captionTemplatePlaceholders = map[string]bool{
+	"caption": true, // The generated caption
+	"trigger": true, // The project's trigger word
+	"project": true, // The project name
+}
+
+var captionTemplatePlaceholderPattern = regexp.MustCompile(`\{([^{}]*)\}`)
+
+// validateCaptionTemplate checks that a template includes {caption} and uses
+// no unknown placeholders
+func validateCaptionTemplate(template string) error {
+	hasCaption := false
+	for _, match := range captionTemplatePlaceholderPattern.FindAllStringSubmatch(template, -1) {
+		if !captionTemplatePlaceholders[match[1]] {
+			return fmt.Errorf("unknown placeholder {%s}; use {caption}, {trigger} or {project}", match[1])
+		}
+		hasCaption = hasCaption || match[1] == "caption"
+	}
+	if !hasCaption {
+		return fmt.Errorf("must include {caption}")
+	}
+	return nil
+}
+
+const maxCaptionStripPatterns = 50
+
+// validateCaptionStripPatterns checks that each pattern is a non-empty regular
+// expression that compiles
+func validateCaptionStripPatterns(patterns []string) error {
+	if len(patterns) > maxCaptionStripPatterns {
+		return fmt.Errorf("at most %d patterns are allowed", maxCaptionStripPatterns)
+	}
+	for i, pattern := range patterns {
+		if pattern == "" {
+			return fmt.Errorf("pattern %d is empty", i)
+		}
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("pattern %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// stripCaption removes every match of the project's caption strip patterns from
+// a generated caption, in order, and trims the whitespace left behind. A caption
+// the patterns would empty is kept as generated.
+func stripCaption(project *Project, caption string) string {
+	if len(project.CaptionStripPatterns) == 0 {
+		return caption
+	}
+
+	stripped := caption
+	for _, pattern := range project.CaptionStripPatterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			// Patterns are validated when the project is saved
+			logger.Warn("Skipping invalid caption strip pattern", "project_id", project.ID, "pattern", pattern, "error", err)
+			continue
+		}
+		if matches := re.FindAllString(stripped, -1); len(matches) > 0 {
+			logger.Info("Stripped text from generated caption",
+				"project_id", project.ID,
+				"pattern", pattern,
+				"removed", matches,
+			)
+			stripped = re.ReplaceAllString(stripped, "")
+		}
+	}
+	stripped = strings.TrimSpace(stripped)
+	if stripped == "" && strings.TrimSpace(caption) != "" {
+		logger.Warn("Caption strip patterns removed the whole caption; keeping it unstripped", "project_id", project.ID)
+		return caption
+	}
+	return stripped
+}
+
+// applyCaptionTemplate wraps a generated caption in the project's caption
+// template, if it has one. Spaces and commas left at either end by an empty
+// trigger word are trimmed.
+func applyCaptionTemplate(project *Project, caption string) string {
+	if project.CaptionTemplate == nil || strings.TrimSpace(*project.CaptionTemplate) == "" {
+		return caption
+	}
+
+	values := map[string]string{
+		"caption": strings.TrimSpace(caption),
+		"project": project.Name,
+	}
+	if project.TriggerWord != nil {
+		values["trigger"] = strings.TrimSpace(*project.TriggerWord)
+	}
+	result := captionTemplatePlaceholderPattern.ReplaceAllStringFunc(*project.CaptionTemplate, func(placeholder string) string {
+		return values[placeholder[1:len(placeholder)-1]]
+	})
+	return strings.Trim(result, " ,")
+}
+
+// buildSystemPrompt returns the project's system prompt (or the default) with
+// an instruction to answer in the project's caption language, if one is set.
+// Gemini has no locale parameter, so the language is requested in the prompt.
+func buildSystemPrompt(project *Project) string {
+	systemPrompt := defaultSystemPrompt
+	if project.SystemPrompt != nil && *project.SystemPrompt != "" {
+		systemPrompt = *project.SystemPrompt
+	}
+
+	if project.CaptionLanguage != nil {
+		if language := strings.TrimSpace(*project.CaptionLanguage); language != "" {
+			systemPrompt = fmt.Sprintf("%s\n\nRespond in %s.", systemPrompt, language)
+		}
+	}
+
+	return systemPrompt
+}
+
 func ImageToBase64(imagePath string) (string, error) {
 	imageFile, err := os.Open(imagePath)
 	if err != nil {
@@ -201,7 +521,7 @@ func GenerateCaptionForTask(projectID, taskID string) (*CaptionResponse, error)
 	}
 
 	// Convert image to base64
-	imagePath := filepath.Join("data", "projects", projectID, image.Path)
+	imagePath := imageFilePath(image)
 	imageBase64, err := ImageToBase64(imagePath)
 	if err != nil {
 		return &CaptionResponse{Error: fmt.Sprintf("Failed to encode image: %v", err)}, nil
@@ -214,10 +534,7 @@ func GenerateCaptionForTask(projectID, taskID string) (*CaptionResponse, error)
 	}
 
 	// Use system prompt from project or default
-	systemPrompt := "Describe this image in detail for training a diffusion model. Focus on the visual elements, composition, style, and any notable features."
-	if project.SystemPrompt != nil && *project.SystemPrompt != "" {
-		systemPrompt = *project.SystemPrompt
-	}
+	systemPrompt := buildSystemPrompt(project)
 
 	// Generate caption
 	caption, err := captioningService.GenerateCaption(imageBase64, systemPrompt)
@@ -227,14 +544,158 @@ func GenerateCaptionForTask(projectID, taskID string) (*CaptionResponse, error)
 	}
 
 	// Update the task with the generated caption and set status to auto_generated
-	task.Caption.String = caption
+	task.Caption.String = applyCaptionTemplate(project, stripCaption(project, caption))
 	task.Caption.Valid = true
 	task.Status = "auto_generated"
 	
-	if err := updateCaptionTask(task); err != nil {
+	if err := updateCaptionTask(task, "auto_generated"); err != nil {
 		logger.Error("Failed to update caption task with generated caption", "error", err)
 		return &CaptionResponse{Error: fmt.Sprintf("Failed to save generated caption: %v", err)}, nil
 	}
+	invalidateProjectStats(task.ProjectID)
+
+	// Respond with the caption as stored, so a client saving it back keeps the template
+	return &CaptionResponse{Caption: task.Caption.String}, nil
+}
+
+// GeneratePromptForTask asks the project's caption API for an edit instruction
+// describing how task's image A becomes its selected image B, and saves it as
+// the task prompt
+func GeneratePromptForTask(task *Task) (*Task, error) {
+	if !task.ImageBId.Valid || task.ImageBId.String == "" {
+		return nil, fmt.Errorf("task has no selected image B")
+	}
+
+	project, err := getProject(task.ProjectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get project: %v", err)
+	}
+	if project == nil {
+		return nil, fmt.Errorf("project not found")
+	}
+	if project.CaptionAPI == nil {
+		return nil, fmt.Errorf("caption API not configured for this project")
+	}
+
+	var apiConfig CaptionAPIConfig
+	if err := json.Unmarshal([]byte(*project.CaptionAPI), &apiConfig); err != nil {
+		return nil, fmt.Errorf("invalid caption API configuration: %v", err)
+	}
+
+	imageA, err := getImage(task.ImageAID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get image A: %v", err)
+	}
+	imageB, err := getImage(task.ImageBId.String)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get image B: %v", err)
+	}
+	if imageA == nil || imageB == nil {
+		return nil, fmt.Errorf("task image not found")
+	}
+
+	sourceBase64, err := ImageToBase64(imageFilePath(imageA))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode image A: %v", err)
+	}
+	targetBase64, err := ImageToBase64(imageFilePath(imageB))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode image B: %v", err)
+	}
+
+	captioningService, err := CreateCaptioningService(&apiConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create captioning service: %v", err)
+	}
+
+	systemPrompt := defaultEditPromptSystemPrompt
+	if project.EditPromptSystemPrompt != nil && strings.TrimSpace(*project.EditPromptSystemPrompt) != "" {
+		systemPrompt = *project.EditPromptSystemPrompt
+	}
+
+	instruction, err := captioningService.GenerateEditInstruction(sourceBase64, targetBase64, systemPrompt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate prompt: %v", err)
+	}
+
+	updated := *task
+	updated.Prompt = normalizePrompt(sql.NullString{String: instruction, Valid: true}, project)
+	if !updated.Prompt.Valid {
+		return nil, fmt.Errorf("caption API returned an empty prompt")
+	}
+	if err := updateTask(&updated); err != nil {
+		return nil, fmt.Errorf("failed to save generated prompt: %v", err)
+	}
+	invalidateProjectStats(task.ProjectID)
+
+	return &updated, nil
+}
+
+// captionCandidateTemperatures spreads n sampling temperatures evenly from
+// conservative to varied, so the candidates differ in more than wording
+func captionCandidateTemperatures(n int) []float64 {
+	const low, high = 0.4, 1.2
+	if n == 1 {
+		return []float64{low}
+	}
+	temperatures := make([]float64, n)
+	for i := range temperatures {
+		temperatures[i] = low + (high-low)*float64(i)/float64(n-1)
+	}
+	return temperatures
+}
+
+// GenerateCaptionCandidatesForTask generates one caption per temperature for
+// task and stores them as its candidates, replacing any earlier set. The
+// task's own caption is left alone until a candidate is selected.
+func GenerateCaptionCandidatesForTask(task *CaptionTask, temperatures []float64) ([]CaptionCandidate, error) {
+	project, err := getProject(task.ProjectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get project: %v", err)
+	}
+	if project == nil {
+		return nil, fmt.Errorf("project not found")
+	}
+	if project.CaptionAPI == nil {
+		return nil, fmt.Errorf("caption API not configured for this project")
+	}
+
+	var apiConfig CaptionAPIConfig
+	if err := json.Unmarshal([]byte(*project.CaptionAPI), &apiConfig); err != nil {
+		return nil, fmt.Errorf("invalid caption API configuration: %v", err)
+	}
+
+	image, err := getImage(task.ImageID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get image: %v", err)
+	}
+	if image == nil {
+		return nil, fmt.Errorf("image not found")
+	}
+
+	imageBase64, err := ImageToBase64(imageFilePath(image))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode image: %v", err)
+	}
+
+	captioningService, err := CreateCaptioningService(&apiConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create captioning service: %v", err)
+	}
+
+	captions, err := captioningService.GenerateCaptionCandidates(imageBase64, buildSystemPrompt(project), temperatures)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate caption candidates: %v", err)
+	}
+
+	candidates := make([]CaptionCandidate, len(captions))
+	for i, caption := range captions {
+		temperature := temperatures[i]
+		candidates[i] = CaptionCandidate{Caption: applyCaptionTemplate(project, stripCaption(project, caption)), Temperature: &temperature}
+	}
+	if err := replaceCaptionCandidates(task.ID, candidates); err != nil {
+		return nil, fmt.Errorf("failed to save caption candidates: %v", err)
+	}
 
-	return &CaptionResponse{Caption: caption}, nil
+	return getCaptionCandidates(task.ID)
 }
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds server settings that can be tuned through environment variables
+type Config struct {
+	ThumbConcurrency       int           // Maximum concurrent thumbnail generations
+	ThumbQueueTimeout      time.Duration // How long a thumbnail request may wait for a free slot
+	URLFetchTimeout        time.Duration // Timeout for each image fetched by URL upload
+	URLFetchMaxBytes       int64         // Largest image accepted by URL upload
+	GenerationWorkers      int           // Parallel similarity searches during task generation
+	MaxStoredCandidates    int           // Ceiling on candidates stored per task, whatever maxCandidates a request asks for
+	StatsCacheTTL          time.Duration // How long project stats are served from memory
+	MaxCaptionRPM          int           // Ceiling for auto-caption requests per minute
+	MaxAutoCaptionSessions int           // Auto-caption sessions that may be active at once across all projects
+	CaptionDebug           bool          // Log caption provider requests and responses, redacted, at DEBUG level (LOG_LEVEL=DEBUG)
+
+	ContentAddressedStorage bool     // Store uploads once per content hash under data/blobs
+	HashNormalizeSize       int      // Scale images to this square before computing their pHash; 0 hashes them at full size
+	UploadFailurePolicy     string   // When an upload's batch insert fails: "salvage" retries each image alone, "discard" stores none
+	AllowedImageFormats     []string // Decoded formats uploads may use, e.g. "png", "jpeg"; empty allows every compiled-in decoder
+
+	DBMaxOpenConns    int           // Connection pool size; SQLite allows one writer at a time regardless
+	DBMaxIdleConns    int           // Connections kept open while idle
+	DBConnMaxLifetime time.Duration // How long a connection is reused before being closed
+
+	ExportTokenSecret string        // Key that signs export tokens; a random one is used (and tokens lost on restart) when unset
+	ExportTokenMaxTTL time.Duration // Longest lifetime an export token may be issued with
+	ExportArtifactTTL time.Duration // How long background export archives are kept for download
+
+	TaskClaimTTL time.Duration // How long an annotator's claim on a task lasts without being renewed
+
+	BulkDeleteJobTTL time.Duration // How long a finished bulk delete job's results stay available
+
+	LogMaxSize    int64 // Rotate the JSON log file once it reaches this many bytes; 0 keeps one file, wiped on restart
+	LogMaxBackups int   // Rotated log files kept, oldest removed first
+	LogCompress   bool  // Gzip rotated log files
+
+	ResponseEnvelope bool // Wrap JSON responses as {data|error, requestId}; clients can also opt in per request
+
+	ReadOnly bool // Reject every request that could change data, for serving a public viewer
+
+	AdminToken string // Bearer token for protected admin endpoints; without one they only answer loopback clients
+
+	MigrateDownTo      int  // When set, roll the schema back to this version and exit
+	MigrateDownConfirm bool // Allow rollbacks that drop data
+}
+
+var appConfig = loadConfig()
+
+func loadConfig() *Config {
+	return &Config{
+		ThumbConcurrency:       envInt("THUMB_CONCURRENCY", 4),
+		ThumbQueueTimeout:      time.Duration(envInt("THUMB_QUEUE_TIMEOUT_MS", 10000)) * time.Millisecond,
+		URLFetchTimeout:        time.Duration(envInt("URL_FETCH_TIMEOUT_MS", 30000)) * time.Millisecond,
+		URLFetchMaxBytes:       int64(envInt("URL_FETCH_MAX_BYTES", 50<<20)),
+		GenerationWorkers:      envInt("GENERATION_WORKERS", runtime.NumCPU()),
+		MaxStoredCandidates:    envInt("MAX_STORED_CANDIDATES", 50),
+		StatsCacheTTL:          time.Duration(envInt("STATS_CACHE_TTL_SECONDS", 10)) * time.Second,
+		MaxCaptionRPM:          envInt("MAX_CAPTION_RPM", 600),
+		MaxAutoCaptionSessions: envInt("MAX_AUTO_CAPTION_SESSIONS", 4),
+		CaptionDebug:           envBool("CAPTION_DEBUG", false),
+
+		ContentAddressedStorage: envBool("CONTENT_ADDRESSED_STORAGE", false),
+		HashNormalizeSize:       envInt("HASH_NORMALIZE_SIZE", 0),
+		UploadFailurePolicy:     envChoice("UPLOAD_FAILURE_POLICY", uploadFailureSalvage, uploadFailureSalvage, uploadFailureDiscard),
+		AllowedImageFormats:     envList("ALLOWED_IMAGE_FORMATS"),
+
+		DBMaxOpenConns:    envInt("DB_MAX_OPEN_CONNS", 25),
+		DBMaxIdleConns:    envInt("DB_MAX_IDLE_CONNS", 25),
+		DBConnMaxLifetime: time.Duration(envInt("DB_CONN_MAX_LIFETIME_SECONDS", 300)) * time.Second,
+
+		ExportTokenSecret: os.Getenv("EXPORT_TOKEN_SECRET"),
+		ExportTokenMaxTTL: time.Duration(envInt("EXPORT_TOKEN_MAX_TTL_HOURS", 24*30)) * time.Hour,
+		ExportArtifactTTL: time.Duration(envInt("EXPORT_ARTIFACT_TTL_HOURS", 24)) * time.Hour,
+
+		TaskClaimTTL: time.Duration(envInt("TASK_CLAIM_TTL_SECONDS", 900)) * time.Second,
+
+		BulkDeleteJobTTL: time.Duration(envInt("BULK_DELETE_JOB_TTL_MINUTES", 60)) * time.Minute,
+
+		LogMaxSize:    int64(envInt("LOG_MAX_SIZE_MB", 0)) << 20,
+		LogMaxBackups: envInt("LOG_MAX_BACKUPS", 5),
+		LogCompress:   envBool("LOG_COMPRESS", false),
+
+		ResponseEnvelope: envBool("RESPONSE_ENVELOPE", false),
+
+		ReadOnly: envBool("READ_ONLY", false),
+
+		AdminToken: os.Getenv("ADMIN_TOKEN"),
+
+		MigrateDownTo:      envInt("MIGRATE_DOWN_TO", 0),
+		MigrateDownConfirm: envBool("MIGRATE_DOWN_CONFIRM", false),
+	}
+}
+
+// configSecretFields are Config fields reported only as set or unset
+var configSecretFields = map[string]bool{
+	"ExportTokenSecret": true,
+	"AdminToken":        true,
+}
+
+// effectiveConfig describes the running configuration, keyed by Config field
+// name, for the startup log and /admin/config. Secrets are redacted.
+func effectiveConfig() map[string]interface{} {
+	summary := map[string]interface{}{
+		"DataDir":  "data",
+		"LogLevel": getLogLevel().String(),
+	}
+
+	value := reflect.ValueOf(appConfig).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		name := value.Type().Field(i).Name
+		field := value.Field(i).Interface()
+		switch v := field.(type) {
+		case string:
+			if configSecretFields[name] && v != "" {
+				v = redactedValue
+			}
+			summary[name] = v
+		case time.Duration:
+			summary[name] = v.String()
+		default:
+			summary[name] = v
+		}
+	}
+	return summary
+}
+
+// envInt reads a positive integer from the environment, falling back to def
+func envInt(key string, def int) int {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		return def
+	}
+	return n
+}
+
+// envBool reads a boolean from the environment, falling back to def
+func envBool(key string, def bool) bool {
+	value, err := strconv.ParseBool(strings.TrimSpace(os.Getenv(key)))
+	if err != nil {
+		return def
+	}
+	return value
+}
+
+// envChoice reads one of choices from the environment, falling back to def
+func envChoice(key, def string, choices ...string) string {
+	value := strings.ToLower(strings.TrimSpace(os.Getenv(key)))
+	for _, choice := range choices {
+		if value == choice {
+			return value
+		}
+	}
+	return def
+}
+
+// envList reads a comma-separated list from the environment, lowercased, with
+// blank entries dropped. It returns nil when the variable is unset or empty.
+func envList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.ToLower(strings.TrimSpace(value)); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
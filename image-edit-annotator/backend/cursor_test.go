@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+// createCursorTestTasks makes count tasks sharing one created_at second, so
+// the two orderings differ: rowid follows insertion, id does not
+func createCursorTestTasks(t *testing.T, count int) (*Project, []string) {
+	t.Helper()
+	project := createTestProject(t)
+	image := createTestImage(t, project.ID, "images/a.png", "0000000000000000")
+	var inserted []string
+	for i := 0; i < count; i++ {
+		task := createTestTask(t, Task{ID: fmt.Sprintf("task-%02d", (i*7)%count), ProjectID: project.ID, ImageAID: image.ID})
+		inserted = append(inserted, task.ID)
+	}
+	if _, err := db.Exec("UPDATE tasks SET created_at = '2024-01-01 00:00:00' WHERE project_id = ?", project.ID); err != nil {
+		t.Fatal(err)
+	}
+	return project, inserted
+}
+
+func TestLimitOnlyTaskListingKeepsInsertionOrder(t *testing.T) {
+	setupTestDB(t)
+	project, inserted := createCursorTestTasks(t, 10)
+
+	recorder := serve(getTasksHandler, http.MethodGet, "/projects/"+project.ID+"/tasks?limit=4", "")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("GET returned %d: %s", recorder.Code, recorder.Body.String())
+	}
+	var tasks []Task
+	decodeResponse(t, recorder, &tasks)
+	if len(tasks) != 4 {
+		t.Fatalf("got %d tasks, want 4", len(tasks))
+	}
+	for i, task := range tasks {
+		if task.ID != inserted[i] {
+			t.Errorf("task %d = %s, want %s", i, task.ID, inserted[i])
+		}
+	}
+	if recorder.Header().Get("X-Next-Cursor") != "" {
+		t.Error("limit-only listing sent a cursor")
+	}
+}
+
+func TestCursorTaskListingPagesThroughEveryTask(t *testing.T) {
+	setupTestDB(t)
+	project, _ := createCursorTestTasks(t, 10)
+
+	var seen []string
+	cursor := ""
+	for page := 0; ; page++ {
+		if page > 5 {
+			t.Fatal("pagination did not finish")
+		}
+		target := "/projects/" + project.ID + "/tasks?limit=4&after=" + url.QueryEscape(cursor)
+		recorder := serve(getTasksHandler, http.MethodGet, target, "")
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("GET returned %d: %s", recorder.Code, recorder.Body.String())
+		}
+		var body struct {
+			Items      []Task  `json:"items"`
+			NextCursor *string `json:"nextCursor"`
+		}
+		decodeResponse(t, recorder, &body)
+		for _, task := range body.Items {
+			seen = append(seen, task.ID)
+		}
+		if body.NextCursor == nil {
+			if recorder.Header().Get("X-Next-Cursor") != "" {
+				t.Error("last page sent a cursor header")
+			}
+			break
+		}
+		if recorder.Header().Get("X-Next-Cursor") != *body.NextCursor {
+			t.Errorf("header cursor %q differs from body cursor %q", recorder.Header().Get("X-Next-Cursor"), *body.NextCursor)
+		}
+		cursor = *body.NextCursor
+	}
+
+	if len(seen) != 10 {
+		t.Fatalf("saw %d tasks, want 10: %v", len(seen), seen)
+	}
+	for i, id := range seen {
+		if want := fmt.Sprintf("task-%02d", i); id != want {
+			t.Errorf("task %d = %s, want %s", i, id, want)
+		}
+	}
+}
+
+func TestImageListingWithoutCursorReturnsArray(t *testing.T) {
+	setupTestDB(t)
+	project := createTestProject(t)
+	for i := 0; i < 3; i++ {
+		createTestImage(t, project.ID, fmt.Sprintf("images/%d.png", i), "0000000000000000")
+	}
+
+	recorder := serve(getImagesHandler, http.MethodGet, "/images?projectId="+project.ID+"&limit=2", "")
+	var images []Image
+	decodeResponse(t, recorder, &images)
+	if len(images) != 3 {
+		t.Errorf("limit-only listing returned %d images, want all 3", len(images))
+	}
+
+	recorder = serve(getImagesHandler, http.MethodGet, "/images?projectId="+project.ID+"&limit=2&after=", "")
+	var page struct {
+		Items      []Image `json:"items"`
+		NextCursor *string `json:"nextCursor"`
+	}
+	decodeResponse(t, recorder, &page)
+	if len(page.Items) != 2 || page.NextCursor == nil {
+		t.Errorf("first cursor page = %d images, cursor %v; want 2 and a cursor", len(page.Items), page.NextCursor)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// maxDistancePairs bounds the pairs one distances request may ask about
+const maxDistancePairs = 10000
+
+type DistancesRequest struct {
+	Pairs [][]string `json:"pairs"` // Each an [imageAId, imageBId] pair
+}
+
+type PairDistance struct {
+	ImageAID string `json:"imageAId"`
+	ImageBID string `json:"imageBId"`
+	Distance int    `json:"distance"` // Hamming distance between the pHashes
+}
+
+// SkippedPair is a requested pair whose distance couldn't be computed
+type SkippedPair struct {
+	ImageAID string `json:"imageAId"`
+	ImageBID string `json:"imageBId"`
+	Error    string `json:"error"`
+}
+
+type DistancesResponse struct {
+	Distances []PairDistance `json:"distances"`
+	Skipped   []SkippedPair  `json:"skipped"`
+}
+
+// computePairDistance returns the pHash distance between two images
+func computePairDistance(imageA, imageB *Image) (int, error) {
+	hashA, err := parseImageHash(imageA.PHash)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse hash of image %s: %v", imageA.ID, err)
+	}
+	hashB, err := parseImageHash(imageB.PHash)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse hash of image %s: %v", imageB.ID, err)
+	}
+	return hashA.Distance(hashB)
+}
+
+// distancesHandler handles POST /projects/{id}/distances, returning the pHash
+// distance of each requested pair of the project's images in request order.
+// Pairs naming an image outside the project or with a hash that can't be
+// compared are reported under skipped; POST /projects/{id}/rehash fixes the
+// latter.
+func distancesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	projectID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/projects/"), "/distances")
+	if projectID == "" {
+		writeError(w, r, "Project ID is required", http.StatusBadRequest)
+		return
+	}
+
+	var req DistancesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(req.Pairs) == 0 {
+		writeError(w, r, "pairs must not be empty", http.StatusBadRequest)
+		return
+	}
+	if len(req.Pairs) > maxDistancePairs {
+		writeError(w, r, fmt.Sprintf("pairs must not contain more than %d pairs", maxDistancePairs), http.StatusBadRequest)
+		return
+	}
+	for i, pair := range req.Pairs {
+		if len(pair) != 2 {
+			writeError(w, r, fmt.Sprintf("pairs[%d] must hold exactly two image IDs", i), http.StatusBadRequest)
+			return
+		}
+	}
+
+	project, err := getProject(projectID)
+	if err != nil {
+		writeError(w, r, "Failed to get project", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to get project for distances", err, slog.String("project_id", projectID))
+		return
+	}
+	if project == nil {
+		writeError(w, r, "Project not found", http.StatusNotFound)
+		return
+	}
+
+	images, err := getImagesByProjectID(projectID)
+	if err != nil {
+		writeError(w, r, "Failed to get images", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to get images for distances", err, slog.String("project_id", projectID))
+		return
+	}
+	imageMap := make(map[string]*Image, len(images))
+	for i := range images {
+		imageMap[images[i].ID] = &images[i]
+	}
+
+	response := DistancesResponse{Distances: []PairDistance{}, Skipped: []SkippedPair{}}
+	for _, pair := range req.Pairs {
+		imageA, imageB := imageMap[pair[0]], imageMap[pair[1]]
+		if imageA == nil || imageB == nil {
+			response.Skipped = append(response.Skipped, SkippedPair{ImageAID: pair[0], ImageBID: pair[1], Error: "image not found in project"})
+			continue
+		}
+		distance, err := computePairDistance(imageA, imageB)
+		if err != nil {
+			response.Skipped = append(response.Skipped, SkippedPair{ImageAID: pair[0], ImageBID: pair[1], Error: err.Error()})
+			continue
+		}
+		response.Distances = append(response.Distances, PairDistance{ImageAID: pair[0], ImageBID: pair[1], Distance: distance})
+	}
+
+	logInfo(r.Context(), "Pair distances computed",
+		slog.String("project_id", projectID),
+		slog.Int("pairs", len(req.Pairs)),
+		slog.Int("skipped", len(response.Skipped)))
+
+	writeJSON(w, r, http.StatusOK, response)
+}
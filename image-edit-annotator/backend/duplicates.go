@@ -0,0 +1,135 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+)
+
+// DuplicateGroup is a set of images in a project sharing one hash
+type DuplicateGroup struct {
+	Hash   string  `json:"hash"`
+	Images []Image `json:"images"`
+}
+
+type DuplicatesResponse struct {
+	ProjectID string           `json:"projectId"`
+	Exact     bool             `json:"exact"` // Grouped by SHA-256 of the file rather than by pHash
+	Checked   int              `json:"checked"`
+	Unhashed  int              `json:"unhashed,omitempty"` // Images without a content hash whose file couldn't be read
+	Groups    []DuplicateGroup `json:"groups"`
+}
+
+// backfillContentHash hashes the file of an image stored before content
+// hashes were recorded and saves the result
+func backfillContentHash(image *Image) error {
+	content, err := os.ReadFile(imageFilePath(image))
+	if err != nil {
+		return err
+	}
+	hash := hashContent(content)
+	if err := updateImageContentHash(image.ID, hash); err != nil {
+		return err
+	}
+	image.ContentHash = hash
+	return nil
+}
+
+// groupDuplicateImages groups images by key, keeping only groups with more
+// than one image. Images with an empty key are left out.
+func groupDuplicateImages(images []Image, key func(*Image) string) []DuplicateGroup {
+	byHash := make(map[string][]Image)
+	var order []string
+	for i := range images {
+		hash := key(&images[i])
+		if hash == "" {
+			continue
+		}
+		if _, seen := byHash[hash]; !seen {
+			order = append(order, hash)
+		}
+		byHash[hash] = append(byHash[hash], images[i])
+	}
+
+	groups := []DuplicateGroup{}
+	for _, hash := range order {
+		if len(byHash[hash]) > 1 {
+			groups = append(groups, DuplicateGroup{Hash: hash, Images: byHash[hash]})
+		}
+	}
+	// Largest groups first so the worst offenders are reviewed first
+	sort.SliceStable(groups, func(i, j int) bool {
+		return len(groups[i].Images) > len(groups[j].Images)
+	})
+	return groups
+}
+
+// duplicatesHandler handles GET /projects/{id}/duplicates. By default images
+// are grouped by identical pHash, the check uploads use to skip similar
+// images; exact=true groups byte-identical files by SHA-256 instead, hashing
+// any image stored before content hashes were recorded.
+func duplicatesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	projectID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/projects/"), "/duplicates")
+	if projectID == "" {
+		writeError(w, r, "Project ID is required", http.StatusBadRequest)
+		return
+	}
+
+	project, err := getProject(projectID)
+	if err != nil {
+		writeError(w, r, "Failed to get project", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to get project for duplicate check", err, slog.String("project_id", projectID))
+		return
+	}
+	if project == nil {
+		writeError(w, r, "Project not found", http.StatusNotFound)
+		return
+	}
+
+	images, err := getImagesByProjectID(projectID)
+	if err != nil {
+		writeError(w, r, "Failed to get images", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to get images for duplicate check", err, slog.String("project_id", projectID))
+		return
+	}
+
+	response := DuplicatesResponse{
+		ProjectID: projectID,
+		Exact:     r.URL.Query().Get("exact") == "true",
+		Checked:   len(images),
+	}
+
+	if response.Exact {
+		for i := range images {
+			if images[i].ContentHash != "" {
+				continue
+			}
+			if err := backfillContentHash(&images[i]); err != nil {
+				response.Unhashed++
+				logger.Warn("Failed to backfill content hash",
+					"error", err,
+					"project_id", projectID,
+					"image_id", images[i].ID,
+				)
+			}
+		}
+		response.Groups = groupDuplicateImages(images, func(image *Image) string { return image.ContentHash })
+	} else {
+		response.Groups = groupDuplicateImages(images, func(image *Image) string { return image.PHash })
+	}
+
+	logInfo(r.Context(), "Duplicate images checked",
+		slog.String("project_id", projectID),
+		slog.Bool("exact", response.Exact),
+		slog.Int("checked", response.Checked),
+		slog.Int("groups", len(response.Groups)))
+
+	writeJSON(w, r, http.StatusOK, response)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// exportsDir holds the archives built by background exports until they are
+// downloaded or expire
+var exportsDir = filepath.Join("data", "exports")
+
+// exportArtifactPath returns where a project's background export of the given
+// type is written. Paths are keyed by project ID so projects sharing a name
+// don't overwrite each other's archives.
+func exportArtifactPath(projectID, exportType string) string {
+	return filepath.Join(exportsDir, projectID+"_"+exportType+".zip")
+}
+
+// exportDownloadURL is the endpoint that serves a project's finished
+// background export
+func exportDownloadURL(projectID string) string {
+	return "/projects/" + projectID + "/export/download"
+}
+
+// exportArtifactExpiry returns when an archive finished now will be swept
+func exportArtifactExpiry() string {
+	return time.Now().Add(appConfig.ExportArtifactTTL).UTC().Format(time.RFC3339)
+}
+
+// sweepExportArtifacts removes files and staging directories in exportsDir
+// that haven't been modified for ttl. Archives still being written keep a
+// fresh modification time, and a download in progress keeps reading a file
+// after it is unlinked, so neither is cut short.
+func sweepExportArtifacts(ttl time.Duration) {
+	entries, err := os.ReadDir(exportsDir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Error("Failed to list export artifacts", "error", err)
+		}
+		return
+	}
+
+	cutoff := time.Now().Add(-ttl)
+	removed := 0
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+		path := filepath.Join(exportsDir, entry.Name())
+		if err := os.RemoveAll(path); err != nil {
+			logger.Error("Failed to remove expired export artifact", "error", err, "path", path)
+			continue
+		}
+		removed++
+	}
+
+	if removed > 0 {
+		logger.Info("Removed expired export artifacts", "count", removed)
+	}
+}
+
+// startExportSweeper runs sweepExportArtifacts in the background, checking
+// several times per TTL so artifacts don't outlive it by much
+func startExportSweeper(ttl time.Duration) {
+	interval := min(ttl/4, 10*time.Minute)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			sweepExportArtifacts(ttl)
+			<-ticker.C
+		}
+	}()
+}
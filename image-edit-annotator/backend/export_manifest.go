@@ -0,0 +1,124 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// ExportManifestEntry describes one export endpoint that applies to a
+// project. Available is false while nothing would be exported, so the UI can
+// disable the matching button.
+type ExportManifestEntry struct {
+	Format          string `json:"format"`
+	Endpoint        string `json:"endpoint"`
+	MediaType       string `json:"mediaType"`
+	Description     string `json:"description"`
+	Sync            bool   `json:"sync"`
+	Async           bool   `json:"async"`
+	ExportableCount int    `json:"exportableCount"`
+	Available       bool   `json:"available"`
+}
+
+// ExportManifest lists the export formats for a project
+type ExportManifest struct {
+	ProjectID   string                `json:"projectId"`
+	ProjectType string                `json:"projectType"`
+	Formats     []ExportManifestEntry `json:"formats"`
+}
+
+// buildExportManifest returns the formats that apply to project's type with
+// the number of tasks each would export right now
+func buildExportManifest(project *Project) (*ExportManifest, error) {
+	stats, err := getCachedProjectStats(project)
+	if err != nil {
+		return nil, err
+	}
+
+	base := "/projects/" + project.ID
+	manifest := &ExportManifest{ProjectID: project.ID, ProjectType: project.ProjectType}
+
+	if project.ProjectType == "caption" {
+		manifest.Formats = []ExportManifestEntry{
+			{
+				Format:          "jsonl",
+				Endpoint:        base + exportFormats["jsonl"].suffix,
+				MediaType:       "application/x-ndjson",
+				Description:     "One JSON record per captioned image",
+				Sync:            true,
+				ExportableCount: stats.CompletedTaskCount,
+			},
+			{
+				Format:          "image-text-pairs",
+				Endpoint:        base + exportFormats["image-text-pairs"].suffix,
+				MediaType:       "application/zip",
+				Description:     "Zip of images with matching .txt caption files, built in the background",
+				Async:           true,
+				ExportableCount: stats.CompletedTaskCount,
+			},
+		}
+	} else {
+		paired, err := countPairedTasks(project.ID)
+		if err != nil {
+			return nil, err
+		}
+		manifest.Formats = []ExportManifestEntry{
+			{
+				Format:          "jsonl",
+				Endpoint:        base + exportFormats["jsonl"].suffix,
+				MediaType:       "application/x-ndjson",
+				Description:     "One JSON record per annotated task, including tasks with only an image B or a prompt",
+				Sync:            true,
+				ExportableCount: stats.CompletedTaskCount,
+			},
+			{
+				Format:          "ai-toolkit",
+				Endpoint:        base + exportFormats["ai-toolkit"].suffix,
+				MediaType:       "application/zip",
+				Description:     "Zip of control and target images with prompt files for ai-toolkit; tasks need both an image B and a prompt",
+				Sync:            true,
+				Async:           true,
+				ExportableCount: paired,
+			},
+		}
+	}
+
+	for i := range manifest.Formats {
+		manifest.Formats[i].Available = manifest.Formats[i].ExportableCount > 0
+	}
+	return manifest, nil
+}
+
+// exportManifestHandler handles GET /projects/{id}/exports
+func exportManifestHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	projectID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/projects/"), "/exports")
+	if projectID == "" {
+		writeError(w, r, "Project ID is required", http.StatusBadRequest)
+		return
+	}
+
+	project, err := getProject(projectID)
+	if err != nil {
+		writeError(w, r, "Failed to get project", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to get project for export manifest", err, slog.String("project_id", projectID))
+		return
+	}
+	if project == nil {
+		writeError(w, r, "Project not found", http.StatusNotFound)
+		return
+	}
+
+	manifest, err := buildExportManifest(project)
+	if err != nil {
+		writeError(w, r, "Failed to build export manifest", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to build export manifest", err, slog.String("project_id", projectID))
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, manifest)
+}
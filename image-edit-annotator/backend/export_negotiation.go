@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// exportFormats maps the format query parameter to the suffixed endpoint that
+// implements it
+var exportFormats = map[string]struct {
+	suffix  string
+	handler http.HandlerFunc
+}{
+	"jsonl":            {"/export/jsonl", exportJSONLHandler},
+	"ai-toolkit":       {"/export/ai-toolkit", exportAIToolkitHandler},
+	"image-text-pairs": {"/export/image-text-pairs", exportImageTextPairsHandler},
+}
+
+// exportFormatForMediaType returns the export format that produces mediaType
+// for a project, or "" if none does. Caption archives are only built in the
+// background, so application/zip is served for edit projects only.
+func exportFormatForMediaType(mediaType, projectType string) string {
+	switch mediaType {
+	case "application/x-ndjson", "application/jsonl", "*/*", "application/*":
+		return "jsonl"
+	case "application/zip":
+		if projectType != "caption" {
+			return "ai-toolkit"
+		}
+	}
+	return ""
+}
+
+// negotiateExportFormat picks the export format for an Accept header, trying
+// media types in order of preference. An empty header means JSONL.
+func negotiateExportFormat(accept, projectType string) string {
+	if strings.TrimSpace(accept) == "" {
+		return "jsonl"
+	}
+
+	type acceptRange struct {
+		mediaType string
+		quality   float64
+	}
+	var ranges []acceptRange
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			if parsed, err := strconv.ParseFloat(q, 64); err == nil {
+				quality = parsed
+			}
+		}
+		if quality > 0 {
+			ranges = append(ranges, acceptRange{mediaType, quality})
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].quality > ranges[j].quality
+	})
+
+	for _, r := range ranges {
+		if format := exportFormatForMediaType(r.mediaType, projectType); format != "" {
+			return format
+		}
+	}
+	return ""
+}
+
+// exportHandler handles GET /projects/{id}/export, choosing the format from
+// the format query parameter or else the Accept header and dispatching to the
+// matching suffixed endpoint
+func exportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	projectID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/projects/"), "/export")
+	if projectID == "" {
+		writeError(w, r, "Project ID is required", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Vary", "Accept")
+
+	format := r.URL.Query().Get("format")
+	if format != "" {
+		if _, ok := exportFormats[format]; !ok {
+			writeError(w, r, fmt.Sprintf("Unsupported export format %q; use jsonl, ai-toolkit or image-text-pairs", format), http.StatusBadRequest)
+			return
+		}
+	} else {
+		project, err := getProject(projectID)
+		if err != nil {
+			writeError(w, r, "Failed to get project", http.StatusInternalServerError)
+			logError(r.Context(), "Failed to get project for export", err, slog.String("project_id", projectID))
+			return
+		}
+		if project == nil {
+			writeError(w, r, "Project not found", http.StatusNotFound)
+			return
+		}
+
+		format = negotiateExportFormat(r.Header.Get("Accept"), project.ProjectType)
+		if format == "" {
+			supported := "application/x-ndjson"
+			if project.ProjectType != "caption" {
+				supported += ", application/zip"
+			}
+			writeError(w, r, "None of the accepted types can be exported; supported: "+supported, http.StatusNotAcceptable)
+			return
+		}
+	}
+
+	// The export handlers read the project ID from their own path suffix
+	target := exportFormats[format]
+	dispatched := r.Clone(r.Context())
+	dispatched.URL.Path = "/projects/" + projectID + target.suffix
+	withExportToken(target.handler)(w, dispatched)
+}
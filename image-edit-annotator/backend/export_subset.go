@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// maxExportSubsetIDs bounds the task IDs one subset export may name
+const maxExportSubsetIDs = 10000
+
+// ExportSubsetRequest is the body of a POST export, naming the tasks to
+// include instead of the whole project
+type ExportSubsetRequest struct {
+	TaskIDs []string `json:"taskIds"`
+}
+
+// parseExportSubset returns the task IDs a POST export is limited to, or nil
+// for a GET export of the whole project
+func parseExportSubset(r *http.Request) (map[string]bool, error) {
+	if r.Method != http.MethodPost {
+		return nil, nil
+	}
+
+	var req ExportSubsetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, fmt.Errorf("Invalid request body: %v", err)
+	}
+	if len(req.TaskIDs) == 0 {
+		return nil, fmt.Errorf("taskIds must not be empty")
+	}
+	if len(req.TaskIDs) > maxExportSubsetIDs {
+		return nil, fmt.Errorf("taskIds must not name more than %d tasks", maxExportSubsetIDs)
+	}
+
+	subset := make(map[string]bool, len(req.TaskIDs))
+	for _, id := range req.TaskIDs {
+		subset[id] = true
+	}
+	return subset, nil
+}
+
+// checkExportSubset reports the requested task IDs that aren't among the
+// project's tasks
+func checkExportSubset(subset, projectTaskIDs map[string]bool) error {
+	var unknown []string
+	for id := range subset {
+		if !projectTaskIDs[id] {
+			unknown = append(unknown, id)
+		}
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+	sort.Strings(unknown)
+	return fmt.Errorf("Tasks not found in project: %s", strings.Join(unknown, ", "))
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const defaultExportTokenTTL = 24 * time.Hour
+
+var (
+	exportTokenKey     []byte
+	exportTokenKeyOnce sync.Once
+)
+
+// exportTokenSigningKey returns the configured secret, or a random key
+// generated on first use when none is set
+func exportTokenSigningKey() []byte {
+	exportTokenKeyOnce.Do(func() {
+		if appConfig.ExportTokenSecret != "" {
+			exportTokenKey = []byte(appConfig.ExportTokenSecret)
+			return
+		}
+		exportTokenKey = make([]byte, 32)
+		if _, err := rand.Read(exportTokenKey); err != nil {
+			panic(fmt.Sprintf("failed to generate export token key: %v", err))
+		}
+		logger.Warn("EXPORT_TOKEN_SECRET is not set; export tokens will stop working when the server restarts")
+	})
+	return exportTokenKey
+}
+
+// signExportToken encodes the token's ID, project and expiry with an HMAC so
+// they can be checked before touching the database
+func signExportToken(token *ExportToken) string {
+	payload := fmt.Sprintf("%s:%s:%d", token.ID, token.ProjectID, token.ExpiresAt.Unix())
+	mac := hmac.New(sha256.New, exportTokenSigningKey())
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." +
+		base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verifyExportToken checks that value is a live token for projectID
+func verifyExportToken(value, projectID string) error {
+	encodedPayload, encodedSignature, found := strings.Cut(value, ".")
+	if !found {
+		return fmt.Errorf("malformed token")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return fmt.Errorf("malformed token")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(encodedSignature)
+	if err != nil {
+		return fmt.Errorf("malformed token")
+	}
+
+	mac := hmac.New(sha256.New, exportTokenSigningKey())
+	mac.Write(payload)
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return fmt.Errorf("invalid token signature")
+	}
+
+	fields := strings.Split(string(payload), ":")
+	if len(fields) != 3 {
+		return fmt.Errorf("malformed token")
+	}
+	if fields[1] != projectID {
+		return fmt.Errorf("token is not valid for this project")
+	}
+	expiresAt, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return fmt.Errorf("malformed token")
+	}
+	if time.Now().Unix() >= expiresAt {
+		return fmt.Errorf("token has expired")
+	}
+
+	token, err := getExportToken(fields[0])
+	if err != nil {
+		return fmt.Errorf("failed to look up token: %v", err)
+	}
+	if token == nil || token.ProjectID != projectID {
+		return fmt.Errorf("token not found")
+	}
+	if token.RevokedAt != nil {
+		return fmt.Errorf("token has been revoked")
+	}
+	return nil
+}
+
+// withExportToken checks the ?token= parameter of an export request. Requests
+// without one are passed through unchanged, so the token is an alternative
+// credential rather than a new requirement.
+func withExportToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		value := r.URL.Query().Get("token")
+		if value == "" {
+			next(w, r)
+			return
+		}
+
+		projectID, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/projects/"), "/")
+		if err := verifyExportToken(value, projectID); err != nil {
+			writeError(w, r, "Invalid export token: "+err.Error(), http.StatusUnauthorized)
+			logInfo(r.Context(), "Export token rejected",
+				slog.String("project_id", projectID),
+				slog.String("reason", err.Error()))
+			return
+		}
+		next(w, r)
+	}
+}
+
+type CreateExportTokenRequest struct {
+	TTLSeconds int `json:"ttlSeconds"` // Defaults to 24 hours
+}
+
+type CreateExportTokenResponse struct {
+	ExportToken
+	Token string `json:"token"`
+}
+
+// exportTokensHandler issues (POST) or lists (GET) a project's export tokens
+func exportTokensHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	projectID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/projects/"), "/export-token")
+	if projectID == "" {
+		writeError(w, r, "Project ID is required", http.StatusBadRequest)
+		return
+	}
+
+	ttl := defaultExportTokenTTL
+	if r.Method == http.MethodPost {
+		var req CreateExportTokenRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			writeError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.TTLSeconds < 0 {
+			writeError(w, r, "ttlSeconds must be positive", http.StatusBadRequest)
+			return
+		}
+		if req.TTLSeconds > 0 {
+			ttl = time.Duration(req.TTLSeconds) * time.Second
+		}
+		if ttl > appConfig.ExportTokenMaxTTL {
+			writeError(w, r, fmt.Sprintf("ttlSeconds may be at most %d", int(appConfig.ExportTokenMaxTTL.Seconds())), http.StatusBadRequest)
+			return
+		}
+	}
+
+	project, err := getProject(projectID)
+	if err != nil {
+		writeError(w, r, "Failed to get project", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to get project for export token", err, slog.String("project_id", projectID))
+		return
+	}
+	if project == nil {
+		writeError(w, r, "Project not found", http.StatusNotFound)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		tokens, err := getExportTokensByProjectID(projectID)
+		if err != nil {
+			writeError(w, r, "Failed to get export tokens", http.StatusInternalServerError)
+			logError(r.Context(), "Failed to get export tokens", err, slog.String("project_id", projectID))
+			return
+		}
+		if tokens == nil {
+			tokens = []ExportToken{}
+		}
+		writeJSON(w, r, http.StatusOK, tokens)
+		return
+	}
+
+	now := time.Now().UTC()
+	token := ExportToken{
+		ID:        uuid.New().String(),
+		ProjectID: projectID,
+		ExpiresAt: now.Add(ttl).Truncate(time.Second),
+		CreatedAt: now,
+	}
+	if err := createExportToken(&token); err != nil {
+		writeError(w, r, "Failed to create export token", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to create export token", err, slog.String("project_id", projectID))
+		return
+	}
+
+	logInfo(r.Context(), "Export token created",
+		slog.String("project_id", projectID),
+		slog.String("token_id", token.ID),
+		slog.Time("expires_at", token.ExpiresAt))
+
+	writeJSON(w, r, http.StatusCreated, CreateExportTokenResponse{
+		ExportToken: token,
+		Token:       signExportToken(&token),
+	})
+}
+
+// revokeExportTokenHandler handles DELETE /projects/{id}/export-token/{tokenId}
+func revokeExportTokenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	projectID, tokenID, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/projects/"), "/export-token/")
+	if projectID == "" || tokenID == "" {
+		writeError(w, r, "Project ID and token ID are required", http.StatusBadRequest)
+		return
+	}
+
+	revoked, err := revokeExportToken(projectID, tokenID)
+	if err != nil {
+		writeError(w, r, "Failed to revoke export token", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to revoke export token", err,
+			slog.String("project_id", projectID),
+			slog.String("token_id", tokenID))
+		return
+	}
+	if !revoked {
+		writeError(w, r, "Export token not found", http.StatusNotFound)
+		return
+	}
+
+	logInfo(r.Context(), "Export token revoked",
+		slog.String("project_id", projectID),
+		slog.String("token_id", tokenID))
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -1,4 +1,4 @@
-module main
+module image-edit-annotator
 
 go 1.24.4
 
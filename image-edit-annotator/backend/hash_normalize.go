@@ -0,0 +1,55 @@
+package main
+
+import (
+	"image"
+
+	"github.com/corona10/goimagehash"
+	"golang.org/x/image/draw"
+)
+
+// normalizeForHash converts a decoded image to RGBA so the same picture hashes
+// the same whatever its encoding: CMYK and YCbCr JPEGs, paletted GIFs and
+// grayscale or 16-bit PNGs otherwise reach the hash through different pixel
+// conversions. When appConfig.HashNormalizeSize is set the image is also scaled
+// to that square, so resolution differences don't shift the hash either.
+// Only the hashing input is normalized; stored files are left as uploaded.
+func normalizeForHash(img image.Image) image.Image {
+	bounds := img.Bounds()
+	if size := appConfig.HashNormalizeSize; size > 0 {
+		normalized := image.NewRGBA(image.Rect(0, 0, size, size))
+		draw.ApproxBiLinear.Scale(normalized, normalized.Bounds(), img, bounds, draw.Src, nil)
+		return normalized
+	}
+
+	if rgba, ok := img.(*image.RGBA); ok && bounds.Min == (image.Point{}) {
+		return rgba
+	}
+	normalized := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(normalized, normalized.Bounds(), img, bounds.Min, draw.Src)
+	return normalized
+}
+
+// computePHash returns the perceptual hash stored for an image
+func computePHash(img image.Image) (*goimagehash.ImageHash, error) {
+	return goimagehash.PerceptionHash(normalizeForHash(img))
+}
+
+// computePerceptualHashes returns the pHash and the dHash stored alongside it,
+// normalizing the image once for both
+func computePerceptualHashes(img image.Image) (pHash, dHash *goimagehash.ImageHash, err error) {
+	normalized := normalizeForHash(img)
+	if pHash, err = goimagehash.PerceptionHash(normalized); err != nil {
+		return nil, nil, err
+	}
+	if dHash, err = goimagehash.DifferenceHash(normalized); err != nil {
+		return nil, nil, err
+	}
+	return pHash, dHash, nil
+}
+
+// imageHashes are the hashes stored for one version of an image's content
+type imageHashes struct {
+	pHash       string
+	dHash       string
+	contentHash string
+}
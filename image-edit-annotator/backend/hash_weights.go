@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// HashWeights sets how much the pHash and dHash distances each count towards
+// the distance between two images. The combined distance is their weighted
+// mean, so it stays on the same 0-64 scale as similarityThreshold.
+type HashWeights struct {
+	PHash float64 `json:"pHash"`
+	DHash float64 `json:"dHash"`
+}
+
+// defaultHashWeights compares images by pHash alone
+var defaultHashWeights = HashWeights{PHash: 1}
+
+func validateHashWeights(weights HashWeights) error {
+	if weights.PHash < 0 || weights.DHash < 0 || math.IsNaN(weights.PHash) || math.IsNaN(weights.DHash) {
+		return fmt.Errorf("hashWeights must not be negative")
+	}
+	if weights.PHash+weights.DHash <= 0 {
+		return fmt.Errorf("hashWeights must give at least one hash a positive weight")
+	}
+	return nil
+}
+
+// usesDHash reports whether dHash distances need computing at all
+func (w HashWeights) usesDHash() bool {
+	return w.DHash > 0
+}
+
+// combine returns the weighted mean of a pHash and a dHash distance
+func (w HashWeights) combine(pHashDistance, dHashDistance int) float64 {
+	return (w.PHash*float64(pHashDistance) + w.DHash*float64(dHashDistance)) / (w.PHash + w.DHash)
+}
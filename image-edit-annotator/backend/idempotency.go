@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+const (
+	idempotencyKeyHeader    = "Idempotency-Key"
+	maxIdempotencyKeyLength = 255
+)
+
+// idempotencyReservation marks a keyed request that is still being handled, so
+// a concurrent retry with the same key is turned away instead of creating a
+// duplicate
+type idempotencyReservation struct {
+	requestHash string
+}
+
+var (
+	idempotencyMu       sync.Mutex // Guards idempotencyInFlight only; never held while a handler runs
+	idempotencyInFlight = make(map[string]*idempotencyReservation)
+)
+
+func idempotencyReservationKey(scope, key string) string {
+	return scope + "\x00" + key
+}
+
+// beginIdempotentRequest replays the recorded response if the request's
+// Idempotency-Key was already used for scope, in which case handled is true and
+// the handler must return. A key reused with a different body is rejected with
+// 422, and a key whose first request is still in flight with 409. Otherwise the
+// key is reserved and the handler proceeds; it must call release once it has
+// recorded its response with completeIdempotentRequest.
+func beginIdempotentRequest(w http.ResponseWriter, r *http.Request, scope string) (handled bool, release func()) {
+	key := strings.TrimSpace(r.Header.Get(idempotencyKeyHeader))
+	if key == "" {
+		return false, func() {}
+	}
+	if len(key) > maxIdempotencyKeyLength {
+		writeError(w, r, "Idempotency-Key is too long", http.StatusBadRequest)
+		return true, func() {}
+	}
+
+	// The body is hashed so a key can't be reused for a different request, then
+	// restored for the handler to decode
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, r, "Failed to read request body", http.StatusBadRequest)
+		return true, func() {}
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	sum := sha256.Sum256(body)
+	requestHash := hex.EncodeToString(sum[:])
+
+	reservationKey := idempotencyReservationKey(scope, key)
+	idempotencyMu.Lock()
+	if pending, ok := idempotencyInFlight[reservationKey]; ok {
+		idempotencyMu.Unlock()
+		if pending.requestHash != requestHash {
+			writeError(w, r, "Idempotency-Key was already used with a different request body", http.StatusUnprocessableEntity)
+			return true, func() {}
+		}
+		writeError(w, r, "A request with this Idempotency-Key is still in progress", http.StatusConflict)
+		return true, func() {}
+	}
+	idempotencyInFlight[reservationKey] = &idempotencyReservation{requestHash: requestHash}
+	idempotencyMu.Unlock()
+
+	release = func() {
+		idempotencyMu.Lock()
+		delete(idempotencyInFlight, reservationKey)
+		idempotencyMu.Unlock()
+	}
+
+	record, err := getIdempotencyRecord(key, scope)
+	if err != nil {
+		release()
+		writeError(w, r, "Failed to check idempotency key", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to look up idempotency key", err, slog.String("scope", scope))
+		return true, func() {}
+	}
+	if record != nil {
+		release()
+		// Records written before request hashes were stored can't be checked
+		if record.RequestHash != "" && record.RequestHash != requestHash {
+			writeError(w, r, "Idempotency-Key was already used with a different request body", http.StatusUnprocessableEntity)
+			return true, func() {}
+		}
+		logInfo(r.Context(), "Replaying idempotent response",
+			slog.String("scope", scope),
+			slog.String("entity_id", record.EntityID))
+		w.Header().Set("Idempotent-Replayed", "true")
+		writeJSON(w, r, record.StatusCode, record.Response)
+		return true, func() {}
+	}
+
+	return false, release
+}
+
+// completeIdempotentRequest records a successful response against the request's
+// Idempotency-Key, if it has one. Failures are logged since the entity itself
+// was already created.
+func completeIdempotentRequest(r *http.Request, scope, entityID string, statusCode int, response interface{}) {
+	key := strings.TrimSpace(r.Header.Get(idempotencyKeyHeader))
+	if key == "" {
+		return
+	}
+
+	// The reservation from beginIdempotentRequest is held until the handler returns
+	var requestHash string
+	idempotencyMu.Lock()
+	if pending := idempotencyInFlight[idempotencyReservationKey(scope, key)]; pending != nil {
+		requestHash = pending.requestHash
+	}
+	idempotencyMu.Unlock()
+
+	body, err := json.Marshal(response)
+	if err != nil {
+		logError(r.Context(), "Failed to encode idempotent response", err, slog.String("scope", scope))
+		return
+	}
+
+	record := &IdempotencyRecord{
+		Key:         key,
+		Scope:       scope,
+		RequestHash: requestHash,
+		EntityID:    entityID,
+		StatusCode:  statusCode,
+		Response:    body,
+	}
+	if err := createIdempotencyRecord(record); err != nil {
+		logError(r.Context(), "Failed to record idempotency key", err, slog.String("scope", scope))
+	}
+}
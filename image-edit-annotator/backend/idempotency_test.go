@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func serveCreateProject(key, body string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodPost, "/projects", strings.NewReader(body))
+	request.Header.Set(idempotencyKeyHeader, key)
+	recorder := httptest.NewRecorder()
+	createProjectHandler(recorder, request)
+	return recorder
+}
+
+func TestIdempotencyKeyReplaysMatchingBody(t *testing.T) {
+	setupTestDB(t)
+	body := `{"name":"Retried","version":"1.0"}`
+
+	first := serveCreateProject("retry-key", body)
+	if first.Code != http.StatusOK {
+		t.Fatalf("first request returned %d: %s", first.Code, first.Body.String())
+	}
+	var created Project
+	decodeResponse(t, first, &created)
+
+	second := serveCreateProject("retry-key", body)
+	if second.Code != http.StatusOK {
+		t.Fatalf("retry returned %d: %s", second.Code, second.Body.String())
+	}
+	if second.Header().Get("Idempotent-Replayed") != "true" {
+		t.Error("retry was not marked as replayed")
+	}
+	var replayed Project
+	decodeResponse(t, second, &replayed)
+	if replayed.ID != created.ID {
+		t.Errorf("retry returned project %s, want %s", replayed.ID, created.ID)
+	}
+
+	projects, err := listProjects()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(projects) != 1 {
+		t.Errorf("got %d projects, want 1", len(projects))
+	}
+}
+
+func TestIdempotencyKeyRejectsDifferentBody(t *testing.T) {
+	setupTestDB(t)
+
+	first := serveCreateProject("reused-key", `{"name":"First","version":"1.0"}`)
+	if first.Code != http.StatusOK {
+		t.Fatalf("first request returned %d: %s", first.Code, first.Body.String())
+	}
+	second := serveCreateProject("reused-key", `{"name":"Second","version":"1.0"}`)
+	if second.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("reused key returned %d, want %d", second.Code, http.StatusUnprocessableEntity)
+	}
+
+	projects, err := listProjects()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(projects) != 1 || projects[0].Name != "First" {
+		t.Errorf("projects = %+v, want only the first", projects)
+	}
+}
+
+func TestIdempotencyKeyInFlight(t *testing.T) {
+	setupTestDB(t)
+	body := `{"name":"Slow","version":"1.0"}`
+
+	// Hold the key as if the first request were still running
+	request := httptest.NewRequest(http.MethodPost, "/projects", strings.NewReader(body))
+	request.Header.Set(idempotencyKeyHeader, "slow-key")
+	handled, release := beginIdempotentRequest(httptest.NewRecorder(), request, "create_project")
+	if handled {
+		t.Fatal("first request was handled before it ran")
+	}
+
+	if recorder := serveCreateProject("slow-key", body); recorder.Code != http.StatusConflict {
+		t.Errorf("concurrent retry returned %d, want %d", recorder.Code, http.StatusConflict)
+	}
+	if recorder := serveCreateProject("slow-key", `{"name":"Other","version":"1.0"}`); recorder.Code != http.StatusUnprocessableEntity {
+		t.Errorf("concurrent request with another body returned %d, want %d", recorder.Code, http.StatusUnprocessableEntity)
+	}
+	// Other keys are not held up by it
+	if recorder := serveCreateProject("other-key", body); recorder.Code != http.StatusOK {
+		t.Errorf("request with another key returned %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	release()
+	if recorder := serveCreateProject("slow-key", body); recorder.Code != http.StatusOK {
+		t.Errorf("request after release returned %d: %s", recorder.Code, recorder.Body.String())
+	}
+}
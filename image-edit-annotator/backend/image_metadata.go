@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	maxImageMetadataKeys        = 50
+	maxImageMetadataKeyLength   = 100
+	maxImageMetadataValueLength = 2000
+)
+
+// ImageMetadataRequest replaces all of an image's metadata, e.g. its source
+// URL, license and photographer. An empty map clears it.
+type ImageMetadataRequest struct {
+	Metadata map[string]string `json:"metadata"`
+}
+
+func validateImageMetadata(metadata map[string]string) error {
+	if len(metadata) > maxImageMetadataKeys {
+		return fmt.Errorf("metadata must have at most %d keys", maxImageMetadataKeys)
+	}
+	for key, value := range metadata {
+		if strings.TrimSpace(key) == "" {
+			return fmt.Errorf("metadata keys must not be blank")
+		}
+		if utf8.RuneCountInString(key) > maxImageMetadataKeyLength {
+			return fmt.Errorf("metadata key %q must be at most %d characters", key, maxImageMetadataKeyLength)
+		}
+		if utf8.RuneCountInString(value) > maxImageMetadataValueLength {
+			return fmt.Errorf("metadata value for %q must be at most %d characters", key, maxImageMetadataValueLength)
+		}
+	}
+	return nil
+}
+
+// attachImageMetadata fills in the Metadata of a project's images, which the
+// images queries leave unset
+func attachImageMetadata(projectID string, images []Image) error {
+	metadata, err := getImageMetadataByProjectID(projectID)
+	if err != nil {
+		return err
+	}
+	for i := range images {
+		images[i].Metadata = metadata[images[i].ID]
+	}
+	return nil
+}
+
+// imageMetadataHandler handles GET and PUT /images/{id}/metadata. Both
+// respond with the image, its metadata included.
+func imageMetadataHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPut {
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	imageID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/images/"), "/metadata")
+	if imageID == "" {
+		writeError(w, r, "Image ID is required", http.StatusBadRequest)
+		return
+	}
+
+	var req ImageMetadataRequest
+	if r.Method == http.MethodPut {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, r, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if err := validateImageMetadata(req.Metadata); err != nil {
+			writeError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	image, err := getImage(imageID)
+	if err != nil {
+		writeError(w, r, "Failed to get image", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to get image for metadata", err, slog.String("image_id", imageID))
+		return
+	}
+	if image == nil {
+		writeError(w, r, "Image not found", http.StatusNotFound)
+		return
+	}
+	if image.Metadata, err = getImageMetadata(imageID); err != nil {
+		writeError(w, r, "Failed to get image metadata", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to get image metadata", err, slog.String("image_id", imageID))
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		writeJSON(w, r, http.StatusOK, image)
+		return
+	}
+
+	if err := replaceImageMetadata(imageID, req.Metadata); err != nil {
+		writeError(w, r, "Failed to update image metadata", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to update image metadata", err, slog.String("image_id", imageID))
+		return
+	}
+
+	updated := *image
+	updated.Metadata = nil
+	if len(req.Metadata) > 0 {
+		updated.Metadata = req.Metadata
+	}
+	recordAudit(r.Context(), image.ProjectID, "update", "image", imageID, image, &updated)
+
+	logInfo(r.Context(), "Image metadata updated",
+		slog.String("image_id", imageID),
+		slog.String("project_id", image.ProjectID),
+		slog.Int("keys", len(req.Metadata)))
+
+	writeJSON(w, r, http.StatusOK, updated)
+}
+
+// loadProjectImages returns a project's images, with their metadata attached
+// if withMetadata is set. Export writers embed whatever metadata is attached,
+// so exports that weren't asked for it leave it unloaded.
+func loadProjectImages(projectID string, withMetadata bool) ([]Image, error) {
+	images, err := getImagesByProjectID(projectID)
+	if err != nil || !withMetadata {
+		return images, err
+	}
+	if err := attachImageMetadata(projectID, images); err != nil {
+		return nil, fmt.Errorf("failed to get image metadata: %v", err)
+	}
+	return images, nil
+}
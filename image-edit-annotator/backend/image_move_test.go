@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// createTestImageWithOriginal stores an image row with its file and an
+// archived original under the project
+func createTestImageWithOriginal(t *testing.T, projectID, name string) *Image {
+	t.Helper()
+	originalPath := filepath.Join("originals", name)
+	record := &Image{ProjectID: projectID, Path: filepath.Join("images", name), OriginalPath: &originalPath}
+	writeTestImageFile(t, record, 0)
+	if err := os.MkdirAll(filepath.Dir(originalFilePath(record)), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(originalFilePath(record), []byte("full-size original"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	stored := createTestImage(t, projectID, record.Path, "p:0000000000000000")
+	if _, err := db.Exec("UPDATE images SET original_path = ? WHERE id = ?", originalPath, stored.ID); err != nil {
+		t.Fatal(err)
+	}
+	stored.OriginalPath = &originalPath
+	return stored
+}
+
+func TestMoveImageMovesOriginal(t *testing.T) {
+	setupTestDB(t)
+	source := createTestProject(t)
+	target := createTestProject(t)
+	createTestImageWithOriginal(t, target.ID, "photo.png") // Forces a rename in the target
+	image := createTestImageWithOriginal(t, source.ID, "photo.png")
+	oldOriginal := originalFilePath(image)
+
+	recorder := serve(moveImageHandler, http.MethodPost, "/images/"+image.ID+"/move?projectId="+target.ID, "")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("move returned %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	moved, err := getImage(image.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved.Path != filepath.Join("images", "photo_1.png") {
+		t.Errorf("path = %s, want images/photo_1.png", moved.Path)
+	}
+	if moved.OriginalPath == nil || *moved.OriginalPath != filepath.Join("originals", "photo_1.png") {
+		t.Fatalf("original path = %v, want originals/photo_1.png", moved.OriginalPath)
+	}
+	content, err := os.ReadFile(originalFilePath(moved))
+	if err != nil || string(content) != "full-size original" {
+		t.Errorf("original not found in the target project: %v", err)
+	}
+	if _, err := os.Stat(oldOriginal); !os.IsNotExist(err) {
+		t.Error("original was left behind in the source project")
+	}
+	if _, err := os.Stat(imageFilePath(moved)); err != nil {
+		t.Errorf("image file not found in the target project: %v", err)
+	}
+}
+
+func TestMoveImageRollsBackWhenOriginalCantMove(t *testing.T) {
+	setupTestDB(t)
+	source := createTestProject(t)
+	target := createTestProject(t)
+	image := createTestImageWithOriginal(t, source.ID, "photo.png")
+	if err := os.Remove(originalFilePath(image)); err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := serve(moveImageHandler, http.MethodPost, "/images/"+image.ID+"/move?projectId="+target.ID, "")
+	if recorder.Code != http.StatusInternalServerError {
+		t.Fatalf("move returned %d, want 500", recorder.Code)
+	}
+
+	unchanged, err := getImage(image.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unchanged.ProjectID != source.ID || unchanged.Path != image.Path {
+		t.Errorf("row was changed: %+v", unchanged)
+	}
+	if _, err := os.Stat(imageFilePath(image)); err != nil {
+		t.Errorf("image file was not moved back: %v", err)
+	}
+}
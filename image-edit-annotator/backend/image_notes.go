@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+const maxImageNotesLength = 5000
+
+// UpdateImageRequest holds the image fields annotators may edit. Fields left
+// out of the request keep their value; a null or blank notes value clears the
+// note.
+type UpdateImageRequest struct {
+	Notes    *string `json:"notes"`
+	Excluded *bool   `json:"excluded"`
+}
+
+// normalizeImageNotes trims notes and maps a blank note to nil
+func normalizeImageNotes(notes *string) (*string, error) {
+	if notes == nil {
+		return nil, nil
+	}
+	trimmed := strings.TrimSpace(*notes)
+	if trimmed == "" {
+		return nil, nil
+	}
+	if utf8.RuneCountInString(trimmed) > maxImageNotesLength {
+		return nil, fmt.Errorf("notes must be at most %d characters", maxImageNotesLength)
+	}
+	return &trimmed, nil
+}
+
+// updateImageHandler handles PATCH /images/{id}. Notes and the excluded flag
+// live on the image itself, independent of any task using it. Excluding an
+// image leaves its existing tasks alone; it only stops new pairings and
+// exports.
+func updateImageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPatch {
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	imageID := strings.TrimPrefix(r.URL.Path, "/images/")
+	if imageID == "" {
+		writeError(w, r, "Image ID is required", http.StatusBadRequest)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, r, "Failed to read request body", http.StatusBadRequest)
+		return
+	}
+	var req UpdateImageRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeError(w, r, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	// Notes are only changed when the request mentions them; an explicit
+	// null clears them
+	var present struct {
+		Notes json.RawMessage `json:"notes"`
+	}
+	json.Unmarshal(body, &present)
+	notes, err := normalizeImageNotes(req.Notes)
+	if err != nil {
+		writeError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	image, err := getImage(imageID)
+	if err != nil {
+		writeError(w, r, "Failed to get image", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to get image for update", err, slog.String("image_id", imageID))
+		return
+	}
+	if image == nil {
+		writeError(w, r, "Image not found", http.StatusNotFound)
+		return
+	}
+
+	updated := *image
+	if present.Notes != nil {
+		updated.Notes = notes
+	}
+	if req.Excluded != nil {
+		updated.Excluded = *req.Excluded
+	}
+
+	if err := updateImageAnnotations(imageID, updated.Notes, updated.Excluded); err != nil {
+		writeError(w, r, "Failed to update image", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to update image", err, slog.String("image_id", imageID))
+		return
+	}
+	recordAudit(r.Context(), image.ProjectID, "update", "image", imageID, image, &updated)
+
+	logInfo(r.Context(), "Image updated",
+		slog.String("image_id", imageID),
+		slog.String("project_id", image.ProjectID),
+		slog.Bool("has_notes", updated.Notes != nil),
+		slog.Bool("excluded", updated.Excluded))
+
+	writeJSON(w, r, http.StatusOK, updated)
+}
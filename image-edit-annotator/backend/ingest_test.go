@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func encodeTestImage(t testing.TB, format string) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 64, 48))
+	for y := 0; y < 48; y++ {
+		for x := 0; x < 64; x++ {
+			img.Set(x, y, color.RGBA{uint8(x * 4), uint8(y * 5), uint8(x ^ y), 255})
+		}
+	}
+	var buf bytes.Buffer
+	var err error
+	if format == "png" {
+		err = png.Encode(&buf, img)
+	} else {
+		err = jpeg.Encode(&buf, img, nil)
+	}
+	if err != nil {
+		t.Fatalf("encode %s: %v", format, err)
+	}
+	return buf.Bytes()
+}
+
+func bytesSource(name string, content []byte) uploadSource {
+	return uploadSource{
+		Label:    name,
+		Filename: name,
+		Read:     func() ([]byte, error) { return content, nil },
+	}
+}
+
+// storedFiles lists every file under data/
+func storedFiles(t testing.TB) []string {
+	t.Helper()
+	var files []string
+	filepath.WalkDir("data", func(path string, entry fs.DirEntry, err error) error {
+		if err == nil && !entry.IsDir() {
+			files = append(files, path)
+		}
+		return nil
+	})
+	return files
+}
+
+func TestProcessUploadsRejectsCorruptImages(t *testing.T) {
+	for _, contentAddressed := range []bool{false, true} {
+		t.Run(map[bool]string{false: "project_files", true: "blobs"}[contentAddressed], func(t *testing.T) {
+			setupTestDB(t)
+			previous := appConfig.ContentAddressedStorage
+			appConfig.ContentAddressedStorage = contentAddressed
+			t.Cleanup(func() { appConfig.ContentAddressedStorage = previous })
+
+			project := createTestProject(t)
+			projectDir := filepath.Join("data", "projects", project.ID, "images")
+			if err := os.MkdirAll(projectDir, 0755); err != nil {
+				t.Fatal(err)
+			}
+
+			pngContent := encodeTestImage(t, "png")
+			jpegContent := encodeTestImage(t, "jpeg")
+			garbage := make([]byte, 4096)
+			rand.New(rand.NewSource(1)).Read(garbage)
+			sources := []uploadSource{
+				bytesSource("truncated.png", pngContent[:len(pngContent)/2]),
+				bytesSource("header-only.png", pngContent[:16]),
+				bytesSource("truncated.jpg", jpegContent[:len(jpegContent)/2]),
+				bytesSource("header-only.jpg", jpegContent[:4]),
+				bytesSource("garbage.png", garbage),
+				bytesSource("empty.png", nil),
+			}
+
+			results := processUploads(project, sources, projectDir)
+			for _, result := range results {
+				if result.Status != "error" || result.Error == "" {
+					t.Errorf("%s: status %q (%q), want an error", result.Filename, result.Status, result.Error)
+				}
+			}
+
+			var rows int
+			if err := db.QueryRow("SELECT COUNT(*) FROM images").Scan(&rows); err != nil {
+				t.Fatal(err)
+			}
+			if rows != 0 {
+				t.Errorf("%d image rows stored for corrupt uploads", rows)
+			}
+			if files := storedFiles(t); len(files) != 0 {
+				t.Errorf("files left behind: %v", files)
+			}
+		})
+	}
+}
+
+// panicImageMagic starts files decoded by a format registered in init that
+// panics, standing in for a decoder bug triggered by malformed data
+const panicImageMagic = "PANICIMG"
+
+func init() {
+	panicDecoder := func(io.Reader) (image.Image, error) { panic("decoder bug") }
+	panicConfig := func(io.Reader) (image.Config, error) { panic("decoder bug") }
+	image.RegisterFormat("panicimg", panicImageMagic, panicDecoder, panicConfig)
+}
+
+func TestProcessUploadsRecoversFromDecoderPanic(t *testing.T) {
+	setupTestDB(t)
+	project := createTestProject(t)
+	projectDir := filepath.Join("data", "projects", project.ID, "images")
+	if err := os.MkdirAll(projectDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	panicking := bytesSource("panics.img", []byte(panicImageMagic+"\x00\x01"))
+	panicRead := uploadSource{
+		Label:    "read-panics.png",
+		Filename: "read-panics.png",
+		Read:     func() ([]byte, error) { panic("reader bug") },
+	}
+	valid := bytesSource("valid.png", encodeTestImage(t, "png"))
+
+	results := processUploads(project, []uploadSource{panicking, panicRead, valid}, projectDir)
+	if len(results) != 3 {
+		t.Fatalf("got %d results, want 3", len(results))
+	}
+	for _, result := range results[:2] {
+		if result.Status != "error" || result.Error == "" {
+			t.Errorf("%s: status %q (%q), want an error", result.Filename, result.Status, result.Error)
+		}
+	}
+	if results[2].Status != "created" {
+		t.Fatalf("valid.png: status %q (%q), want created", results[2].Status, results[2].Error)
+	}
+
+	images, err := getImagesByProjectID(project.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(images) != 1 || images[0].Path != filepath.Join("images", "valid.png") {
+		t.Errorf("stored images = %+v, want only valid.png", images)
+	}
+	if _, err := os.Stat(filepath.Join(projectDir, "valid.png")); err != nil {
+		t.Errorf("valid.png was not written: %v", err)
+	}
+}
+
+// FuzzIngestImageSafely feeds arbitrary bytes through ingestion; it must return
+// an error or an image, never panic. Run with go test -fuzz=FuzzIngestImageSafely.
+func FuzzIngestImageSafely(f *testing.F) {
+	setupTestDB(f)
+	project := createTestProject(f)
+	projectDir := filepath.Join("data", "projects", project.ID, "images")
+	if err := os.MkdirAll(projectDir, 0755); err != nil {
+		f.Fatal(err)
+	}
+
+	pngContent := encodeTestImage(f, "png")
+	jpegContent := encodeTestImage(f, "jpeg")
+	f.Add(pngContent)
+	f.Add(jpegContent)
+	f.Add(pngContent[:len(pngContent)-10])
+	f.Add(jpegContent[:len(jpegContent)/3])
+	f.Add([]byte("GIF89a\x01\x00\x01\x00"))
+	f.Add([]byte("RIFF\x00\x00\x00\x00WEBPVP8 "))
+
+	f.Fuzz(func(t *testing.T, content []byte) {
+		var blobLock blobReadLock
+		defer blobLock.release()
+		imageRecord, skipReason, err := ingestImageSafely(project, bytesSource("fuzz.png", content), projectDir, &blobLock)
+		if err == nil && skipReason == "" && imageRecord == nil {
+			t.Fatal("no image, skip reason or error returned")
+		}
+	})
+}
+
+func TestProcessUploadsSkipsRepeatedFilename(t *testing.T) {
+	setupTestDB(t)
+	project := createTestProject(t)
+	projectDir := filepath.Join("data", "projects", project.ID, "images")
+	if err := os.MkdirAll(projectDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	first := encodeTestImage(t, "png")
+	second := encodeTestImage(t, "jpeg")
+	sources := []uploadSource{
+		bytesSource("same.png", first),
+		bytesSource("same.png", second),
+		// A name whose first file failed is still free for a later one
+		bytesSource("retry.png", first[:20]),
+		bytesSource("retry.png", first),
+	}
+
+	results := processUploads(project, sources, projectDir)
+	statuses := make([]string, len(results))
+	for i, result := range results {
+		statuses[i] = result.Status
+	}
+	if want := []string{"created", "skipped", "error", "created"}; !reflect.DeepEqual(statuses, want) {
+		t.Fatalf("statuses = %v, want %v", statuses, want)
+	}
+
+	stored, err := getImageByPath(project.ID, filepath.Join("images", "same.png"))
+	if err != nil || stored == nil {
+		t.Fatalf("same.png row: %v", err)
+	}
+	content, err := os.ReadFile(filepath.Join(projectDir, "same.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(content, first) {
+		t.Error("the repeated file overwrote the first one on disk")
+	}
+	if sum := sha256.Sum256(content); stored.ContentHash != hex.EncodeToString(sum[:]) {
+		t.Error("stored content hash doesn't match the file on disk")
+	}
+}
+
+func TestProcessUploadsHoldsBlobLockOnlyWhileStoring(t *testing.T) {
+	setupTestDB(t)
+	previous := appConfig.ContentAddressedStorage
+	appConfig.ContentAddressedStorage = true
+	t.Cleanup(func() { appConfig.ContentAddressedStorage = previous })
+	project := createTestProject(t)
+	projectDir := filepath.Join("data", "projects", project.ID, "images")
+
+	// Each Read reports whether a delete could take blobMu at that point
+	var deletable []bool
+	source := func(name, format string) uploadSource {
+		content := encodeTestImage(t, format)
+		return uploadSource{
+			Label:    name,
+			Filename: name,
+			Read: func() ([]byte, error) {
+				locked := blobMu.TryLock()
+				if locked {
+					blobMu.Unlock()
+				}
+				deletable = append(deletable, locked)
+				return content, nil
+			},
+		}
+	}
+
+	results := processUploads(project, []uploadSource{source("a.png", "png"), source("b.jpg", "jpeg")}, projectDir)
+	for _, result := range results {
+		if result.Status != "created" {
+			t.Fatalf("%s: %s %s", result.Filename, result.Status, result.Error)
+		}
+	}
+	if want := []bool{true, false}; !reflect.DeepEqual(deletable, want) {
+		t.Errorf("blobMu free during reads = %v, want %v", deletable, want)
+	}
+	if !blobMu.TryLock() {
+		t.Fatal("blobMu still held after the upload")
+	}
+	blobMu.Unlock()
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ActiveJob describes one piece of background work the server is doing
+type ActiveJob struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`                // "upload", "auto_caption" or "bulk_delete"
+	ProjectID string    `json:"projectId,omitempty"` // Empty for jobs spanning several projects
+	Progress  int       `json:"progress"`
+	Total     int       `json:"total"`
+	StartedAt time.Time `json:"startedAt"`
+}
+
+// uploadSession tracks an upload while processUploads works through it
+type uploadSession struct {
+	projectID string
+	filename  string // Label of the source being processed
+	progress  int
+	total     int
+	startedAt time.Time
+	last      *ProgressUpdate // Most recent update sent for it, replayed to clients that connect late
+}
+
+var (
+	uploadSessions   = make(map[string]*uploadSession)
+	uploadSessionsMu sync.Mutex
+)
+
+func startUploadSession(projectID string, total int) string {
+	id := uuid.New().String()
+	uploadSessionsMu.Lock()
+	uploadSessions[id] = &uploadSession{projectID: projectID, total: total, startedAt: time.Now()}
+	uploadSessionsMu.Unlock()
+	return id
+}
+
+func updateUploadSession(id string, progress int, filename string) {
+	uploadSessionsMu.Lock()
+	if session, exists := uploadSessions[id]; exists {
+		session.progress = progress
+		session.filename = filename
+	}
+	uploadSessionsMu.Unlock()
+}
+
+// reportUploadProgress sends an upload's progress over SSE and keeps it as the
+// session's last-known state, so a client that reconnects mid-upload can pick
+// up where the stream left off
+func reportUploadProgress(id string, update ProgressUpdate) {
+	uploadSessionsMu.Lock()
+	if session, exists := uploadSessions[id]; exists {
+		copied := update
+		session.last = &copied
+	}
+	uploadSessionsMu.Unlock()
+
+	sendProgressUpdate(update.ProjectID, update)
+}
+
+// getUploadProgress returns the last update sent for each of a project's
+// running uploads, oldest first
+func getUploadProgress(projectID string) []ProgressUpdate {
+	uploadSessionsMu.Lock()
+	var sessions []*uploadSession
+	for _, session := range uploadSessions {
+		if session.projectID == projectID {
+			copied := *session
+			sessions = append(sessions, &copied)
+		}
+	}
+	uploadSessionsMu.Unlock()
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].startedAt.Before(sessions[j].startedAt)
+	})
+	updates := make([]ProgressUpdate, 0, len(sessions))
+	for _, session := range sessions {
+		if session.last != nil {
+			updates = append(updates, *session.last)
+			continue
+		}
+		updates = append(updates, ProgressUpdate{
+			ProjectID: projectID,
+			Filename:  session.filename,
+			Progress:  session.progress,
+			Total:     session.total,
+			Status:    "processing",
+		})
+	}
+	return updates
+}
+
+func endUploadSession(id string) {
+	uploadSessionsMu.Lock()
+	delete(uploadSessions, id)
+	uploadSessionsMu.Unlock()
+}
+
+// listActiveJobs gathers running uploads, auto captioning sessions and bulk
+// deletes from their in-memory managers, oldest first
+func listActiveJobs() []ActiveJob {
+	jobs := []ActiveJob{}
+
+	uploadSessionsMu.Lock()
+	for id, session := range uploadSessions {
+		jobs = append(jobs, ActiveJob{
+			ID:        id,
+			Type:      "upload",
+			ProjectID: session.projectID,
+			Progress:  session.progress,
+			Total:     session.total,
+			StartedAt: session.startedAt,
+		})
+	}
+	uploadSessionsMu.Unlock()
+
+	for _, progress := range autoCaptionManager.ActiveSessions() {
+		startedAt, _ := time.Parse(time.RFC3339, progress.StartedAt)
+		jobs = append(jobs, ActiveJob{
+			// Only one session runs per project, so the project ID identifies it
+			ID:        progress.ProjectID,
+			Type:      "auto_caption",
+			ProjectID: progress.ProjectID,
+			Progress:  progress.Processed,
+			Total:     progress.Total,
+			StartedAt: startedAt,
+		})
+	}
+
+	bulkDeleteJobsMu.RLock()
+	for _, job := range bulkDeleteJobs {
+		if job.Status != "processing" {
+			continue
+		}
+		jobs = append(jobs, ActiveJob{
+			ID:        job.JobID,
+			Type:      "bulk_delete",
+			Progress:  job.Progress,
+			Total:     job.Total,
+			StartedAt: job.StartedAt,
+		})
+	}
+	bulkDeleteJobsMu.RUnlock()
+
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].StartedAt.Before(jobs[j].StartedAt)
+	})
+	return jobs
+}
+
+// progressSnapshotHandler returns the current upload, auto captioning and
+// export progress for a project. The SSE streams only carry later changes, so
+// clients render this on connect and then apply the stream's updates.
+func progressSnapshotHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	projectID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/projects/"), "/progress-snapshot")
+	if projectID == "" {
+		writeError(w, r, "Project ID is required", http.StatusBadRequest)
+		return
+	}
+
+	project, err := getProject(projectID)
+	if err != nil {
+		writeError(w, r, "Failed to get project", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to get project for progress snapshot", err, slog.String("project_id", projectID))
+		return
+	}
+	if project == nil {
+		writeError(w, r, "Project not found", http.StatusNotFound)
+		return
+	}
+
+	autoCaption, _ := autoCaptionManager.GetAutoCaptionStatus(projectID)
+
+	var export *ExportStatus
+	if status := getExportStatus(projectID); status != nil {
+		copied := *status
+		export = &copied
+	}
+
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"uploads":     getUploadProgress(projectID),
+		"autoCaption": autoCaption.Progress, // null when no session is running
+		"export":      export,               // Most recent export, which may have finished
+	})
+}
+
+// uploadProgressSnapshotHandler handles GET /progress/snapshot?projectId=,
+// returning the last update of each of the project's running uploads, oldest
+// first. progressHandler sends the same updates when a client subscribes.
+func uploadProgressSnapshotHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	projectID := r.URL.Query().Get("projectId")
+	if projectID == "" {
+		writeError(w, r, "Project ID is required", http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, getUploadProgress(projectID))
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rotatingFile is the writer behind the JSON log file. Once the file would
+// grow past maxSize it is renamed aside with a timestamp, optionally gzipped,
+// and a fresh file is started; only the newest maxBackups rotated files are
+// kept. slog writes each record in one call, so files split between records.
+//
+// Errors are reported on stderr since the logger can't log about itself.
+type rotatingFile struct {
+	mu         sync.Mutex
+	path       string
+	file       *os.File
+	size       int64
+	maxSize    int64
+	maxBackups int
+	compress   bool
+}
+
+// openRotatingFile opens the log at path. Any log left by a previous run is
+// rotated aside rather than wiped, so recent history survives a restart.
+func openRotatingFile(path string, maxSize int64, maxBackups int, compress bool) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups, compress: compress}
+	if info, err := os.Stat(path); err == nil && info.Size() > 0 {
+		if err := r.rotateExisting(); err != nil {
+			return nil, err
+		}
+	}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	file, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	r.file = file
+	r.size = 0
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.file.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to close log file for rotation: %v\n", err)
+		}
+		if err := r.rotateExisting(); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to rotate log file: %v\n", err)
+		}
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// rotateExisting renames the current log aside, then compresses it and prunes
+// old backups in the background so logging isn't held up
+func (r *rotatingFile) rotateExisting() error {
+	ext := filepath.Ext(r.path)
+	rotated := fmt.Sprintf("%s-%s%s", strings.TrimSuffix(r.path, ext), time.Now().Format("20060102T150405.000"), ext)
+	if err := os.Rename(r.path, rotated); err != nil {
+		return err
+	}
+
+	go func() {
+		if r.compress {
+			if err := gzipFile(rotated); err != nil {
+				fmt.Fprintf(os.Stderr, "failed to compress rotated log %s: %v\n", rotated, err)
+			}
+		}
+		r.pruneBackups()
+	}()
+	return nil
+}
+
+// pruneBackups removes all but the newest maxBackups rotated logs, compressed
+// or not. Timestamps in the names sort in the order the files were rotated.
+func (r *rotatingFile) pruneBackups() {
+	ext := filepath.Ext(r.path)
+	backups, err := filepath.Glob(strings.TrimSuffix(r.path, ext) + "-*" + ext + "*")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to list rotated logs: %v\n", err)
+		return
+	}
+	// A backup still being compressed shows up as both files; count it once
+	sort.Strings(backups)
+	seen := make(map[string]bool)
+	var distinct []string
+	for _, backup := range backups {
+		if strings.HasSuffix(backup, ".tmp") {
+			continue
+		}
+		name := strings.TrimSuffix(backup, ".gz")
+		if !seen[name] {
+			seen[name] = true
+			distinct = append(distinct, name)
+		}
+	}
+
+	for i := 0; i < len(distinct)-r.maxBackups; i++ {
+		for _, path := range []string{distinct[i], distinct[i] + ".gz"} {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				fmt.Fprintf(os.Stderr, "failed to remove rotated log %s: %v\n", path, err)
+			}
+		}
+	}
+}
+
+// gzipFile replaces path with path.gz
+func gzipFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp := path + ".gz.tmp"
+	dst, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(dst)
+	if _, err := io.Copy(gz, src); err != nil {
+		dst.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		dst.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path+".gz"); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+const (
+	defaultLogTailLines = 200
+	maxLogTailLines     = 5000
+	logTailChunkSize    = 64 << 10
+)
+
+// tailLogFile returns up to n of the last complete lines of the file at path,
+// oldest first. It reads backwards in chunks from the size seen when opening,
+// so lines appended meanwhile are ignored, and a file truncated or rotated
+// under it just yields fewer lines. A missing file has no lines.
+func tailLogFile(path string, n int) ([][]byte, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	var data []byte
+	offset := info.Size()
+	for offset > 0 && bytes.Count(data, []byte("\n")) <= n {
+		chunk := int64(logTailChunkSize)
+		if chunk > offset {
+			chunk = offset
+		}
+		offset -= chunk
+		buf := make([]byte, chunk)
+		read, err := file.ReadAt(buf, offset)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		// A short read means the file shrank; keep whatever was read
+		data = append(buf[:read], data...)
+	}
+
+	lines := bytes.Split(bytes.TrimRight(data, "\n"), []byte("\n"))
+	// Unless the whole file was read, the first line may be cut off
+	if offset > 0 && len(lines) > 0 {
+		lines = lines[1:]
+	}
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines, nil
+}
+
+// logTailHandler handles GET /admin/logs?lines=N, returning the last N
+// entries (default 200, at most 5000) of the JSON app log as an array. Lines
+// that aren't valid JSON, such as one caught mid-write, are left out.
+func logTailHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	n := defaultLogTailLines
+	if raw := r.URL.Query().Get("lines"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed <= 0 {
+			writeError(w, r, "lines must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		n = min(parsed, maxLogTailLines)
+	}
+
+	lines, err := tailLogFile(logFilePath, n)
+	if err != nil {
+		writeError(w, r, "Failed to read log file", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to read log file", err, slog.String("path", logFilePath))
+		return
+	}
+
+	entries := make([]json.RawMessage, 0, len(lines))
+	for _, line := range lines {
+		if json.Valid(line) {
+			entries = append(entries, json.RawMessage(line))
+		}
+	}
+
+	writeJSON(w, r, http.StatusOK, entries)
+}
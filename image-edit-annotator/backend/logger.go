@@ -16,20 +16,32 @@ import (
 
 var logger *slog.Logger
 
+// logFilePath is the JSON log file written alongside the console output
+var logFilePath = filepath.Join("data", "logs", "app.log")
+
 func initLogger() error {
 	logLevel := getLogLevel()
 	
 	// Create log directory
-	logDir := filepath.Join("data", "logs")
-	if err := os.MkdirAll(logDir, 0755); err != nil {
+	if err := os.MkdirAll(filepath.Dir(logFilePath), 0755); err != nil {
 		return fmt.Errorf("failed to create log directory: %v", err)
 	}
 
-	// Create/truncate log file (wipe on restart)
-	logFile := filepath.Join(logDir, "app.log")
-	file, err := os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
-	if err != nil {
-		return fmt.Errorf("failed to open log file: %v", err)
+	// Create/truncate log file (wipe on restart), unless rotation is enabled
+	logFile := logFilePath
+	var file io.Writer
+	if appConfig.LogMaxSize > 0 {
+		rotating, err := openRotatingFile(logFile, appConfig.LogMaxSize, appConfig.LogMaxBackups, appConfig.LogCompress)
+		if err != nil {
+			return fmt.Errorf("failed to open log file: %v", err)
+		}
+		file = rotating
+	} else {
+		plain, err := os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to open log file: %v", err)
+		}
+		file = plain
 	}
 
 	// Create multi-writer for console and file (unused but shows intent)
@@ -67,6 +79,7 @@ func initLogger() error {
 	logger.Info("Logger initialized", 
 		"level", logLevel.String(),
 		"file", logFile,
+		"rotate_at_bytes", appConfig.LogMaxSize,
 	)
 
 	return nil
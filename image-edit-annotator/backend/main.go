@@ -2,23 +2,31 @@ package main
 
 import (
 	"archive/zip"
+	"bytes"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"image"
 	"image/png"
 	"io"
 	"log/slog"
+	"math"
 	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/corona10/goimagehash"
 	"github.com/google/uuid"
 
+	_ "image/gif"
 	_ "image/jpeg"
 	_ "image/png"
 	_ "golang.org/x/image/webp"
@@ -41,6 +49,13 @@ type ProgressUpdate struct {
 	Total        int    `json:"total"`
 	Status       string `json:"status"`
 	ErrorMessage string `json:"errorMessage,omitempty"`
+	// Bytes of the files handled so far, whatever their outcome, out of the
+	// batch total. Both stay 0 when a source's size isn't known up front.
+	BytesProcessed int64 `json:"bytesProcessed"`
+	TotalBytes     int64 `json:"totalBytes,omitempty"`
+	// Tasks generated for the new images, on the completion update of
+	// projects with autoGenerateTasks
+	TasksCreated int `json:"tasksCreated,omitempty"`
 }
 
 type ExportProgress struct {
@@ -64,21 +79,115 @@ type ExportStatus struct {
 	Error       string `json:"error,omitempty"`
 	StartTime   string `json:"startTime"`
 	CompletedAt string `json:"completedAt,omitempty"`
+	DownloadURL string `json:"downloadUrl,omitempty"` // Set once the archive is ready
+	ExpiresAt   string `json:"expiresAt,omitempty"`   // When the archive becomes eligible for cleanup
 }
 
 func pingHandler(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, "pong")
 }
 
+// validateProject checks project settings that can't be enforced by the schema
+func validateProject(project *Project) error {
+	if project.DefaultSimilarityThreshold != nil && *project.DefaultSimilarityThreshold <= 0 {
+		return fmt.Errorf("defaultSimilarityThreshold must be positive")
+	}
+	if project.DefaultMaxCandidates != nil && *project.DefaultMaxCandidates <= 0 {
+		return fmt.Errorf("defaultMaxCandidates must be positive")
+	}
+	if project.MinWidth != nil && *project.MinWidth <= 0 {
+		return fmt.Errorf("minWidth must be positive")
+	}
+	if project.MinHeight != nil && *project.MinHeight <= 0 {
+		return fmt.Errorf("minHeight must be positive")
+	}
+	if project.MinAspectRatio != nil && *project.MinAspectRatio <= 0 {
+		return fmt.Errorf("minAspectRatio must be positive")
+	}
+	if project.MaxAspectRatio != nil && *project.MaxAspectRatio <= 0 {
+		return fmt.Errorf("maxAspectRatio must be positive")
+	}
+	if project.MinAspectRatio != nil && project.MaxAspectRatio != nil && *project.MinAspectRatio > *project.MaxAspectRatio {
+		return fmt.Errorf("minAspectRatio must not exceed maxAspectRatio")
+	}
+	if project.MaxDimension != nil && *project.MaxDimension <= 0 {
+		return fmt.Errorf("maxDimension must be positive")
+	}
+	if project.CaptionTemplate != nil && strings.TrimSpace(*project.CaptionTemplate) != "" {
+		if err := validateCaptionTemplate(*project.CaptionTemplate); err != nil {
+			return fmt.Errorf("captionTemplate: %v", err)
+		}
+	}
+	if err := validateCaptionStripPatterns(project.CaptionStripPatterns); err != nil {
+		return fmt.Errorf("captionStripPatterns: %v", err)
+	}
+	if project.CaptionAPI != nil {
+		// Malformed configurations are reported when captioning starts, as before
+		var apiConfig CaptionAPIConfig
+		if json.Unmarshal([]byte(*project.CaptionAPI), &apiConfig) == nil {
+			if err := apiConfig.validate(); err != nil {
+				return fmt.Errorf("captionApi: %v", err)
+			}
+		}
+	}
+	return nil
+}
+
+// checkImageConstraints returns why an image of the given size doesn't meet the
+// project's optional dimension and aspect ratio limits, or "" if it does
+func checkImageConstraints(project *Project, width, height int) string {
+	if project.MinWidth != nil && width < *project.MinWidth {
+		return fmt.Sprintf("Image width %d is below the minimum of %d", width, *project.MinWidth)
+	}
+	if project.MinHeight != nil && height < *project.MinHeight {
+		return fmt.Sprintf("Image height %d is below the minimum of %d", height, *project.MinHeight)
+	}
+	if height == 0 {
+		return ""
+	}
+	ratio := float64(width) / float64(height)
+	if project.MinAspectRatio != nil && ratio < *project.MinAspectRatio {
+		return fmt.Sprintf("Aspect ratio %.3f is below the minimum of %.3f", ratio, *project.MinAspectRatio)
+	}
+	if project.MaxAspectRatio != nil && ratio > *project.MaxAspectRatio {
+		return fmt.Sprintf("Aspect ratio %.3f is above the maximum of %.3f", ratio, *project.MaxAspectRatio)
+	}
+	return ""
+}
+
+// imageFormatAllowed reports whether ALLOWED_IMAGE_FORMATS admits an image
+// decoded as format (the name image.Decode reports). "jpg" is accepted as an
+// alias of "jpeg".
+func imageFormatAllowed(format string) bool {
+	if len(appConfig.AllowedImageFormats) == 0 {
+		return true
+	}
+	for _, allowed := range appConfig.AllowedImageFormats {
+		if allowed == "jpg" {
+			allowed = "jpeg"
+		}
+		if allowed == format {
+			return true
+		}
+	}
+	return false
+}
+
 func createProjectHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
+	handled, release := beginIdempotentRequest(w, r, "create_project")
+	if handled {
+		return
+	}
+	defer release()
+
 	var project Project
 	if err := json.NewDecoder(r.Body).Decode(&project); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, err.Error(), http.StatusBadRequest)
 		return
 	}
 
@@ -89,76 +198,111 @@ func createProjectHandler(w http.ResponseWriter, r *http.Request) {
 		project.ProjectType = "edit"
 	}
 
+	if err := validateProject(&project); err != nil {
+		writeError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	if err := createProject(&project); err != nil {
-		http.Error(w, "Failed to create project", http.StatusInternalServerError)
+		writeError(w, r, "Failed to create project", http.StatusInternalServerError)
 		logError(r.Context(), "Failed to create project", err, slog.String("project_name", project.Name))
 		return
 	}
+	recordAudit(r.Context(), project.ID, "create", "project", project.ID, nil, &project)
+	completeIdempotentRequest(r, "create_project", project.ID, http.StatusOK, project)
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(project)
+	writeJSON(w, r, http.StatusOK, project)
 }
 
 func getProjectHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
 	id := r.URL.Path[len("/projects/"):]
 	if id == "" {
-		http.Error(w, "Project ID is required", http.StatusBadRequest)
+		writeError(w, r, "Project ID is required", http.StatusBadRequest)
 		return
 	}
 
 	project, err := getProject(id)
 	if err != nil {
-		http.Error(w, "Failed to get project", http.StatusInternalServerError)
+		writeError(w, r, "Failed to get project", http.StatusInternalServerError)
 		logError(r.Context(), "Failed to get project", err, slog.String("project_id", id))
 		return
 	}
 
 	if project == nil {
-		http.Error(w, "Project not found", http.StatusNotFound)
+		writeError(w, r, "Project not found", http.StatusNotFound)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(project)
+	writeJSON(w, r, http.StatusOK, project)
 }
 
 func listProjectsHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
 	projects, err := listProjects()
 	if err != nil {
-		http.Error(w, "Failed to list projects", http.StatusInternalServerError)
+		writeError(w, r, "Failed to list projects", http.StatusInternalServerError)
 		logError(r.Context(), "Failed to list projects", err)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(projects)
+	writeJSON(w, r, http.StatusOK, projects)
+}
+
+// projectSettingsKeptWhenOmitted copies each optional project setting, keyed by
+// its JSON name, from the stored project when a PUT body omits it
+var projectSettingsKeptWhenOmitted = map[string]func(updated, existing *Project){
+	"promptButtons":              func(updated, existing *Project) { updated.PromptButtons = existing.PromptButtons },
+	"parentProjectId":            func(updated, existing *Project) { updated.ParentProjectID = existing.ParentProjectID },
+	"captionApi":                 func(updated, existing *Project) { updated.CaptionAPI = existing.CaptionAPI },
+	"systemPrompt":               func(updated, existing *Project) { updated.SystemPrompt = existing.SystemPrompt },
+	"autoCaptionConfig":          func(updated, existing *Project) { updated.AutoCaptionConfig = existing.AutoCaptionConfig },
+	"captionLanguage":            func(updated, existing *Project) { updated.CaptionLanguage = existing.CaptionLanguage },
+	"defaultSimilarityThreshold": func(updated, existing *Project) { updated.DefaultSimilarityThreshold = existing.DefaultSimilarityThreshold },
+	"defaultMaxCandidates":       func(updated, existing *Project) { updated.DefaultMaxCandidates = existing.DefaultMaxCandidates },
+	"preservePromptWhitespace":   func(updated, existing *Project) { updated.PreservePromptWhitespace = existing.PreservePromptWhitespace },
+	"minWidth":                   func(updated, existing *Project) { updated.MinWidth = existing.MinWidth },
+	"minHeight":                  func(updated, existing *Project) { updated.MinHeight = existing.MinHeight },
+	"minAspectRatio":             func(updated, existing *Project) { updated.MinAspectRatio = existing.MinAspectRatio },
+	"maxAspectRatio":             func(updated, existing *Project) { updated.MaxAspectRatio = existing.MaxAspectRatio },
+	"editPromptSystemPrompt":     func(updated, existing *Project) { updated.EditPromptSystemPrompt = existing.EditPromptSystemPrompt },
+	"maxDimension":               func(updated, existing *Project) { updated.MaxDimension = existing.MaxDimension },
+	"captionTemplate":            func(updated, existing *Project) { updated.CaptionTemplate = existing.CaptionTemplate },
+	"triggerWord":                func(updated, existing *Project) { updated.TriggerWord = existing.TriggerWord },
+	"keepOriginal":               func(updated, existing *Project) { updated.KeepOriginal = existing.KeepOriginal },
+	"autoGenerateTasks":          func(updated, existing *Project) { updated.AutoGenerateTasks = existing.AutoGenerateTasks },
+	"captionStripPatterns":       func(updated, existing *Project) { updated.CaptionStripPatterns = existing.CaptionStripPatterns },
 }
 
 func updateProjectHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPut {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
 	id := r.URL.Path[len("/projects/"):]
 	if id == "" {
-		http.Error(w, "Project ID is required", http.StatusBadRequest)
+		writeError(w, r, "Project ID is required", http.StatusBadRequest)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, r, "Failed to read request body", http.StatusBadRequest)
 		return
 	}
 
 	var updatedProject Project
-	if err := json.NewDecoder(r.Body).Decode(&updatedProject); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if err := json.Unmarshal(body, &updatedProject); err != nil {
+		writeError(w, r, err.Error(), http.StatusBadRequest)
 		return
 	}
 
@@ -167,92 +311,195 @@ func updateProjectHandler(w http.ResponseWriter, r *http.Request) {
 	// Check if project exists
 	existingProject, err := getProject(id)
 	if err != nil {
-		http.Error(w, "Failed to get project", http.StatusInternalServerError)
+		writeError(w, r, "Failed to get project", http.StatusInternalServerError)
 		logError(r.Context(), "Failed to get project for update", err, slog.String("project_id", id))
 		return
 	}
 	if existingProject == nil {
-		http.Error(w, "Project not found", http.StatusNotFound)
+		writeError(w, r, "Project not found", http.StatusNotFound)
+		return
+	}
+
+	// Settings the request leaves out keep their stored value; the frontend's
+	// update calls only send the fields they edit. An explicit null still clears one.
+	var presentFields map[string]json.RawMessage
+	json.Unmarshal(body, &presentFields)
+	for field, keep := range projectSettingsKeptWhenOmitted {
+		if _, ok := presentFields[field]; !ok {
+			keep(&updatedProject, existingProject)
+		}
+	}
+
+	if err := validateProject(&updatedProject); err != nil {
+		writeError(w, r, err.Error(), http.StatusBadRequest)
 		return
 	}
 
 	if err := updateProject(&updatedProject); err != nil {
-		http.Error(w, "Failed to update project", http.StatusInternalServerError)
+		writeError(w, r, "Failed to update project", http.StatusInternalServerError)
 		logError(r.Context(), "Failed to update project", err, slog.String("project_id", id))
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(updatedProject)
+	// Return the updated project, with the timestamps the database set
+	project, err := getProject(id)
+	if err != nil {
+		writeError(w, r, "Failed to get updated project", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to get updated project", err, slog.String("project_id", id))
+		return
+	}
+	recordAudit(r.Context(), id, "update", "project", id, existingProject, project)
+
+	writeJSON(w, r, http.StatusOK, project)
 }
 
 func deleteProjectHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodDelete {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
 	id := r.URL.Path[len("/projects/"):]
 	if id == "" {
-		http.Error(w, "Project ID is required", http.StatusBadRequest)
+		writeError(w, r, "Project ID is required", http.StatusBadRequest)
 		return
 	}
 
 	// Check if project exists
 	existingProject, err := getProject(id)
 	if err != nil {
-		http.Error(w, "Failed to get project", http.StatusInternalServerError)
+		writeError(w, r, "Failed to get project", http.StatusInternalServerError)
 		logError(r.Context(), "Failed to get project for deletion", err, slog.String("project_id", id))
 		return
 	}
 	if existingProject == nil {
-		http.Error(w, "Project not found", http.StatusNotFound)
+		writeError(w, r, "Project not found", http.StatusNotFound)
+		return
+	}
+
+	blobHashes, err := getProjectBlobHashes(id)
+	if err != nil {
+		writeError(w, r, "Failed to delete project", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to get project blobs for deletion", err, slog.String("project_id", id))
 		return
 	}
 
 	if err := deleteProject(id); err != nil {
-		http.Error(w, "Failed to delete project", http.StatusInternalServerError)
+		writeError(w, r, "Failed to delete project", http.StatusInternalServerError)
 		logError(r.Context(), "Failed to delete project", err, slog.String("project_id", id))
 		return
 	}
+	releaseBlobs(blobHashes)
+	recordAudit(r.Context(), id, "delete", "project", id, existingProject, nil)
+	invalidateProjectStats(id)
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// parsePagination reads limit/offset query parameters, applying defaultLimit
+// when limit is absent and capping it at maxLimit
+func parsePagination(r *http.Request, defaultLimit, maxLimit int) (int, int, error) {
+	limit := defaultLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			return 0, 0, fmt.Errorf("limit must be a positive integer")
+		}
+		limit = min(n, maxLimit)
+	}
+
+	offset := 0
+	if value := r.URL.Query().Get("offset"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return 0, 0, fmt.Errorf("offset must be a non-negative integer")
+		}
+		offset = n
+	}
+
+	return limit, offset, nil
+}
+
+func getAuditLogHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// The project may already be deleted; its audit history is still readable
+	projectID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/projects/"), "/audit")
+	if projectID == "" {
+		writeError(w, r, "Project ID is required", http.StatusBadRequest)
+		return
+	}
+
+	limit, offset, err := parsePagination(r, 50, 500)
+	if err != nil {
+		writeError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	entries, total, err := getAuditLogByProjectID(projectID, limit, offset)
+	if err != nil {
+		writeError(w, r, "Failed to get audit log", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to get audit log", err, slog.String("project_id", projectID))
+		return
+	}
+
+	if entries == nil {
+		entries = []AuditLogEntry{}
+	}
+
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"entries": entries,
+		"total":   total,
+		"limit":   limit,
+		"offset":  offset,
+	})
+}
+
 func uploadHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
 	projectID := r.URL.Query().Get("projectId")
 	if projectID == "" {
-		http.Error(w, "Project ID is required", http.StatusBadRequest)
+		writeError(w, r, "Project ID is required", http.StatusBadRequest)
 		return
 	}
 
 	// Check if project exists
 	project, err := getProject(projectID)
 	if err != nil {
-		http.Error(w, "Failed to get project", http.StatusInternalServerError)
+		writeError(w, r, "Failed to get project", http.StatusInternalServerError)
 		logError(r.Context(), "Failed to get project for upload", err, slog.String("project_id", projectID))
 		return
 	}
 	if project == nil {
-		http.Error(w, "Project not found", http.StatusNotFound)
+		writeError(w, r, "Project not found", http.StatusNotFound)
 		return
 	}
 
 	// Parse multipart form
 	err = r.ParseMultipartForm(32 << 20) // 32MB max memory
 	if err != nil {
-		http.Error(w, "Error parsing multipart form", http.StatusBadRequest)
+		writeError(w, r, "Error parsing multipart form", http.StatusBadRequest)
 		return
 	}
 
 	files := r.MultipartForm.File["files"]
 	if len(files) == 0 {
-		http.Error(w, "No files provided", http.StatusBadRequest)
+		writeError(w, r, "No files provided", http.StatusBadRequest)
+		return
+	}
+
+	// Folder uploads send each file's relative path, since multipart
+	// filenames are reduced to their base name
+	paths, err := parseUploadPaths(r.MultipartForm.Value["paths"], len(files))
+	if err != nil {
+		writeError(w, r, err.Error(), http.StatusBadRequest)
 		return
 	}
 
@@ -260,7 +507,17 @@ func uploadHandler(w http.ResponseWriter, r *http.Request) {
 	projectDir := filepath.Join("data", "projects", projectID, "images")
 	err = os.MkdirAll(projectDir, 0755)
 	if err != nil {
-		http.Error(w, "Error creating project directory", http.StatusInternalServerError)
+		writeError(w, r, "Error creating project directory", http.StatusInternalServerError)
+		return
+	}
+
+	// Scripted clients can wait for the results instead of following SSE
+	if r.URL.Query().Get("sync") == "true" {
+		logInfo(r.Context(), "Synchronous upload started",
+			slog.String("project_id", projectID),
+			slog.Int("file_count", len(files)),
+		)
+		writeUploadResults(w, r, processUploadedFiles(project, files, paths, projectDir))
 		return
 	}
 
@@ -269,218 +526,518 @@ func uploadHandler(w http.ResponseWriter, r *http.Request) {
 		slog.String("project_id", projectID),
 		slog.Int("file_count", len(files)),
 	)
-	go processUploadedFiles(projectID, files, projectDir)
+	go processUploadedFiles(project, files, paths, projectDir)
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{
 		"message": "Upload started",
 		"count":   len(files),
 	})
 }
 
-func processUploadedFiles(projectID string, files []*multipart.FileHeader, projectDir string) {
-	total := len(files)
-	processedImages := make([]Image, 0, total)
+// uploadSource is one incoming image. Label identifies it in progress updates
+// (the filename for multipart uploads, the URL for URL ingestion).
+type uploadSource struct {
+	Label    string
+	Filename string
+	Size     int64 // Bytes to read, 0 if not known before reading
+	Read     func() ([]byte, error)
+}
+
+// UploadFileResult reports what happened to one file (or URL) of an upload
+type UploadFileResult struct {
+	Filename string `json:"filename"`
+	Status   string `json:"status"` // "created", "skipped", "rejected" or "error"
+	Error    string `json:"error,omitempty"`
+	Image    *Image `json:"image,omitempty"`
+}
 
+// writeUploadResults responds to a synchronous upload with the created images
+// and the outcome for every file
+func writeUploadResults(w http.ResponseWriter, r *http.Request, results []UploadFileResult) {
+	images := []Image{}
+	for _, result := range results {
+		if result.Image != nil {
+			images = append(images, *result.Image)
+		}
+	}
+
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"images":  images,
+		"results": results,
+	})
+}
+
+// processUploadedFiles ingests multipart files. paths, if not nil, holds each
+// file's validated relative path, which is kept under the images directory
+// in place of its bare filename.
+func processUploadedFiles(project *Project, files []*multipart.FileHeader, paths []string, projectDir string) []UploadFileResult {
+	sources := make([]uploadSource, 0, len(files))
 	for i, fileHeader := range files {
-		// Send progress update
-		sendProgressUpdate(projectID, ProgressUpdate{
-			ProjectID: projectID,
-			Filename:  fileHeader.Filename,
-			Progress:  i + 1,
-			Total:     total,
-			Status:    "processing",
+		fileHeader := fileHeader
+		filename := fileHeader.Filename
+		if paths != nil {
+			filename = filepath.FromSlash(paths[i])
+		}
+		sources = append(sources, uploadSource{
+			Label:    filename,
+			Filename: filename,
+			Size:     fileHeader.Size,
+			Read: func() ([]byte, error) {
+				file, err := fileHeader.Open()
+				if err != nil {
+					return nil, fmt.Errorf("Error opening file: %v", err)
+				}
+				defer file.Close()
+
+				content, err := io.ReadAll(file)
+				if err != nil {
+					return nil, fmt.Errorf("Error reading file: %v", err)
+				}
+				return content, nil
+			},
 		})
+	}
 
-		// Check if file already exists by path
-		imagePath := filepath.Join("images", fileHeader.Filename)
-		exists, err := imageExistsByPath(projectID, imagePath)
-		if err != nil {
-			sendProgressUpdate(projectID, ProgressUpdate{
-				ProjectID:    projectID,
-				Filename:     fileHeader.Filename,
-				Progress:     i + 1,
-				Total:        total,
-				Status:       "error",
-				ErrorMessage: fmt.Sprintf("Error checking existing file: %v", err),
-			})
-			continue
+	return processUploads(project, sources, projectDir)
+}
+
+// processUploads ingests each source in turn, reporting per-source progress
+// over SSE, and stores the accepted images in a single batch. If the batch
+// fails, UPLOAD_FAILURE_POLICY decides whether each image is retried alone;
+// files of images left unstored are removed. Projects with autoGenerateTasks
+// then get tasks for the stored images. It returns the outcome for every
+// source in order.
+func processUploads(project *Project, sources []uploadSource, projectDir string) []UploadFileResult {
+	projectID := project.ID
+	total := len(sources)
+	sessionID := startUploadSession(projectID, total)
+	defer endUploadSession(sessionID)
+
+	// Blobs written for images that end up not being stored are released once
+	// the batch is done. Until then no blob may be released, since the rows
+	// referencing this batch's blobs aren't stored yet.
+	var unstoredBlobs []string
+	defer func() { releaseBlobs(unstoredBlobs) }()
+	var blobLock blobReadLock
+	defer blobLock.release()
+	processedImages := make([]Image, 0, total)
+	results := make([]UploadFileResult, 0, total)
+	acceptedFilenames := make(map[string]bool)
+
+	// Byte totals are only reported when every source's size is known, as
+	// for multipart files; URL uploads only learn it once fetched
+	var totalBytes, bytesProcessed int64
+	for _, source := range sources {
+		if source.Size <= 0 {
+			totalBytes = 0
+			break
 		}
+		totalBytes += source.Size
+	}
 
-		if exists {
-			logger.Info("Skipping duplicate file",
-				"project_id", projectID,
-				"filename", fileHeader.Filename,
-			)
-			sendProgressUpdate(projectID, ProgressUpdate{
-				ProjectID:    projectID,
-				Filename:     fileHeader.Filename,
-				Progress:     i + 1,
-				Total:        total,
-				Status:       "skipped",
-				ErrorMessage: "File already exists",
+	for i, source := range sources {
+		updateUploadSession(sessionID, i+1, source.Label)
+
+		// Send progress update
+		reportUploadProgress(sessionID, ProgressUpdate{
+			ProjectID:      projectID,
+			Filename:       source.Label,
+			Progress:       i + 1,
+			Total:          total,
+			Status:         "processing",
+			BytesProcessed: bytesProcessed,
+			TotalBytes:     totalBytes,
+		})
+
+		// A later file with the same name would overwrite the file of one
+		// already accepted, before either row is stored
+		var imageRecord *Image
+		var skipReason string
+		var err error
+		if acceptedFilenames[source.Filename] {
+			skipReason = "Duplicate filename in this upload"
+		} else {
+			imageRecord, skipReason, err = ingestImageSafely(project, source, projectDir, &blobLock)
+		}
+		if totalBytes > 0 {
+			bytesProcessed += source.Size
+		}
+		var rejection *imageRejectedError
+		if errors.As(err, &rejection) {
+			results = append(results, UploadFileResult{Filename: source.Label, Status: "rejected", Error: rejection.reason})
+			reportUploadProgress(sessionID, ProgressUpdate{
+				ProjectID:      projectID,
+				Filename:       source.Label,
+				Progress:       i + 1,
+				Total:          total,
+				Status:         "rejected",
+				ErrorMessage:   rejection.reason,
+				BytesProcessed: bytesProcessed,
+				TotalBytes:     totalBytes,
 			})
 			continue
 		}
-
-		// Open uploaded file
-		file, err := fileHeader.Open()
 		if err != nil {
-			sendProgressUpdate(projectID, ProgressUpdate{
-				ProjectID:    projectID,
-				Filename:     fileHeader.Filename,
-				Progress:     i + 1,
-				Total:        total,
-				Status:       "error",
-				ErrorMessage: fmt.Sprintf("Error opening file: %v", err),
+			results = append(results, UploadFileResult{Filename: source.Label, Status: "error", Error: err.Error()})
+			reportUploadProgress(sessionID, ProgressUpdate{
+				ProjectID:      projectID,
+				Filename:       source.Label,
+				Progress:       i + 1,
+				Total:          total,
+				Status:         "error",
+				ErrorMessage:   err.Error(),
+				BytesProcessed: bytesProcessed,
+				TotalBytes:     totalBytes,
 			})
 			continue
 		}
-
-		// Read file content
-		content, err := io.ReadAll(file)
-		file.Close()
-		if err != nil {
-			sendProgressUpdate(projectID, ProgressUpdate{
-				ProjectID:    projectID,
-				Filename:     fileHeader.Filename,
-				Progress:     i + 1,
-				Total:        total,
-				Status:       "error",
-				ErrorMessage: fmt.Sprintf("Error reading file: %v", err),
+		if skipReason != "" {
+			results = append(results, UploadFileResult{Filename: source.Label, Status: "skipped", Error: skipReason})
+			reportUploadProgress(sessionID, ProgressUpdate{
+				ProjectID:      projectID,
+				Filename:       source.Label,
+				Progress:       i + 1,
+				Total:          total,
+				Status:         "skipped",
+				ErrorMessage:   skipReason,
+				BytesProcessed: bytesProcessed,
+				TotalBytes:     totalBytes,
 			})
 			continue
 		}
 
-		// Validate image
-		reader := strings.NewReader(string(content))
-		img, _, err := image.Decode(reader)
+		acceptedFilenames[source.Filename] = true
+		processedImages = append(processedImages, *imageRecord)
+		results = append(results, UploadFileResult{Filename: source.Label, Status: "created", Image: imageRecord})
+	}
+
+	// Store images in database
+	if len(processedImages) > 0 {
+		inserted, duplicates, err := createImages(processedImages)
 		if err != nil {
-			logger.Error("Invalid image format",
+			logger.Error("Error storing images in database",
 				"error", err,
 				"project_id", projectID,
-				"filename", fileHeader.Filename,
+				"image_count", len(processedImages),
+				"failure_policy", appConfig.UploadFailurePolicy,
 			)
-			sendProgressUpdate(projectID, ProgressUpdate{
-				ProjectID:    projectID,
-				Filename:     fileHeader.Filename,
-				Progress:     i + 1,
-				Total:        total,
-				Status:       "error",
-				ErrorMessage: fmt.Sprintf("Invalid image format: %v", err),
-			})
-			continue
-		}
 
-		// Compute pHash
-		hash, err := goimagehash.PerceptionHash(img)
-		if err != nil {
-			sendProgressUpdate(projectID, ProgressUpdate{
-				ProjectID:    projectID,
-				Filename:     fileHeader.Filename,
-				Progress:     i + 1,
-				Total:        total,
-				Status:       "error",
-				ErrorMessage: fmt.Sprintf("Error computing hash: %v", err),
-			})
-			continue
-		}
+			// The batch rolled back; salvage what stores on its own, or none of it
+			failures := make(map[string]error)
+			if appConfig.UploadFailurePolicy == uploadFailureSalvage {
+				inserted, duplicates, failures = salvageImages(processedImages)
+			} else {
+				inserted, duplicates = 0, map[string]bool{}
+				for _, image := range processedImages {
+					failures[image.ID] = err
+				}
+			}
 
-		// Check if similar image exists by hash
-		hashExists, err := imageExistsByHash(projectID, hash.ToString(), 0)
-		if err != nil {
-			logger.Warn("Error checking hash duplicates",
-				"error", err,
+			for i := range results {
+				if results[i].Image == nil {
+					continue
+				}
+				if _, failed := failures[results[i].Image.ID]; !failed {
+					continue
+				}
+				removeUnstoredImageFiles(results[i].Image)
+				if results[i].Image.BlobHash != "" {
+					unstoredBlobs = append(unstoredBlobs, results[i].Image.BlobHash)
+				}
+				results[i] = UploadFileResult{Filename: results[i].Filename, Status: "error", Error: "Failed to store image in database"}
+				reportUploadProgress(sessionID, ProgressUpdate{
+					ProjectID:      projectID,
+					Filename:       results[i].Filename,
+					Progress:       total,
+					Total:          total,
+					Status:         "error",
+					ErrorMessage:   "Failed to store image in database",
+					BytesProcessed: bytesProcessed,
+					TotalBytes:     totalBytes,
+				})
+			}
+			logger.Warn("Reconciled failed image batch",
 				"project_id", projectID,
-				"filename", fileHeader.Filename,
+				"stored_count", inserted,
+				"failed_count", len(failures),
 			)
-		} else if hashExists {
-			logger.Info("Skipping duplicate image by hash",
+		}
+
+		// A concurrent upload of the same file can be stored between our path
+		// check and the insert; those rows were skipped rather than failing the batch
+		for i := range results {
+			if results[i].Image == nil || !duplicates[results[i].Image.ID] {
+				continue
+			}
+			if results[i].Image.BlobHash != "" {
+				unstoredBlobs = append(unstoredBlobs, results[i].Image.BlobHash)
+			}
+			logger.Info("Skipping file stored by a concurrent upload",
 				"project_id", projectID,
-				"filename", fileHeader.Filename,
+				"filename", results[i].Filename,
 			)
-			sendProgressUpdate(projectID, ProgressUpdate{
-				ProjectID:    projectID,
-				Filename:     fileHeader.Filename,
-				Progress:     i + 1,
-				Total:        total,
-				Status:       "skipped",
-				ErrorMessage: "Similar image already exists",
+			results[i] = UploadFileResult{Filename: results[i].Filename, Status: "skipped", Error: "File already exists"}
+			reportUploadProgress(sessionID, ProgressUpdate{
+				ProjectID:      projectID,
+				Filename:       results[i].Filename,
+				Progress:       total,
+				Total:          total,
+				Status:         "skipped",
+				ErrorMessage:   "File already exists",
+				BytesProcessed: bytesProcessed,
+				TotalBytes:     totalBytes,
 			})
-			continue
 		}
 
-		// Save file to disk
-		filename := fileHeader.Filename
-		filePath := filepath.Join(projectDir, filename)
-		destFile, err := os.Create(filePath)
-		if err != nil {
-			sendProgressUpdate(projectID, ProgressUpdate{
-				ProjectID:    projectID,
-				Filename:     fileHeader.Filename,
-				Progress:     i + 1,
-				Total:        total,
-				Status:       "error",
-				ErrorMessage: fmt.Sprintf("Error creating file: %v", err),
-			})
-			continue
-		}
+		invalidateProjectStats(projectID)
+		logger.Info("Images stored successfully",
+			"project_id", projectID,
+			"image_count", inserted,
+			"duplicate_count", len(duplicates),
+		)
+	}
+	// Every blob this batch wrote is now referenced or listed in unstoredBlobs
+	blobLock.release()
 
-		_, err = destFile.Write(content)
-		destFile.Close()
-		if err != nil {
-			sendProgressUpdate(projectID, ProgressUpdate{
-				ProjectID:    projectID,
-				Filename:     fileHeader.Filename,
-				Progress:     i + 1,
-				Total:        total,
-				Status:       "error",
-				ErrorMessage: fmt.Sprintf("Error writing file: %v", err),
-			})
-			continue
+	// Generate tasks for the images this upload stored
+	var tasksCreated int
+	if project.AutoGenerateTasks {
+		imageIDs := make(map[string]bool)
+		for _, result := range results {
+			if result.Image != nil {
+				imageIDs[result.Image.ID] = true
+			}
 		}
+		if len(imageIDs) > 0 {
+			var err error
+			tasksCreated, err = generateTasksForUpload(project, imageIDs)
+			if err != nil {
+				logger.Error("Error generating tasks for uploaded images",
+					"error", err,
+					"project_id", projectID,
+					"image_count", len(imageIDs),
+				)
+			} else {
+				logger.Info("Tasks generated for uploaded images",
+					"project_id", projectID,
+					"image_count", len(imageIDs),
+					"tasks_created", tasksCreated,
+				)
+			}
+		}
+	}
 
-		// Create image record
-		imageRecord := Image{
-			ID:        uuid.New().String(),
-			ProjectID: projectID,
-			Path:      imagePath,
-			PHash:     hash.ToString(),
+	// Send completion update
+	reportUploadProgress(sessionID, ProgressUpdate{
+		ProjectID:      projectID,
+		Progress:       total,
+		Total:          total,
+		Status:         "completed",
+		BytesProcessed: bytesProcessed,
+		TotalBytes:     totalBytes,
+		TasksCreated:   tasksCreated,
+	})
+
+	return results
+}
+
+// imageRejectedError is returned by ingestImage when an image decodes fine but
+// fails the project's dimension or aspect ratio constraints, or is in a format
+// ALLOWED_IMAGE_FORMATS leaves out
+type imageRejectedError struct {
+	reason string
+}
+
+func (e *imageRejectedError) Error() string {
+	return e.reason
+}
+
+// ingestImageSafely runs ingestImage, turning a panic in the image decoders
+// (which some malformed files trigger) into an error for that file alone
+func ingestImageSafely(project *Project, source uploadSource, projectDir string, blobLock *blobReadLock) (imageRecord *Image, skipReason string, err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			logger.Error("Recovered from panic while processing image",
+				"panic", fmt.Sprint(recovered),
+				"project_id", project.ID,
+				"filename", source.Label,
+				"stack", string(debug.Stack()),
+			)
+			imageRecord, skipReason = nil, ""
+			err = fmt.Errorf("Invalid image format: decoder failed on malformed data: %v", recovered)
 		}
+	}()
+	return ingestImage(project, source, projectDir, blobLock)
+}
 
-		processedImages = append(processedImages, imageRecord)
+// ingestImage validates, hashes and saves one source, returning the image
+// record to store. Duplicates are reported through skipReason rather than err.
+// blobLock is taken before the first blob is written.
+func ingestImage(project *Project, source uploadSource, projectDir string, blobLock *blobReadLock) (*Image, string, error) {
+	projectID := project.ID
+	// Check if file already exists by path. Retried batches skip files that
+	// were saved before without reading or decoding them again.
+	imagePath := filepath.Join("images", source.Filename)
+	existing, err := getImageByPath(projectID, imagePath)
+	if err != nil {
+		return nil, "", fmt.Errorf("Error checking existing file: %v", err)
 	}
-
-	// Store images in database
-	if len(processedImages) > 0 {
-		if err := createImages(processedImages); err != nil {
-			logger.Error("Error storing images in database",
-				"error", err,
+	if existing != nil {
+		if _, err := os.Stat(imageFilePath(existing)); err == nil {
+			logger.Info("Skipping duplicate file",
 				"project_id", projectID,
-				"image_count", len(processedImages),
+				"filename", source.Filename,
 			)
-			sendProgressUpdate(projectID, ProgressUpdate{
-				ProjectID:    projectID,
-				Progress:     total,
-				Total:        total,
-				Status:       "error",
-				ErrorMessage: "Failed to store images in database",
-			})
-			return
+			return nil, "File already exists", nil
 		}
-		logger.Info("Images stored successfully",
+	}
+
+	content, err := source.Read()
+	if err != nil {
+		return nil, "", err
+	}
+
+	// The row was stored but its file is gone, so this upload restores it
+	if existing != nil {
+		if err := restoreImageFile(project, existing, content); err != nil {
+			return nil, "", err
+		}
+		logger.Info("Restored missing file for existing image",
 			"project_id", projectID,
-			"image_count", len(processedImages),
+			"image_id", existing.ID,
+			"filename", source.Filename,
 		)
+		return nil, "File already exists; restored its missing file", nil
 	}
 
-	// Send completion update
-	sendProgressUpdate(projectID, ProgressUpdate{
+	// Validate image, taking the first frame of animated inputs
+	img, format, animated, err := decodeFirstFrame(content)
+	if err != nil {
+		logger.Error("Invalid image format",
+			"error", err,
+			"project_id", projectID,
+			"filename", source.Filename,
+		)
+		return nil, "", fmt.Errorf("Invalid image format: %v", err)
+	}
+	if !imageFormatAllowed(format) {
+		reason := fmt.Sprintf("Image format %s is not allowed; accepted formats: %s", format, strings.Join(appConfig.AllowedImageFormats, ", "))
+		logger.Info("Rejecting image in a disallowed format",
+			"project_id", projectID,
+			"filename", source.Filename,
+			"format", format,
+		)
+		return nil, "", &imageRejectedError{reason: reason}
+	}
+
+	// Enforce the project's size and aspect ratio limits before anything is saved
+	bounds := img.Bounds()
+	if reason := checkImageConstraints(project, bounds.Dx(), bounds.Dy()); reason != "" {
+		logger.Info("Rejecting image outside project constraints",
+			"project_id", projectID,
+			"filename", source.Filename,
+			"reason", reason,
+		)
+		return nil, "", &imageRejectedError{reason: reason}
+	}
+	originalWidth, originalHeight := bounds.Dx(), bounds.Dy()
+
+	// Downscale oversized uploads; the stored file and its hash use the smaller version
+	var originalContent []byte
+	if project.MaxDimension != nil && !animated {
+		resized, err := downscaleImage(img, format, *project.MaxDimension)
+		if err != nil {
+			return nil, "", fmt.Errorf("Error resizing image: %v", err)
+		}
+		if resized != nil {
+			if project.KeepOriginal {
+				originalContent = content
+			}
+			img, content = resized.img, resized.content
+		}
+	}
+
+	// Byte-identical files are caught by their SHA-256 without computing a pHash
+	contentHash := hashContent(content)
+	identical, err := getImageByContentHash(projectID, contentHash)
+	if err != nil {
+		logger.Warn("Error checking exact duplicates",
+			"error", err,
+			"project_id", projectID,
+			"filename", source.Filename,
+		)
+	} else if identical != nil {
+		logger.Info("Skipping exact duplicate image",
+			"project_id", projectID,
+			"filename", source.Filename,
+			"duplicate_of", identical.ID,
+		)
+		return nil, "Identical image already exists", nil
+	}
+
+	// Compute pHash, and the dHash that generation can combine with it
+	hash, dHash, err := computePerceptualHashes(img)
+	if err != nil {
+		return nil, "", fmt.Errorf("Error computing hash: %v", err)
+	}
+
+	// Check if similar image exists by hash
+	hashExists, err := imageExistsByHash(projectID, hash.ToString(), 0)
+	if err != nil {
+		logger.Warn("Error checking hash duplicates",
+			"error", err,
+			"project_id", projectID,
+			"filename", source.Filename,
+		)
+	} else if hashExists {
+		logger.Info("Skipping duplicate image by hash",
+			"project_id", projectID,
+			"filename", source.Filename,
+		)
+		return nil, "Similar image already exists", nil
+	}
+
+	imageRecord := &Image{
+		ID:        uuid.New().String(),
 		ProjectID: projectID,
-		Progress:  total,
-		Total:     total,
-		Status:    "completed",
-	})
+		Path:      imagePath,
+		PHash:     hash.ToString(),
+		Animated:  animated,
+		DHash:          dHash.ToString(),
+		ContentHash:    contentHash,
+		OriginalWidth:  &originalWidth,
+		OriginalHeight: &originalHeight,
+	}
+
+	// Archive the full-size upload before it's lost to the downscale
+	if originalContent != nil {
+		originalPath, err := storeOriginalFile(projectID, source.Filename, originalContent)
+		if err != nil {
+			return nil, "", err
+		}
+		imageRecord.OriginalPath = &originalPath
+	}
+
+	// Save file to disk, once per distinct content when blobs are enabled
+	if appConfig.ContentAddressedStorage {
+		blobLock.acquire()
+		blobHash, err := storeBlob(content)
+		if err != nil {
+			return nil, "", fmt.Errorf("Error writing file: %v", err)
+		}
+		imageRecord.BlobHash = blobHash
+	} else {
+		filePath := filepath.Join(projectDir, source.Filename)
+		// Folder uploads keep their subdirectories
+		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+			return nil, "", fmt.Errorf("Error creating directory: %v", err)
+		}
+		if err := os.WriteFile(filePath, content, 0644); err != nil {
+			return nil, "", fmt.Errorf("Error writing file: %v", err)
+		}
+	}
+
+	return imageRecord, "", nil
 }
 
 func sendProgressUpdate(projectID string, update ProgressUpdate) {
@@ -528,13 +1085,13 @@ func getExportStatus(projectID string) *ExportStatus {
 
 func progressHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
 	projectID := r.URL.Query().Get("projectId")
 	if projectID == "" {
-		http.Error(w, "Project ID is required", http.StatusBadRequest)
+		writeError(w, r, "Project ID is required", http.StatusBadRequest)
 		return
 	}
 
@@ -558,6 +1115,13 @@ func progressHandler(w http.ResponseWriter, r *http.Request) {
 		close(progressCh)
 	}()
 
+	// Catch a reconnecting client up on uploads already running
+	for _, update := range getUploadProgress(projectID) {
+		data, _ := json.Marshal(update)
+		fmt.Fprintf(w, "data: %s\n\n", data)
+	}
+	w.(http.Flusher).Flush()
+
 	// Send events to client
 	for {
 		select {
@@ -573,19 +1137,53 @@ func progressHandler(w http.ResponseWriter, r *http.Request) {
 
 func getImagesHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
 	projectID := r.URL.Query().Get("projectId")
 	if projectID == "" {
-		http.Error(w, "Project ID is required", http.StatusBadRequest)
+		writeError(w, r, "Project ID is required", http.StatusBadRequest)
 		return
 	}
 
-	projectImages, err := getImagesByProjectID(projectID)
+	// hasTask filters on whether the image is already image A of a task
+	var hasTask *bool
+	switch r.URL.Query().Get("hasTask") {
+	case "":
+	case "true", "false":
+		value := r.URL.Query().Get("hasTask") == "true"
+		hasTask = &value
+	default:
+		writeError(w, r, "hasTask must be true or false", http.StatusBadRequest)
+		return
+	}
+
+	// Without after every image is returned, as before pagination existed
+	var projectImages []Image
+	var err error
+	var nextCursor *string
+	useCursor := wantsCursorPagination(r)
+	if useCursor {
+		var limit int
+		var after *pageCursor
+		limit, after, err = parseCursorPagination(r, 100, 1000)
+		if err != nil {
+			writeError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+		projectImages, err = getImagesPageAfter(projectID, hasTask, after, limit)
+		if err == nil && len(projectImages) > 0 {
+			last := projectImages[len(projectImages)-1]
+			nextCursor = nextPageCursor(w, len(projectImages), limit, last.CreatedAt, last.ID)
+		}
+	} else if hasTask == nil {
+		projectImages, err = getImagesByProjectID(projectID)
+	} else {
+		projectImages, err = getImagesByTaskPresence(projectID, *hasTask)
+	}
 	if err != nil {
-		http.Error(w, "Failed to get images", http.StatusInternalServerError)
+		writeError(w, r, "Failed to get images", http.StatusInternalServerError)
 		logError(r.Context(), "Failed to get images", err, slog.String("project_id", projectID))
 		return
 	}
@@ -593,14 +1191,87 @@ func getImagesHandler(w http.ResponseWriter, r *http.Request) {
 	if projectImages == nil {
 		projectImages = []Image{}
 	}
+	if err := attachImageMetadata(projectID, projectImages); err != nil {
+		writeError(w, r, "Failed to get image metadata", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to get image metadata", err, slog.String("project_id", projectID))
+		return
+	}
+
+	if useCursor {
+		writeJSON(w, r, http.StatusOK, cursorPage{Items: projectImages, NextCursor: nextCursor})
+		return
+	}
+	writeJSON(w, r, http.StatusOK, projectImages)
+}
+
+func sampleImagesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	projectID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/projects/"), "/images/sample")
+	if projectID == "" {
+		writeError(w, r, "Project ID is required", http.StatusBadRequest)
+		return
+	}
+
+	n := 12
+	if value := r.URL.Query().Get("n"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			writeError(w, r, "n must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		n = min(parsed, 100)
+	}
+
+	// mode=even gives a repeatable spread through upload order instead of a random pick
+	mode := r.URL.Query().Get("mode")
+	if mode != "" && mode != "random" && mode != "even" {
+		writeError(w, r, "mode must be \"random\" or \"even\"", http.StatusBadRequest)
+		return
+	}
+	// A seed makes the random pick reproducible: the same seed gives the same sample
+	seed := r.URL.Query().Get("seed")
+	if seed != "" && mode == "even" {
+		writeError(w, r, "seed only applies to mode=random", http.StatusBadRequest)
+		return
+	}
+
+	project, err := getProject(projectID)
+	if err != nil {
+		writeError(w, r, "Failed to get project", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to get project for image sample", err, slog.String("project_id", projectID))
+		return
+	}
+	if project == nil {
+		writeError(w, r, "Project not found", http.StatusNotFound)
+		return
+	}
+
+	var images []Image
+	if seed != "" {
+		images, err = sampleImagesSeeded(projectID, n, seed)
+	} else {
+		images, err = sampleImages(projectID, n, mode == "even")
+	}
+	if err != nil {
+		writeError(w, r, "Failed to get images", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to sample images", err, slog.String("project_id", projectID))
+		return
+	}
+
+	if images == nil {
+		images = []Image{}
+	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(projectImages)
+	writeJSON(w, r, http.StatusOK, images)
 }
 
 func deleteImageHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodDelete {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
@@ -608,7 +1279,7 @@ func deleteImageHandler(w http.ResponseWriter, r *http.Request) {
 	// URL format: /projects/{projectId}/images/{imageId}
 	pathParts := strings.Split(strings.TrimPrefix(r.URL.Path, "/projects/"), "/")
 	if len(pathParts) < 3 || pathParts[1] != "images" {
-		http.Error(w, "Invalid image path", http.StatusBadRequest)
+		writeError(w, r, "Invalid image path", http.StatusBadRequest)
 		return
 	}
 
@@ -618,47 +1289,61 @@ func deleteImageHandler(w http.ResponseWriter, r *http.Request) {
 	// Check if project exists
 	project, err := getProject(projectID)
 	if err != nil {
-		http.Error(w, "Failed to get project", http.StatusInternalServerError)
+		writeError(w, r, "Failed to get project", http.StatusInternalServerError)
 		logError(r.Context(), "Failed to get project for image deletion", err, slog.String("project_id", projectID))
 		return
 	}
 	if project == nil {
-		http.Error(w, "Project not found", http.StatusNotFound)
+		writeError(w, r, "Project not found", http.StatusNotFound)
 		return
 	}
 
 	// Get image details before deletion
 	image, err := getImage(imageID)
 	if err != nil {
-		http.Error(w, "Failed to get image", http.StatusInternalServerError)
+		writeError(w, r, "Failed to get image", http.StatusInternalServerError)
 		logError(r.Context(), "Failed to get image for deletion", err, slog.String("image_id", imageID))
 		return
 	}
 	if image == nil {
-		http.Error(w, "Image not found", http.StatusNotFound)
+		writeError(w, r, "Image not found", http.StatusNotFound)
 		return
 	}
 
 	// Verify image belongs to the project
 	if image.ProjectID != projectID {
-		http.Error(w, "Image does not belong to this project", http.StatusBadRequest)
+		writeError(w, r, "Image does not belong to this project", http.StatusBadRequest)
 		return
 	}
 
-	// Delete image file from disk
-	filePath := filepath.Join("data", "projects", projectID, image.Path)
-	if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
-		logError(r.Context(), "Failed to delete image file", err, 
-			slog.String("file_path", filePath),
-			slog.String("image_id", imageID))
+	// Delete image file from disk; shared blobs are released once the row is gone
+	if image.BlobHash == "" {
+		filePath := filepath.Join("data", "projects", projectID, image.Path)
+		if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+			logError(r.Context(), "Failed to delete image file", err, 
+				slog.String("file_path", filePath),
+				slog.String("image_id", imageID))
+		}
+	}
+	if originalPath := originalFilePath(image); originalPath != "" {
+		if err := os.Remove(originalPath); err != nil && !os.IsNotExist(err) {
+			logError(r.Context(), "Failed to delete original image file", err,
+				slog.String("file_path", originalPath),
+				slog.String("image_id", imageID))
+		}
 	}
+	removeCachedThumbnails(projectID, strings.TrimPrefix(image.Path, "images/"))
 
 	// Delete image from database (this will cascade delete related tasks)
 	if err := deleteImage(imageID); err != nil {
-		http.Error(w, "Failed to delete image", http.StatusInternalServerError)
+		writeError(w, r, "Failed to delete image", http.StatusInternalServerError)
 		logError(r.Context(), "Failed to delete image from database", err, slog.String("image_id", imageID))
 		return
 	}
+	if image.BlobHash != "" {
+		releaseBlobs([]string{image.BlobHash})
+	}
+	invalidateProjectStats(projectID)
 
 	logInfo(r.Context(), "Image deleted successfully", 
 		slog.String("project_id", projectID),
@@ -668,40 +1353,195 @@ func deleteImageHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// projectFileExists reports whether a project-relative path is taken on disk
+func projectFileExists(projectID, path string) bool {
+	_, err := os.Stat(filepath.Join("data", "projects", projectID, path))
+	return !os.IsNotExist(err)
+}
+
+// availableImagePath returns a project-relative image path for filename that
+// doesn't collide with an existing image row, image file or archived original,
+// appending _1, _2, ... to the base name as needed.
+func availableImagePath(projectID, filename string) (string, error) {
+	ext := filepath.Ext(filename)
+	base := strings.TrimSuffix(filename, ext)
+	candidate := filename
+	for i := 1; ; i++ {
+		imagePath := filepath.Join("images", candidate)
+		exists, err := imageExistsByPath(projectID, imagePath)
+		if err != nil {
+			return "", err
+		}
+		if !exists && !projectFileExists(projectID, imagePath) && !projectFileExists(projectID, filepath.Join("originals", candidate)) {
+			return imagePath, nil
+		}
+		candidate = fmt.Sprintf("%s_%d%s", base, i, ext)
+	}
+}
+
+func moveImageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	imageID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/images/"), "/move")
+	if imageID == "" {
+		writeError(w, r, "Image ID is required", http.StatusBadRequest)
+		return
+	}
+
+	targetProjectID := r.URL.Query().Get("projectId")
+	if targetProjectID == "" {
+		writeError(w, r, "Target project ID is required", http.StatusBadRequest)
+		return
+	}
+
+	// Tasks using the image are deleted by default, or carried over with "reassign"
+	taskPolicy := r.URL.Query().Get("tasks")
+	if taskPolicy == "" {
+		taskPolicy = "delete"
+	}
+	if taskPolicy != "delete" && taskPolicy != "reassign" {
+		writeError(w, r, "tasks must be \"delete\" or \"reassign\"", http.StatusBadRequest)
+		return
+	}
+
+	image, err := getImage(imageID)
+	if err != nil {
+		writeError(w, r, "Failed to get image", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to get image for move", err, slog.String("image_id", imageID))
+		return
+	}
+	if image == nil {
+		writeError(w, r, "Image not found", http.StatusNotFound)
+		return
+	}
+	if image.ProjectID == targetProjectID {
+		writeError(w, r, "Image already belongs to this project", http.StatusBadRequest)
+		return
+	}
+
+	targetProject, err := getProject(targetProjectID)
+	if err != nil {
+		writeError(w, r, "Failed to get project", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to get target project for image move", err, slog.String("project_id", targetProjectID))
+		return
+	}
+	if targetProject == nil {
+		writeError(w, r, "Target project not found", http.StatusNotFound)
+		return
+	}
+
+	// Rename on collision to respect the unique (project_id, path) constraint
+	newPath, err := availableImagePath(targetProjectID, filepath.Base(image.Path))
+	if err != nil {
+		writeError(w, r, "Failed to resolve target path", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to resolve target path for image move", err, slog.String("image_id", imageID))
+		return
+	}
+
+	sourceProjectID := image.ProjectID
+	var files []fileMove
+	if image.BlobHash == "" {
+		// A blob stays where it is; only the row changes project
+		files = append(files, fileMove{
+			src: filepath.Join("data", "projects", sourceProjectID, image.Path),
+			dst: filepath.Join("data", "projects", targetProjectID, newPath),
+		})
+	}
+	// Originals always live under the project, named after the image
+	var newOriginalPath *string
+	if image.OriginalPath != nil {
+		originalPath := filepath.Join("originals", filepath.Base(newPath))
+		newOriginalPath = &originalPath
+		files = append(files, fileMove{
+			src: originalFilePath(image),
+			dst: filepath.Join("data", "projects", targetProjectID, originalPath),
+		})
+	}
+
+	if err := moveImage(imageID, targetProjectID, newPath, newOriginalPath, taskPolicy == "reassign", files); err != nil {
+		writeError(w, r, "Failed to move image", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to move image", err,
+			slog.String("image_id", imageID),
+			slog.String("target_project_id", targetProjectID))
+		return
+	}
+	removeCachedThumbnails(sourceProjectID, strings.TrimPrefix(image.Path, "images/"))
+	invalidateProjectStats(sourceProjectID)
+	invalidateProjectStats(targetProjectID)
+
+	logInfo(r.Context(), "Image moved successfully",
+		slog.String("image_id", imageID),
+		slog.String("source_project_id", sourceProjectID),
+		slog.String("target_project_id", targetProjectID),
+		slog.String("path", newPath),
+		slog.String("task_policy", taskPolicy))
+
+	image.ProjectID = targetProjectID
+	image.Path = newPath
+	image.OriginalPath = newOriginalPath
+
+	writeJSON(w, r, http.StatusOK, image)
+}
+
 type SimilarImage struct {
-	Image    Image
-	Distance int
+	Image           Image    `json:"image"`
+	Distance        int      `json:"distance"`
+	PixelDifference *float64 `json:"pixelDifference,omitempty"` // Set when the match was verified by decoding both images
 }
 
+
 type TaskGenerationRequest struct {
-	SimilarityThreshold int `json:"similarityThreshold"`
-	MaxCandidates       int `json:"maxCandidates"`
+	SimilarityThreshold int          `json:"similarityThreshold"`
+	MaxCandidates       int          `json:"maxCandidates"`
+	ExclusiveBImages    bool         `json:"exclusiveBImages"`   // Offer each image as a candidate B at most once
+	MinCandidates       int          `json:"minCandidates"`      // Images with fewer candidates get no task; 0 keeps them all
+	ImageAfter          string       `json:"imageAfter"`         // RFC3339; only images uploaded after it become image A, candidates still come from every image
+	Verify              bool         `json:"verify"`             // Decode each hash match and drop those whose pixels differ by more than maxPixelDifference
+	MaxPixelDifference  *float64     `json:"maxPixelDifference"` // 0 to 1, defaults to 0.1 when omitted; only used with verify
+	CandidateStrategy   string       `json:"candidateStrategy"`  // "nearest" (default) or "diverse"
+	HashWeights         *HashWeights `json:"hashWeights"`        // Weights combining pHash and dHash distances; pHash alone when omitted
 }
 
 type TaskGenerationResponse struct {
-	TasksCreated      int     `json:"tasksCreated"`
-	AverageCandidates float64 `json:"averageCandidates"`
+	TasksCreated                  int     `json:"tasksCreated"`
+	AverageCandidates             float64 `json:"averageCandidates"`
+	SkippedInsufficientCandidates int     `json:"skippedInsufficientCandidates"` // Images left without a task for having fewer than minCandidates
+	RejectedByVerification        int     `json:"rejectedByVerification"`        // Hash matches dropped by the pixel check, with verify
 }
 
 func parseImageHash(hashString string) (*goimagehash.ImageHash, error) {
 	return goimagehash.ImageHashFromString(hashString)
 }
 
-func findSimilarImages(targetImage Image, allImages []Image, threshold int) ([]SimilarImage, error) {
+// findSimilarImages returns the images within threshold of targetImage,
+// closest first. Distances combine the pHash and dHash distances by weights;
+// a pair where either image has no dHash yet is compared by pHash alone.
+// Excluded images are never returned.
+func findSimilarImages(targetImage Image, allImages []Image, threshold int, weights HashWeights) ([]SimilarImage, error) {
 	targetHash, err := parseImageHash(targetImage.PHash)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse target hash: %v", err)
 	}
+	var targetDHash *goimagehash.ImageHash
+	if weights.usesDHash() && targetImage.DHash != "" {
+		if targetDHash, err = parseImageHash(targetImage.DHash); err != nil {
+			targetDHash = nil
+		}
+	}
 
 	var similar []SimilarImage
+	scores := make(map[string]float64)
 	for _, img := range allImages {
-		if img.ID == targetImage.ID {
+		if img.ID == targetImage.ID || img.Excluded {
 			continue
 		}
 
 		imgHash, err := parseImageHash(img.PHash)
 		if err != nil {
-			logger.Warn("Failed to parse image hash",
+			logger.Warn("Failed to parse image hash; POST /projects/{id}/rehash regenerates stale hashes",
 				"error", err,
 				"image_id", img.ID,
 			)
@@ -717,32 +1557,41 @@ func findSimilarImages(targetImage Image, allImages []Image, threshold int) ([]S
 			continue
 		}
 
+		score := float64(distance)
+		if targetDHash != nil && img.DHash != "" {
+			if imgDHash, err := parseImageHash(img.DHash); err == nil {
+				if dDistance, err := targetDHash.Distance(imgDHash); err == nil {
+					score = weights.combine(distance, dDistance)
+				}
+			}
+		}
+
 		logger.Debug("Image distance calculated",
 			"image_id", img.ID,
 			"distance", distance,
+			"score", score,
 		)
 
-		if distance <= threshold {
+		if score <= float64(threshold) {
 			similar = append(similar, SimilarImage{
 				Image:    img,
-				Distance: distance,
+				Distance: int(math.Round(score)),
 			})
+			scores[img.ID] = score
 		}
 	}
 
-	// Sort by distance (most similar first)
-	for i := 0; i < len(similar)-1; i++ {
-		for j := i + 1; j < len(similar); j++ {
-			if similar[i].Distance > similar[j].Distance {
-				similar[i], similar[j] = similar[j], similar[i]
-			}
-		}
-	}
+	// Sort by combined distance (most similar first)
+	sort.SliceStable(similar, func(i, j int) bool {
+		return scores[similar[i].Image.ID] < scores[similar[j].Image.ID]
+	})
 
 	return similar, nil
 }
 
-func generateCaptionTasksForProject(projectID string) (*TaskGenerationResponse, error) {
+// generateCaptionTasksForProject creates a caption task for every image
+// without one. A non-nil imageIDs limits it to those images.
+func generateCaptionTasksForProject(projectID string, imageIDs map[string]bool) (*TaskGenerationResponse, error) {
 	images, err := getImagesByProjectID(projectID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get images: %v", err)
@@ -754,6 +1603,10 @@ func generateCaptionTasksForProject(projectID string) (*TaskGenerationResponse,
 
 	var tasksCreated int
 	for _, img := range images {
+		if imageIDs != nil && !imageIDs[img.ID] {
+			continue
+		}
+
 		// Check if caption task already exists for this image
 		exists, err := captionTaskExistsForImage(projectID, img.ID)
 		if err != nil {
@@ -798,6 +1651,7 @@ func generateCaptionTasksForProject(projectID string) (*TaskGenerationResponse,
 
 		tasksCreated++
 	}
+	invalidateProjectStats(projectID)
 
 	return &TaskGenerationResponse{
 		TasksCreated:      tasksCreated,
@@ -805,7 +1659,27 @@ func generateCaptionTasksForProject(projectID string) (*TaskGenerationResponse,
 	}, nil
 }
 
-func generateTasksForProject(projectID string, threshold, maxCandidates int) (*TaskGenerationResponse, error) {
+// generationLocks serialises task generation per project so concurrent
+// requests can't both create a task for the same image
+var generationLocks sync.Map
+
+// generateTasksForProject creates a task for every image without one. With
+// exclusiveBImages, an image offered as a candidate (or already chosen as
+// image B) is never offered again, so results depend on order: images are
+// assigned in upload order and earlier images get first pick of their
+// closest matches. Images with fewer than minCandidates available candidates
+// get no task and reserve nothing, so a later run may still create one.
+// A non-zero imageAfter limits image A to images uploaded after it; candidates
+// are still drawn from every image, as they are when a non-nil imageIDs limits
+// image A to those images. A non-nil verifier drops hash matches whose
+// pixels differ too much before candidates are limited or reserved. strategy
+// chooses which maxCandidates matches are kept (see selectCandidates).
+// Excluded images get no task and are never candidates.
+func generateTasksForProject(projectID string, threshold, maxCandidates, minCandidates int, exclusiveBImages bool, imageAfter time.Time, imageIDs map[string]bool, verifier *pixelVerifier, strategy string, weights HashWeights) (*TaskGenerationResponse, error) {
+	lock, _ := generationLocks.LoadOrStore(projectID, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
 	images, err := getImagesByProjectID(projectID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get images: %v", err)
@@ -815,121 +1689,259 @@ func generateTasksForProject(projectID string, threshold, maxCandidates int) (*T
 		return &TaskGenerationResponse{TasksCreated: 0, AverageCandidates: 0}, nil
 	}
 
-	var totalCandidates int
-	var tasksCreated int
-	for _, img := range images {
-		// Check if task already exists for this image
-		exists, err := taskExistsForImageA(projectID, img.ID)
-		if err != nil {
-			logger.Warn("Error checking if task exists",
-				"error", err,
-				"image_id", img.ID,
-			)
+	// Skip images that already have a task
+	existing, err := getTaskImageAIDs(projectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get existing tasks: %v", err)
+	}
+	var pending []int
+	for i, img := range images {
+		if img.Excluded {
 			continue
 		}
-		if exists {
+		if existing[img.ID] {
 			logger.Debug("Task already exists for image, skipping",
 				"image_id", img.ID,
 				"project_id", projectID,
 			)
 			continue
 		}
+		if !imageAfter.IsZero() && !img.CreatedAt.After(imageAfter) {
+			continue
+		}
+		if imageIDs != nil && !imageIDs[img.ID] {
+			continue
+		}
+		pending = append(pending, i)
+	}
+
+	// Similarity searches are independent and CPU-bound, so spread them over
+	// a worker pool; each worker writes only its own result slot
+	results := make([][]SimilarImage, len(pending))
+	failed := make([]bool, len(pending))
+	rejected := make([]int, len(pending))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(appConfig.GenerationWorkers, max(len(pending), 1)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := range jobs {
+				img := images[pending[n]]
+				similarImages, err := findSimilarImages(img, images, threshold, weights)
+				if err != nil {
+					logger.Warn("Error finding similar images",
+						"error", err,
+						"image_id", img.ID,
+					)
+					failed[n] = true
+					continue
+				}
+				if verifier != nil {
+					similarImages, rejected[n], err = verifier.filter(img, similarImages)
+					if err != nil {
+						logger.Warn("Error verifying similar images",
+							"error", err,
+							"image_id", img.ID,
+						)
+						failed[n] = true
+						continue
+					}
+				}
+				results[n] = similarImages
+			}
+		}()
+	}
+	for n := range pending {
+		jobs <- n
+	}
+	close(jobs)
+	wg.Wait()
 
-		similarImages, err := findSimilarImages(img, images, threshold)
+	// Images reserved by existing tasks are off limits from the start
+	var reserved map[string]bool
+	if exclusiveBImages {
+		reserved, err = getReservedBImageIDs(projectID)
 		if err != nil {
-			logger.Warn("Error finding similar images",
-				"error", err,
+			return nil, fmt.Errorf("failed to get reserved images: %v", err)
+		}
+	}
+
+	var totalCandidates, skippedInsufficient, totalRejected int
+	var tasks []Task
+	for n, index := range pending {
+		totalRejected += rejected[n]
+		if failed[n] {
+			continue
+		}
+		img := images[index]
+
+		candidates := results[n]
+		if exclusiveBImages {
+			var available []SimilarImage
+			for _, candidate := range candidates {
+				if !reserved[candidate.Image.ID] {
+					available = append(available, candidate)
+				}
+			}
+			candidates = available
+		}
+
+		if len(candidates) < minCandidates {
+			logger.Debug("Too few candidates for image, skipping",
 				"image_id", img.ID,
+				"project_id", projectID,
+				"candidates", len(candidates),
 			)
+			skippedInsufficient++
 			continue
 		}
 
 		// Limit candidates
-		candidates := similarImages
-		if len(candidates) > maxCandidates {
-			candidates = candidates[:maxCandidates]
-		}
+		candidates = selectCandidates(candidates, maxCandidates, threshold, strategy)
 
-		// Extract candidate IDs
+		// Extract candidate IDs along with the distances that ranked them
 		var candidateIDs []string
+		var taskCandidates []TaskCandidate
 		for _, candidate := range candidates {
+			distance := candidate.Distance
 			candidateIDs = append(candidateIDs, candidate.Image.ID)
+			taskCandidates = append(taskCandidates, TaskCandidate{ImageID: candidate.Image.ID, Distance: &distance})
+			if exclusiveBImages {
+				reserved[candidate.Image.ID] = true
+			}
 		}
 
-		// Create task
-		task := &Task{
+		tasks = append(tasks, Task{
 			ID:            uuid.New().String(),
 			ProjectID:     projectID,
 			ImageAID:      img.ID,
 			ImageBId:      sql.NullString{}, // Will be set during annotation
 			Prompt:        sql.NullString{}, // Will be set during annotation
 			Skipped:       false,
-			CandidateBIds: candidateIDs,
-		}
-
-		logger.Debug("Creating task",
-			"task_id", task.ID,
-			"project_id", projectID,
-			"image_id", img.ID,
-			"candidate_count", len(candidateIDs),
-		)
-		if err := createTask(task); err != nil {
-			logger.Error("Error creating task",
-				"error", err,
-				"task_id", task.ID,
-				"project_id", projectID,
-				"image_id", img.ID,
-			)
-			continue
-		}
-
-		tasksCreated++
+			CandidateBIds: candidateIDs,
+			Candidates:    taskCandidates,
+		})
 		totalCandidates += len(candidateIDs)
 	}
 
+	// Writes stay serial, in a single transaction
+	if len(tasks) > 0 {
+		logger.Debug("Creating tasks",
+			"project_id", projectID,
+			"task_count", len(tasks),
+		)
+		if err := createTasks(tasks); err != nil {
+			return nil, fmt.Errorf("failed to create tasks: %v", err)
+		}
+		invalidateProjectStats(projectID)
+	}
+
+	tasksCreated := len(tasks)
 	var averageCandidates float64
 	if tasksCreated > 0 {
 		averageCandidates = float64(totalCandidates) / float64(tasksCreated)
 	}
 	return &TaskGenerationResponse{
-		TasksCreated:      tasksCreated,
-		AverageCandidates: averageCandidates,
+		TasksCreated:                  tasksCreated,
+		AverageCandidates:             averageCandidates,
+		SkippedInsufficientCandidates: skippedInsufficient,
+		RejectedByVerification:        totalRejected,
 	}, nil
 }
 
 func generateTasksHandler(w http.ResponseWriter, r *http.Request) {
 	projectID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/projects/"), "/generate-tasks")
 	if projectID == "" {
-		http.Error(w, "Project ID is required", http.StatusBadRequest)
+		writeError(w, r, "Project ID is required", http.StatusBadRequest)
 		return
 	}
 
 	// Check if project exists
 	project, err := getProject(projectID)
 	if err != nil {
-		http.Error(w, "Failed to get project", http.StatusInternalServerError)
+		writeError(w, r, "Failed to get project", http.StatusInternalServerError)
 		logError(r.Context(), "Failed to get project for task generation", err, slog.String("project_id", projectID))
 		return
 	}
 	if project == nil {
-		http.Error(w, "Project not found", http.StatusNotFound)
+		writeError(w, r, "Project not found", http.StatusNotFound)
 		return
 	}
 
-	// Parse request body
+	// Keys are scoped to the project so the same key can't replay another project's result
+	idempotencyScope := "generate_tasks:" + projectID
+	handled, release := beginIdempotentRequest(w, r, idempotencyScope)
+	if handled {
+		return
+	}
+	defer release()
+
+	// Parse request body, falling back to defaults if parsing fails
 	var req TaskGenerationRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		// Use defaults if parsing fails
-		req.SimilarityThreshold = 10
-		req.MaxCandidates = 5
+		req = TaskGenerationRequest{}
 	}
 
-	// Validate parameters
+	// Omitted parameters use the project defaults, then the server defaults
 	if req.SimilarityThreshold <= 0 {
 		req.SimilarityThreshold = 10
+		if project.DefaultSimilarityThreshold != nil {
+			req.SimilarityThreshold = *project.DefaultSimilarityThreshold
+		}
 	}
 	if req.MaxCandidates <= 0 {
 		req.MaxCandidates = 5
+		if project.DefaultMaxCandidates != nil {
+			req.MaxCandidates = *project.DefaultMaxCandidates
+		}
+	}
+	req.MaxCandidates = clampMaxCandidates(r.Context(), projectID, req.MaxCandidates)
+	if req.MinCandidates < 0 {
+		req.MinCandidates = 0
+	}
+	if req.MinCandidates > req.MaxCandidates {
+		writeError(w, r, "minCandidates must not exceed maxCandidates", http.StatusBadRequest)
+		return
+	}
+	var imageAfter time.Time
+	if req.ImageAfter != "" {
+		imageAfter, err = time.Parse(time.RFC3339, req.ImageAfter)
+		if err != nil {
+			writeError(w, r, "imageAfter must be an RFC3339 timestamp", http.StatusBadRequest)
+			return
+		}
+	}
+	var verifier *pixelVerifier
+	if req.Verify {
+		maxDifference, err := parseMaxPixelDifference(req.MaxPixelDifference)
+		if err != nil {
+			writeError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+		verifier = newPixelVerifier(maxDifference)
+	}
+	// "nearest" keeps the maxCandidates closest matches. "diverse" aims for
+	// candidates at evenly spaced distances between the closest match and
+	// similarityThreshold, taking for each target distance the remaining match
+	// nearest to it, so a task offers near-duplicates and looser matches alike.
+	if req.CandidateStrategy == "" {
+		req.CandidateStrategy = candidateStrategyNearest
+	}
+	if err := validateCandidateStrategy(req.CandidateStrategy); err != nil {
+		writeError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Hash distances are combined as a weighted mean, so similarityThreshold
+	// keeps its meaning whatever the weights. Images stored before dHashes were
+	// recorded need POST /projects/{id}/rehash to take part in the dHash term.
+	weights := defaultHashWeights
+	if req.HashWeights != nil {
+		if err := validateHashWeights(*req.HashWeights); err != nil {
+			writeError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+		weights = *req.HashWeights
 	}
 
 	// Generate tasks based on project type
@@ -940,19 +1952,26 @@ func generateTasksHandler(w http.ResponseWriter, r *http.Request) {
 			slog.String("project_id", projectID),
 			slog.String("project_type", project.ProjectType),
 		)
-		response, err = generateCaptionTasksForProject(projectID)
+		response, err = generateCaptionTasksForProject(projectID, nil)
 	} else {
 		logInfo(r.Context(), "Generating edit tasks",
 			slog.String("project_id", projectID),
 			slog.String("project_type", project.ProjectType),
 			slog.Int("similarity_threshold", req.SimilarityThreshold),
 			slog.Int("max_candidates", req.MaxCandidates),
+			slog.Bool("exclusive_b_images", req.ExclusiveBImages),
+			slog.Int("min_candidates", req.MinCandidates),
+			slog.String("image_after", req.ImageAfter),
+			slog.Bool("verify", req.Verify),
+			slog.String("candidate_strategy", req.CandidateStrategy),
+			slog.Float64("phash_weight", weights.PHash),
+			slog.Float64("dhash_weight", weights.DHash),
 		)
-		response, err = generateTasksForProject(projectID, req.SimilarityThreshold, req.MaxCandidates)
+		response, err = generateTasksForProject(projectID, req.SimilarityThreshold, req.MaxCandidates, req.MinCandidates, req.ExclusiveBImages, imageAfter, nil, verifier, req.CandidateStrategy, weights)
 	}
 	
 	if err != nil {
-		http.Error(w, "Failed to generate tasks", http.StatusInternalServerError)
+		writeError(w, r, "Failed to generate tasks", http.StatusInternalServerError)
 		logError(r.Context(), "Failed to generate tasks", err, slog.String("project_id", projectID))
 		return
 	}
@@ -961,39 +1980,77 @@ func generateTasksHandler(w http.ResponseWriter, r *http.Request) {
 		slog.String("project_type", project.ProjectType),
 		slog.Int("tasks_created", response.TasksCreated),
 		slog.Float64("average_candidates", response.AverageCandidates),
+		slog.Int("skipped_insufficient_candidates", response.SkippedInsufficientCandidates),
+		slog.Int("rejected_by_verification", response.RejectedByVerification),
 	)
+	completeIdempotentRequest(r, idempotencyScope, projectID, http.StatusOK, response)
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	writeJSON(w, r, http.StatusOK, response)
 }
 
 func getTasksHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
 	projectID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/projects/"), "/tasks")
 	if projectID == "" {
-		http.Error(w, "Project ID is required", http.StatusBadRequest)
+		writeError(w, r, "Project ID is required", http.StatusBadRequest)
 		return
 	}
 
 	// Check if project exists
 	project, err := getProject(projectID)
 	if err != nil {
-		http.Error(w, "Failed to get project", http.StatusInternalServerError)
+		writeError(w, r, "Failed to get project", http.StatusInternalServerError)
 		logError(r.Context(), "Failed to get project for tasks", err, slog.String("project_id", projectID))
 		return
 	}
 	if project == nil {
-		http.Error(w, "Project not found", http.StatusNotFound)
+		writeError(w, r, "Project not found", http.StatusNotFound)
 		return
 	}
 
-	tasks, err := getTasksByProjectID(projectID)
+	// Without a limit every task is returned, as before pagination existed.
+	// Passing after pages by cursor, which doesn't drift when tasks are
+	// generated mid-listing; limit and offset page as they always have.
+	query := r.URL.Query()
+	useCursor := wantsCursorPagination(r)
+	limit, offset := -1, 0
+	var after *pageCursor
+	if useCursor {
+		limit, after, err = parseCursorPagination(r, 100, 1000)
+	} else if query.Get("limit") != "" || query.Get("offset") != "" {
+		limit, offset, err = parsePagination(r, 100, 1000)
+	}
 	if err != nil {
-		http.Error(w, "Failed to get tasks", http.StatusInternalServerError)
+		writeError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if limit >= 0 {
+		total, err := countTasksByProjectID(projectID)
+		if err != nil {
+			writeError(w, r, "Failed to get tasks", http.StatusInternalServerError)
+			logError(r.Context(), "Failed to count tasks", err, slog.String("project_id", projectID))
+			return
+		}
+		w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	}
+
+	var tasks []Task
+	var nextCursor *string
+	if useCursor {
+		tasks, err = getTasksPageAfter(projectID, after, limit)
+		if err == nil && len(tasks) > 0 {
+			last := tasks[len(tasks)-1]
+			nextCursor = nextPageCursor(w, len(tasks), limit, last.CreatedAt, last.ID)
+		}
+	} else {
+		tasks, err = getTasksPageByProjectID(projectID, limit, offset)
+	}
+	if err != nil {
+		writeError(w, r, "Failed to get tasks", http.StatusInternalServerError)
 		logError(r.Context(), "Failed to get tasks", err, slog.String("project_id", projectID))
 		return
 	}
@@ -1002,65 +2059,170 @@ func getTasksHandler(w http.ResponseWriter, r *http.Request) {
 		tasks = []Task{}
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(tasks)
+	if useCursor {
+		writeJSON(w, r, http.StatusOK, cursorPage{Items: tasks, NextCursor: nextCursor})
+		return
+	}
+	writeJSON(w, r, http.StatusOK, tasks)
 }
 
 func getTaskHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
 	taskID := r.URL.Path[len("/tasks/"):]
 	if taskID == "" {
-		http.Error(w, "Task ID is required", http.StatusBadRequest)
+		writeError(w, r, "Task ID is required", http.StatusBadRequest)
 		return
 	}
 
 	task, err := getTask(taskID)
 	if err != nil {
-		http.Error(w, "Failed to get task", http.StatusInternalServerError)
+		writeError(w, r, "Failed to get task", http.StatusInternalServerError)
 		logError(r.Context(), "Failed to get task", err, slog.String("task_id", taskID))
 		return
 	}
 
 	if task == nil {
-		http.Error(w, "Task not found", http.StatusNotFound)
+		writeError(w, r, "Task not found", http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, task)
+}
+
+// getTaskCandidatesHandler recomputes similar images for a task's image A
+// against the whole project, without touching the stored task_candidates.
+func getTaskCandidatesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	taskID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/tasks/"), "/candidates")
+	if taskID == "" {
+		writeError(w, r, "Task ID is required", http.StatusBadRequest)
+		return
+	}
+
+	threshold := 10
+	if value := r.URL.Query().Get("threshold"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			writeError(w, r, "threshold must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		threshold = n
+	}
+
+	limit, _, err := parsePagination(r, 20, 200)
+	if err != nil {
+		writeError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var verifier *pixelVerifier
+	if r.URL.Query().Get("verify") == "true" {
+		var requested *float64
+		if r.URL.Query().Has("maxPixelDifference") {
+			value, err := strconv.ParseFloat(r.URL.Query().Get("maxPixelDifference"), 64)
+			if err != nil {
+				writeError(w, r, "maxPixelDifference must be a number", http.StatusBadRequest)
+				return
+			}
+			requested = &value
+		}
+		maxDifference, err := parseMaxPixelDifference(requested)
+		if err != nil {
+			writeError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+		verifier = newPixelVerifier(maxDifference)
+	}
+
+	task, err := getTask(taskID)
+	if err != nil {
+		writeError(w, r, "Failed to get task", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to get task for candidates", err, slog.String("task_id", taskID))
+		return
+	}
+	if task == nil {
+		writeError(w, r, "Task not found", http.StatusNotFound)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(task)
+	imageA, err := getImage(task.ImageAID)
+	if err != nil {
+		writeError(w, r, "Failed to get image", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to get image A for candidates", err, slog.String("task_id", taskID))
+		return
+	}
+	if imageA == nil {
+		writeError(w, r, "Image A not found", http.StatusNotFound)
+		return
+	}
+
+	images, err := getImagesByProjectID(task.ProjectID)
+	if err != nil {
+		writeError(w, r, "Failed to get images", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to get images for candidates", err, slog.String("project_id", task.ProjectID))
+		return
+	}
+
+	candidates, err := findSimilarImages(*imageA, images, threshold, defaultHashWeights)
+	if err != nil {
+		writeError(w, r, "Failed to find similar images", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to find similar images", err, slog.String("task_id", taskID))
+		return
+	}
+	if verifier != nil {
+		candidates, _, err = verifier.filter(*imageA, candidates)
+		if err != nil {
+			writeError(w, r, "Failed to verify similar images", http.StatusInternalServerError)
+			logError(r.Context(), "Failed to verify similar images", err, slog.String("task_id", taskID))
+			return
+		}
+	}
+
+	if len(candidates) > limit {
+		candidates = candidates[:limit]
+	}
+	if candidates == nil {
+		candidates = []SimilarImage{}
+	}
+
+	writeJSON(w, r, http.StatusOK, candidates)
 }
 
 func getCaptionTasksHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
 	projectID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/projects/"), "/caption-tasks")
 	if projectID == "" {
-		http.Error(w, "Project ID is required", http.StatusBadRequest)
+		writeError(w, r, "Project ID is required", http.StatusBadRequest)
 		return
 	}
 
 	// Check if project exists and is caption type
 	project, err := getProject(projectID)
 	if err != nil {
-		http.Error(w, "Failed to get project", http.StatusInternalServerError)
+		writeError(w, r, "Failed to get project", http.StatusInternalServerError)
 		logError(r.Context(), "Failed to get project for caption tasks", err, slog.String("project_id", projectID))
 		return
 	}
 	if project == nil {
-		http.Error(w, "Project not found", http.StatusNotFound)
+		writeError(w, r, "Project not found", http.StatusNotFound)
 		return
 	}
 
 	tasks, err := getCaptionTasksByProjectID(projectID)
 	if err != nil {
-		http.Error(w, "Failed to get caption tasks", http.StatusInternalServerError)
+		writeError(w, r, "Failed to get caption tasks", http.StatusInternalServerError)
 		logError(r.Context(), "Failed to get caption tasks", err, slog.String("project_id", projectID))
 		return
 	}
@@ -1069,141 +2231,353 @@ func getCaptionTasksHandler(w http.ResponseWriter, r *http.Request) {
 		tasks = []CaptionTask{}
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(tasks)
+	writeJSON(w, r, http.StatusOK, tasks)
 }
 
 func getCaptionTaskHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
 	taskID := r.URL.Path[len("/caption-tasks/"):]
 	if taskID == "" {
-		http.Error(w, "Caption task ID is required", http.StatusBadRequest)
+		writeError(w, r, "Caption task ID is required", http.StatusBadRequest)
 		return
 	}
 
 	task, err := getCaptionTask(taskID)
 	if err != nil {
-		http.Error(w, "Failed to get caption task", http.StatusInternalServerError)
+		writeError(w, r, "Failed to get caption task", http.StatusInternalServerError)
 		logError(r.Context(), "Failed to get caption task", err, slog.String("task_id", taskID))
 		return
 	}
 
 	if task == nil {
-		http.Error(w, "Caption task not found", http.StatusNotFound)
+		writeError(w, r, "Caption task not found", http.StatusNotFound)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(task)
+	writeJSON(w, r, http.StatusOK, task)
 }
 
 func updateCaptionTaskHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "PUT" {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
 	taskID := r.URL.Path[len("/caption-tasks/"):]
 	if taskID == "" {
-		http.Error(w, "Caption task ID is required", http.StatusBadRequest)
+		writeError(w, r, "Caption task ID is required", http.StatusBadRequest)
 		return
 	}
 
 	// Check if task exists
 	existingTask, err := getCaptionTask(taskID)
 	if err != nil {
-		http.Error(w, "Failed to get caption task", http.StatusInternalServerError)
+		writeError(w, r, "Failed to get caption task", http.StatusInternalServerError)
 		logError(r.Context(), "Failed to get caption task for update", err, slog.String("task_id", taskID))
 		return
 	}
 	if existingTask == nil {
-		http.Error(w, "Caption task not found", http.StatusNotFound)
+		writeError(w, r, "Caption task not found", http.StatusNotFound)
 		return
 	}
 
 	var updatedTask CaptionTask
 	if err := json.NewDecoder(r.Body).Decode(&updatedTask); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, err.Error(), http.StatusBadRequest)
 		return
 	}
 
 	updatedTask.ID = taskID // Ensure the ID from the URL is used
 
-	if err := updateCaptionTask(&updatedTask); err != nil {
-		http.Error(w, "Failed to update caption task", http.StatusInternalServerError)
+	project, err := getProject(existingTask.ProjectID)
+	if err != nil {
+		writeError(w, r, "Failed to get project", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to get project for caption task update", err, slog.String("task_id", taskID))
+		return
+	}
+	updatedTask.Caption = normalizePrompt(updatedTask.Caption, project)
+
+	if err := updateCaptionTask(&updatedTask, "manual"); err != nil {
+		writeError(w, r, "Failed to update caption task", http.StatusInternalServerError)
 		logError(r.Context(), "Failed to update caption task", err, slog.String("task_id", taskID))
 		return
 	}
+	invalidateProjectStats(existingTask.ProjectID)
 
 	// Return the updated task
 	task, err := getCaptionTask(taskID)
 	if err != nil {
-		http.Error(w, "Failed to get updated caption task", http.StatusInternalServerError)
+		writeError(w, r, "Failed to get updated caption task", http.StatusInternalServerError)
 		logError(r.Context(), "Failed to get updated caption task", err, slog.String("task_id", taskID))
 		return
 	}
+	recordAudit(r.Context(), existingTask.ProjectID, "update", "caption_task", taskID, existingTask, task)
+
+	writeJSON(w, r, http.StatusOK, task)
+}
+
+// isSelfPair reports whether a task pairs image A with itself, which makes a
+// meaningless edit example
+func isSelfPair(task *Task) bool {
+	return task.ImageBId.Valid && task.ImageBId.String == task.ImageAID
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(task)
+// involvesExcludedImage reports whether either of a task's images has been
+// excluded since the task was created, which keeps it out of exports
+func involvesExcludedImage(task *Task, imageMap map[string]*Image) bool {
+	if imageA := imageMap[task.ImageAID]; imageA != nil && imageA.Excluded {
+		return true
+	}
+	if task.ImageBId.Valid {
+		if imageB := imageMap[task.ImageBId.String]; imageB != nil && imageB.Excluded {
+			return true
+		}
+	}
+	return false
 }
 
+// updateTaskHandler handles PUT and PATCH /tasks/{id}. PUT replaces image B,
+// the prompt and skipped; PATCH only changes the fields its body includes, so
+// {"skipped": true} leaves the annotation alone. Both take fields in the same
+// shape as the task JSON, and an explicit null clears a field. A PATCH body
+// with imageAId moves the task to another image A (see reassignTaskHandler).
 func updateTaskHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "PUT" {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	if r.Method != http.MethodPut && r.Method != http.MethodPatch {
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
 	taskID := r.URL.Path[len("/tasks/"):]
 	if taskID == "" {
-		http.Error(w, "Task ID is required", http.StatusBadRequest)
+		writeError(w, r, "Task ID is required", http.StatusBadRequest)
 		return
 	}
 
 	// Check if task exists
 	existingTask, err := getTask(taskID)
 	if err != nil {
-		http.Error(w, "Failed to get task", http.StatusInternalServerError)
+		writeError(w, r, "Failed to get task", http.StatusInternalServerError)
 		logError(r.Context(), "Failed to get task for update", err, slog.String("task_id", taskID))
 		return
 	}
 	if existingTask == nil {
-		http.Error(w, "Task not found", http.StatusNotFound)
+		writeError(w, r, "Task not found", http.StatusNotFound)
 		return
 	}
 
-	var updatedTask Task
-	if err := json.NewDecoder(r.Body).Decode(&updatedTask); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, r, "Failed to read request body", http.StatusBadRequest)
 		return
 	}
 
+	var updatedTask Task
+	if err := json.Unmarshal(body, &updatedTask); err != nil {
+		writeError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// The region and negative prompt are only changed when the request
+	// mentions them, as is everything else for PATCH; an explicit null clears
+	// a field
+	var optionalFields struct {
+		ImageAID       json.RawMessage `json:"imageAId"`
+		ImageBId       json.RawMessage `json:"imageBId"`
+		Prompt         json.RawMessage `json:"prompt"`
+		Skipped        json.RawMessage `json:"skipped"`
+		Region         json.RawMessage `json:"region"`
+		NegativePrompt json.RawMessage `json:"negativePrompt"`
+	}
+	json.Unmarshal(body, &optionalFields)
+	if r.Method == http.MethodPatch {
+		if optionalFields.ImageAID != nil {
+			if optionalFields.ImageBId != nil || optionalFields.Prompt != nil || optionalFields.Skipped != nil ||
+				optionalFields.Region != nil || optionalFields.NegativePrompt != nil {
+				writeError(w, r, "imageAId can't be changed together with other task fields", http.StatusBadRequest)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			reassignTaskHandler(w, r)
+			return
+		}
+		if optionalFields.ImageBId == nil {
+			updatedTask.ImageBId = existingTask.ImageBId
+		}
+		if optionalFields.Prompt == nil {
+			updatedTask.Prompt = existingTask.Prompt
+		}
+		if optionalFields.Skipped == nil {
+			updatedTask.Skipped = existingTask.Skipped
+		}
+	}
+
 	updatedTask.ID = taskID // Ensure the ID from the URL is used
+	updatedTask.ImageAID = existingTask.ImageAID
+	if isSelfPair(&updatedTask) {
+		writeError(w, r, "imageBId must be a different image from the task's image A", http.StatusBadRequest)
+		return
+	}
+
+	if optionalFields.Region == nil {
+		updatedTask.Region = existingTask.Region
+	} else if err := validateTaskRegion(updatedTask.Region); err != nil {
+		writeError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if optionalFields.NegativePrompt == nil {
+		updatedTask.NegativePrompt = existingTask.NegativePrompt
+	}
+
+	project, err := getProject(existingTask.ProjectID)
+	if err != nil {
+		writeError(w, r, "Failed to get project", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to get project for task update", err, slog.String("task_id", taskID))
+		return
+	}
+	updatedTask.Prompt = normalizePrompt(updatedTask.Prompt, project)
+	updatedTask.NegativePrompt = normalizePrompt(updatedTask.NegativePrompt, project)
 
 	if err := updateTask(&updatedTask); err != nil {
-		http.Error(w, "Failed to update task", http.StatusInternalServerError)
+		writeError(w, r, "Failed to update task", http.StatusInternalServerError)
 		logError(r.Context(), "Failed to update task", err, slog.String("task_id", taskID))
 		return
 	}
+	invalidateProjectStats(existingTask.ProjectID)
 
 	// Return the updated task
 	task, err := getTask(taskID)
 	if err != nil {
-		http.Error(w, "Failed to get updated task", http.StatusInternalServerError)
+		writeError(w, r, "Failed to get updated task", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to get updated task", err, slog.String("task_id", taskID))
+		return
+	}
+	recordAudit(r.Context(), existingTask.ProjectID, "update", "task", taskID, existingTask, task)
+
+	writeJSON(w, r, http.StatusOK, task)
+}
+
+type SelectCandidateRequest struct {
+	ImageID string  `json:"imageId"`
+	Prompt  *string `json:"prompt"` // Optional; the existing prompt is kept when omitted
+}
+
+// selectCandidateHandler sets one of the task's candidates as image B, and
+// optionally the prompt, without the client sending the whole task
+func selectCandidateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	taskID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/tasks/"), "/select-candidate")
+	if taskID == "" {
+		writeError(w, r, "Task ID is required", http.StatusBadRequest)
+		return
+	}
+
+	var req SelectCandidateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.ImageID == "" {
+		writeError(w, r, "imageId is required", http.StatusBadRequest)
+		return
+	}
+
+	existingTask, err := getTask(taskID)
+	if err != nil {
+		writeError(w, r, "Failed to get task", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to get task for candidate selection", err, slog.String("task_id", taskID))
+		return
+	}
+	if existingTask == nil {
+		writeError(w, r, "Task not found", http.StatusNotFound)
+		return
+	}
+
+	isCandidate := false
+	for _, candidateID := range existingTask.CandidateBIds {
+		if candidateID == req.ImageID {
+			isCandidate = true
+			break
+		}
+	}
+	if !isCandidate {
+		writeError(w, r, "Image is not a candidate for this task", http.StatusBadRequest)
+		return
+	}
+
+	updatedTask := *existingTask
+	updatedTask.ImageBId = sql.NullString{String: req.ImageID, Valid: true}
+	if req.Prompt != nil {
+		project, err := getProject(existingTask.ProjectID)
+		if err != nil {
+			writeError(w, r, "Failed to get project", http.StatusInternalServerError)
+			logError(r.Context(), "Failed to get project for candidate selection", err, slog.String("task_id", taskID))
+			return
+		}
+		updatedTask.Prompt = normalizePrompt(sql.NullString{String: *req.Prompt, Valid: true}, project)
+	}
+
+	if err := updateTask(&updatedTask); err != nil {
+		writeError(w, r, "Failed to update task", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to select candidate", err, slog.String("task_id", taskID))
+		return
+	}
+	invalidateProjectStats(existingTask.ProjectID)
+
+	task, err := getTask(taskID)
+	if err != nil {
+		writeError(w, r, "Failed to get updated task", http.StatusInternalServerError)
 		logError(r.Context(), "Failed to get updated task", err, slog.String("task_id", taskID))
 		return
 	}
+	recordAudit(r.Context(), existingTask.ProjectID, "update", "task", taskID, existingTask, task)
+
+	writeJSON(w, r, http.StatusOK, task)
+}
+
+// normalizePrompt trims prompt text and treats whitespace-only text as absent.
+// Unless the project preserves whitespace, internal runs of whitespace
+// (including newlines) are collapsed to single spaces.
+func normalizePrompt(prompt sql.NullString, project *Project) sql.NullString {
+	if !prompt.Valid {
+		return prompt
+	}
+	text := strings.TrimSpace(prompt.String)
+	if text == "" {
+		return sql.NullString{}
+	}
+	if project == nil || !project.PreservePromptWhitespace {
+		text = strings.Join(strings.Fields(text), " ")
+	}
+	return sql.NullString{String: text, Valid: true}
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(task)
+// validateTaskRegion checks that a region lies within the normalized image bounds
+func validateTaskRegion(region *TaskRegion) error {
+	if region == nil {
+		return nil
+	}
+	if region.X < 0 || region.Y < 0 || region.W <= 0 || region.H <= 0 {
+		return fmt.Errorf("region must have a non-negative origin and positive size")
+	}
+	// Allow a little slack for floating point error from clients
+	const epsilon = 1e-6
+	if region.X+region.W > 1+epsilon || region.Y+region.H > 1+epsilon {
+		return fmt.Errorf("region must lie within the image (normalized 0-1 coordinates)")
+	}
+	return nil
 }
 
 func serveImageHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
@@ -1211,7 +2585,7 @@ func serveImageHandler(w http.ResponseWriter, r *http.Request) {
 	// URL format: /projects/{projectId}/images/{imagePath}
 	pathParts := strings.Split(strings.TrimPrefix(r.URL.Path, "/projects/"), "/")
 	if len(pathParts) < 3 || pathParts[1] != "images" {
-		http.Error(w, "Invalid image path", http.StatusBadRequest)
+		writeError(w, r, "Invalid image path", http.StatusBadRequest)
 		return
 	}
 
@@ -1224,24 +2598,46 @@ func serveImageHandler(w http.ResponseWriter, r *http.Request) {
 	// Security check: ensure the path is within the project directory
 	absProjectDir, err := filepath.Abs(filepath.Join("data", "projects", projectID))
 	if err != nil {
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		writeError(w, r, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 
 	absFilePath, err := filepath.Abs(filePath)
 	if err != nil {
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		writeError(w, r, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 
 	if !strings.HasPrefix(absFilePath, absProjectDir) {
-		http.Error(w, "Access denied", http.StatusForbidden)
+		writeError(w, r, "Access denied", http.StatusForbidden)
+		return
+	}
+
+	// Images stored as blobs are resolved through their row
+	imageRecord, err := getImageByPath(projectID, filepath.Join("images", imagePath))
+	if err != nil {
+		writeError(w, r, "Internal server error", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to look up image", err, slog.String("project_id", projectID))
 		return
 	}
+	if imageRecord != nil && imageRecord.BlobHash != "" {
+		filePath = imageFilePath(imageRecord)
+	}
 
 	// Check if file exists
 	if _, err := os.Stat(filePath); os.IsNotExist(err) {
-		http.Error(w, "Image not found", http.StatusNotFound)
+		writeError(w, r, "Image not found", http.StatusNotFound)
+		return
+	}
+
+	// Serve a scaled-down version if a thumbnail size was requested
+	if thumbParam := r.URL.Query().Get("thumb"); thumbParam != "" {
+		size, err := strconv.Atoi(thumbParam)
+		if err != nil || size < minThumbSize || size > maxThumbSize {
+			writeError(w, r, fmt.Sprintf("thumb must be between %d and %d", minThumbSize, maxThumbSize), http.StatusBadRequest)
+			return
+		}
+		serveThumbnail(w, r, projectID, imagePath, filePath, size)
 		return
 	}
 
@@ -1249,44 +2645,94 @@ func serveImageHandler(w http.ResponseWriter, r *http.Request) {
 	http.ServeFile(w, r, filePath)
 }
 
+// exportJSONLHandler exports a project's completed tasks as JSONL. A POST
+// with a body of task IDs limits the export to those tasks.
+//
+// Caption projects emit one record per captioned, unskipped task, in creation
+// order or, with order=path, by image path. Edit projects emit one record per
+// unskipped task with an image B or a prompt, so records may lack "b" or
+// "prompt". With strict=true only tasks with both an image B and a non-blank
+// prompt are emitted, which is what training needs. With originals=true,
+// images archived by keepOriginal are referenced by their original's path.
+// Tasks involving an excluded image are left out. With metadata=true, each
+// record carries its images' metadata ("metadata", or "a_metadata" and
+// "b_metadata" for edit projects).
 func exportJSONLHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "GET" {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
 	projectID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/projects/"), "/export/jsonl")
 	if projectID == "" {
-		http.Error(w, "Project ID is required", http.StatusBadRequest)
+		writeError(w, r, "Project ID is required", http.StatusBadRequest)
+		return
+	}
+
+	subset, err := parseExportSubset(r)
+	if err != nil {
+		writeError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	statuses, err := parseCaptionStatusFilter(r)
+	if err != nil {
+		writeError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	order, err := parseCaptionExportOrder(r)
+	if err != nil {
+		writeError(w, r, err.Error(), http.StatusBadRequest)
 		return
 	}
 
 	// Check if project exists
 	project, err := getProject(projectID)
 	if err != nil {
-		http.Error(w, "Failed to get project", http.StatusInternalServerError)
+		writeError(w, r, "Failed to get project", http.StatusInternalServerError)
 		logError(r.Context(), "Failed to get project for JSONL export", err, slog.String("project_id", projectID))
 		return
 	}
 	if project == nil {
-		http.Error(w, "Project not found", http.StatusNotFound)
+		writeError(w, r, "Project not found", http.StatusNotFound)
+		return
+	}
+
+	if statuses != nil && project.ProjectType != "caption" {
+		writeError(w, r, "The status filter only applies to caption projects", http.StatusBadRequest)
+		return
+	}
+	if r.URL.Query().Has("order") && project.ProjectType != "caption" {
+		writeError(w, r, "order only applies to caption projects", http.StatusBadRequest)
 		return
 	}
+	strict := r.URL.Query().Get("strict") == "true"
+	originals := r.URL.Query().Get("originals") == "true"
+	withMetadata := r.URL.Query().Get("metadata") == "true"
 
 	// Handle different project types for export
 	if project.ProjectType == "caption" {
 		// Caption project export
-		captionTasks, err := getCaptionTasksByProjectID(projectID)
+		captionTasks, err := getCaptionTasksForExport(projectID, order)
 		if err != nil {
-			http.Error(w, "Failed to get caption tasks", http.StatusInternalServerError)
+			writeError(w, r, "Failed to get caption tasks", http.StatusInternalServerError)
 			logError(r.Context(), "Failed to get caption tasks for JSONL export", err, slog.String("project_id", projectID))
 			return
 		}
+		if subset != nil {
+			taskIDs := make(map[string]bool, len(captionTasks))
+			for _, task := range captionTasks {
+				taskIDs[task.ID] = true
+			}
+			if err := checkExportSubset(subset, taskIDs); err != nil {
+				writeError(w, r, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
 
 		// Get all images for path lookup
-		images, err := getImagesByProjectID(projectID)
+		images, err := loadProjectImages(projectID, withMetadata)
 		if err != nil {
-			http.Error(w, "Failed to get images", http.StatusInternalServerError)
+			writeError(w, r, "Failed to get images", http.StatusInternalServerError)
 			logError(r.Context(), "Failed to get images for JSONL export", err, slog.String("project_id", projectID))
 			return
 		}
@@ -1307,17 +2753,26 @@ func exportJSONLHandler(w http.ResponseWriter, r *http.Request) {
 			if task.Skipped || !task.Caption.Valid {
 				continue
 			}
+			if subset != nil && !subset[task.ID] {
+				continue
+			}
+			if statuses != nil && !statuses[task.Status] {
+				continue
+			}
 
 			image := imageMap[task.ImageID]
-			if image == nil {
+			if image == nil || image.Excluded {
 				continue
 			}
 
 			// Create export record
 			record := map[string]interface{}{
-				"image":   image.Path,
+				"image":   exportImagePath(image, originals),
 				"caption": task.Caption.String,
 			}
+			if len(image.Metadata) > 0 {
+				record["metadata"] = image.Metadata
+			}
 
 			// Write JSON line
 			jsonData, err := json.Marshal(record)
@@ -1333,15 +2788,25 @@ func exportJSONLHandler(w http.ResponseWriter, r *http.Request) {
 		// Edit project export (existing functionality)
 		tasks, err := getTasksByProjectID(projectID)
 		if err != nil {
-			http.Error(w, "Failed to get tasks", http.StatusInternalServerError)
+			writeError(w, r, "Failed to get tasks", http.StatusInternalServerError)
 			logError(r.Context(), "Failed to get tasks for JSONL export", err, slog.String("project_id", projectID))
 			return
 		}
+		if subset != nil {
+			taskIDs := make(map[string]bool, len(tasks))
+			for _, task := range tasks {
+				taskIDs[task.ID] = true
+			}
+			if err := checkExportSubset(subset, taskIDs); err != nil {
+				writeError(w, r, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
 
 		// Get all images for path lookup
-		images, err := getImagesByProjectID(projectID)
+		images, err := loadProjectImages(projectID, withMetadata)
 		if err != nil {
-			http.Error(w, "Failed to get images", http.StatusInternalServerError)
+			writeError(w, r, "Failed to get images", http.StatusInternalServerError)
 			logError(r.Context(), "Failed to get images for JSONL export", err, slog.String("project_id", projectID))
 			return
 		}
@@ -1362,21 +2827,41 @@ func exportJSONLHandler(w http.ResponseWriter, r *http.Request) {
 			if task.Skipped || (!task.ImageBId.Valid && !task.Prompt.Valid) {
 				continue
 			}
+			if strict && (!task.ImageBId.Valid || !task.Prompt.Valid || strings.TrimSpace(task.Prompt.String) == "") {
+				continue
+			}
+			if subset != nil && !subset[task.ID] {
+				continue
+			}
+			// Rejected on update, but older tasks may still pair an image with itself
+			if isSelfPair(&task) {
+				logger.Warn("Skipping self-paired task in JSONL export",
+					"project_id", projectID,
+					"task_id", task.ID,
+				)
+				continue
+			}
 
 			imageA := imageMap[task.ImageAID]
-			if imageA == nil {
+			if imageA == nil || involvesExcludedImage(&task, imageMap) {
 				continue
 			}
 
 			// Create export record
 			record := map[string]interface{}{
-				"a": imageA.Path,
+				"a": exportImagePath(imageA, originals),
+			}
+			if len(imageA.Metadata) > 0 {
+				record["a_metadata"] = imageA.Metadata
 			}
 
 			if task.ImageBId.Valid {
 				imageB := imageMap[task.ImageBId.String]
 				if imageB != nil {
-					record["b"] = imageB.Path
+					record["b"] = exportImagePath(imageB, originals)
+					if len(imageB.Metadata) > 0 {
+						record["b_metadata"] = imageB.Metadata
+					}
 				}
 			}
 
@@ -1384,6 +2869,14 @@ func exportJSONLHandler(w http.ResponseWriter, r *http.Request) {
 				record["prompt"] = task.Prompt.String
 			}
 
+			if task.NegativePrompt.Valid {
+				record["negative_prompt"] = task.NegativePrompt.String
+			}
+
+			if task.Region != nil {
+				record["region"] = task.Region
+			}
+
 			// Write JSON line
 			jsonData, err := json.Marshal(record)
 			if err != nil {
@@ -1396,62 +2889,154 @@ func exportJSONLHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	logInfo(r.Context(), "JSONL export completed", slog.String("project_id", projectID))
+	logInfo(r.Context(), "JSONL export completed",
+		slog.String("project_id", projectID),
+		slog.Int("subset_size", len(subset)),
+		slog.Bool("strict", strict))
 }
 
 func exportAIToolkitHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "GET" {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
 	projectID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/projects/"), "/export/ai-toolkit")
 	if projectID == "" {
-		http.Error(w, "Project ID is required", http.StatusBadRequest)
+		writeError(w, r, "Project ID is required", http.StatusBadRequest)
 		return
 	}
 
-	// Check if there's already an active export
-	if status := getExportStatus(projectID); status != nil && status.Status == "processing" {
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"message": "Export already in progress",
-			"status":  status,
-		})
+	async := r.URL.Query().Get("async") == "true"
+	originals := r.URL.Query().Get("originals") == "true"
+	withMetadata := r.URL.Query().Get("metadata") == "true"
+
+	layout, err := parseAIToolkitLayout(r)
+	if err != nil {
+		writeError(w, r, err.Error(), http.StatusBadRequest)
 		return
 	}
 
+	// Check if there's already an active background export
+	if async {
+		if status := getExportStatus(projectID); status != nil && status.Status == "processing" {
+			writeJSON(w, r, http.StatusOK, map[string]interface{}{
+				"message": "Export already in progress",
+				"status":  status,
+			})
+			return
+		}
+	}
+
 	// Check if project exists
 	project, err := getProject(projectID)
 	if err != nil {
-		http.Error(w, "Failed to get project", http.StatusInternalServerError)
+		writeError(w, r, "Failed to get project", http.StatusInternalServerError)
 		logError(r.Context(), "Failed to get project for AI-toolkit export", err, slog.String("project_id", projectID))
 		return
 	}
 	if project == nil {
-		http.Error(w, "Project not found", http.StatusNotFound)
+		writeError(w, r, "Project not found", http.StatusNotFound)
 		return
 	}
 
 	// AI-toolkit export is only available for edit projects
 	if project.ProjectType == "caption" {
-		http.Error(w, "AI-toolkit export is not available for caption projects", http.StatusBadRequest)
+		writeError(w, r, "AI-toolkit export is not available for caption projects", http.StatusBadRequest)
 		return
 	}
 
-	// Start async export
-	go asyncExportAIToolkit(projectID, project)
+	if async {
+		// Build the archive in the background and report progress over SSE
+		go asyncExportAIToolkit(projectID, project, layout, originals, withMetadata)
 
-	// Return immediate response
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"message":   "Export started",
-		"projectId": projectID,
-		"type":      "ai-toolkit",
-	})
+		writeJSON(w, r, http.StatusOK, map[string]interface{}{
+			"message":     "Export started",
+			"projectId":   projectID,
+			"type":        "ai-toolkit",
+			"downloadUrl": exportDownloadURL(projectID), // Serves the archive once export-status reports completed
+		})
+		return
+	}
+
+	tasks, imageMap, err := getAIToolkitExportTasks(projectID, withMetadata)
+	if err != nil {
+		writeError(w, r, "Failed to load export data", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to load AI-toolkit export data", err, slog.String("project_id", projectID))
+		return
+	}
+
+	// Stream the archive straight to the client; nothing is staged on disk
+	filename := fmt.Sprintf("%s_ai-toolkit.zip", project.Name)
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
+
+	zipWriter := zip.NewWriter(w)
+	exportCount := 0
+	for _, task := range tasks {
+		if err := r.Context().Err(); err != nil {
+			logInfo(r.Context(), "AI-toolkit export cancelled by client", slog.String("project_id", projectID))
+			return
+		}
+		written, err := writeAIToolkitPair(zipWriter, task, imageMap, exportCount, layout, originals)
+		if err != nil {
+			// Headers are already sent, so the truncated archive is all we can report
+			logError(r.Context(), "Failed to stream AI-toolkit export", err, slog.String("project_id", projectID))
+			return
+		}
+		if written {
+			exportCount++
+		}
+	}
+	if err := zipWriter.Close(); err != nil {
+		logError(r.Context(), "Failed to finish AI-toolkit export", err, slog.String("project_id", projectID))
+		return
+	}
+
+	logInfo(r.Context(), "AI-toolkit export completed",
+		slog.String("project_id", projectID),
+		slog.Int("exported_pairs", exportCount))
+}
+
+// getAIToolkitExportTasks returns the exportable tasks of a project (completed,
+// not skipped, with both images present, distinct and not excluded) along with an image
+// lookup map, with image metadata attached if withMetadata is set
+func getAIToolkitExportTasks(projectID string, withMetadata bool) ([]Task, map[string]*Image, error) {
+	tasks, err := getTasksByProjectID(projectID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	images, err := loadProjectImages(projectID, withMetadata)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	imageMap := make(map[string]*Image)
+	for i := range images {
+		imageMap[images[i].ID] = &images[i]
+	}
+
+	var exportable []Task
+	for _, task := range tasks {
+		if !task.Skipped && task.ImageBId.Valid && task.Prompt.Valid {
+			if isSelfPair(&task) {
+				logger.Warn("Skipping self-paired task in AI-toolkit export",
+					"project_id", projectID,
+					"task_id", task.ID,
+				)
+				continue
+			}
+			if imageMap[task.ImageAID] != nil && imageMap[task.ImageBId.String] != nil && !involvesExcludedImage(&task, imageMap) {
+				exportable = append(exportable, task)
+			}
+		}
+	}
+
+	return exportable, imageMap, nil
 }
 
-func asyncExportAIToolkit(projectID string, project *Project) {
+func asyncExportAIToolkit(projectID string, project *Project, layout AIToolkitLayout, originals, withMetadata bool) {
 	startTime := "2023-01-01T00:00:00Z" // You might want to use actual timestamp
 	
 	// Initialize export status
@@ -1471,9 +3056,7 @@ func asyncExportAIToolkit(projectID string, project *Project) {
 		Status:     "processing",
 	})
 
-	// Get completed tasks
-	tasks, err := getTasksByProjectID(projectID)
-	if err != nil {
+	failExport := func(err error) {
 		status.Status = "error"
 		status.Error = err.Error()
 		updateExportStatus(projectID, status)
@@ -1483,146 +3066,66 @@ func asyncExportAIToolkit(projectID string, project *Project) {
 			Status:       "error",
 			ErrorMessage: err.Error(),
 		})
-		return
 	}
 
-	// Get all images for path lookup
-	images, err := getImagesByProjectID(projectID)
+	tasks, imageMap, err := getAIToolkitExportTasks(projectID, withMetadata)
 	if err != nil {
-		status.Status = "error"
-		status.Error = err.Error()
-		updateExportStatus(projectID, status)
-		sendExportProgress(projectID, ExportProgress{
-			ProjectID:    projectID,
-			ExportType:   "ai-toolkit",
-			Status:       "error",
-			ErrorMessage: err.Error(),
-		})
+		failExport(err)
 		return
 	}
 
-	// Create image lookup map
-	imageMap := make(map[string]*Image)
-	for i := range images {
-		imageMap[images[i].ID] = &images[i]
-	}
-
-	// Count valid tasks for progress tracking
-	validTasks := 0
-	for _, task := range tasks {
-		if !task.Skipped && task.ImageBId.Valid && task.Prompt.Valid {
-			imageA := imageMap[task.ImageAID]
-			imageB := imageMap[task.ImageBId.String]
-			if imageA != nil && imageB != nil {
-				validTasks++
-			}
-		}
-	}
-
+	validTasks := len(tasks)
 	status.Total = validTasks
 	updateExportStatus(projectID, status)
 
-	// Create temporary export directory
-	exportDir := filepath.Join("data", "exports", projectID+"-ai-toolkit")
-	sourceDir := filepath.Join(exportDir, "source")
-	targetDir := filepath.Join(exportDir, "target")
-
-	// Clean and create directories
-	os.RemoveAll(exportDir)
-	if err := os.MkdirAll(sourceDir, 0755); err != nil {
-		status.Status = "error"
-		status.Error = err.Error()
-		updateExportStatus(projectID, status)
-		return
-	}
-	if err := os.MkdirAll(targetDir, 0755); err != nil {
-		status.Status = "error"
-		status.Error = err.Error()
-		updateExportStatus(projectID, status)
+	if err := os.MkdirAll(exportsDir, 0755); err != nil {
+		failExport(err)
 		return
 	}
 
 	sendExportProgress(projectID, ExportProgress{
 		ProjectID:  projectID,
 		ExportType: "ai-toolkit",
-		Step:       "copying_files",
+		Step:       "creating_zip",
 		Status:     "processing",
 		Total:      validTasks,
 	})
 
-	// Process completed tasks with worker pool
-	exportCount := 0
-	processedTasks := 0
-	var exportCountMu sync.Mutex
-	
-	// Worker pool for file operations
-	const numWorkers = 4
-	taskChan := make(chan Task, validTasks)
-	resultChan := make(chan bool, validTasks)
-	
-	// Start workers
-	for i := 0; i < numWorkers; i++ {
-		go func() {
-			for task := range taskChan {
-				exportCountMu.Lock()
-				currentCount := exportCount
-				exportCount++
-				exportCountMu.Unlock()
-				
-				success := processTaskForAIToolkit(task, imageMap, projectID, sourceDir, targetDir, currentCount)
-				resultChan <- success
-			}
-		}()
+	// Write pairs straight into the archive rather than staging them on disk first
+	zipPath := exportArtifactPath(projectID, "ai-toolkit")
+	zipFile, err := os.Create(zipPath)
+	if err != nil {
+		failExport(err)
+		return
 	}
+	defer zipFile.Close()
 
-	// Send tasks to workers
-	for _, task := range tasks {
-		if !task.Skipped && task.ImageBId.Valid && task.Prompt.Valid {
-			imageA := imageMap[task.ImageAID]
-			imageB := imageMap[task.ImageBId.String]
-			if imageA != nil && imageB != nil {
-				taskChan <- task
-			}
+	zipWriter := zip.NewWriter(zipFile)
+	exportCount := 0
+	for i, task := range tasks {
+		written, err := writeAIToolkitPair(zipWriter, task, imageMap, exportCount, layout, originals)
+		if err != nil {
+			failExport(err)
+			return
+		}
+		if written {
+			exportCount++
 		}
-	}
-	close(taskChan)
 
-	// Collect results and update progress
-	for i := 0; i < validTasks; i++ {
-		<-resultChan
-		processedTasks++
-		status.Progress = processedTasks
+		status.Progress = i + 1
 		updateExportStatus(projectID, status)
-		
+
 		sendExportProgress(projectID, ExportProgress{
 			ProjectID:  projectID,
 			ExportType: "ai-toolkit",
-			Step:       "copying_files",
-			Progress:   processedTasks,
+			Step:       "creating_zip",
+			Progress:   i + 1,
 			Total:      validTasks,
 			Status:     "processing",
 		})
 	}
-
-	sendExportProgress(projectID, ExportProgress{
-		ProjectID:  projectID,
-		ExportType: "ai-toolkit",
-		Step:       "creating_zip",
-		Status:     "processing",
-	})
-
-	// Create ZIP archive with progress
-	zipPath := filepath.Join("data", "exports", project.Name+"_ai-toolkit.zip")
-	if err := createZipArchiveWithProgress(exportDir, zipPath, projectID); err != nil {
-		status.Status = "error"
-		status.Error = err.Error()
-		updateExportStatus(projectID, status)
-		sendExportProgress(projectID, ExportProgress{
-			ProjectID:    projectID,
-			ExportType:   "ai-toolkit",
-			Status:       "error",
-			ErrorMessage: err.Error(),
-		})
+	if err := zipWriter.Close(); err != nil {
+		failExport(err)
 		return
 	}
 
@@ -1630,6 +3133,8 @@ func asyncExportAIToolkit(projectID string, project *Project) {
 	status.Status = "completed"
 	status.FilePath = zipPath
 	status.CompletedAt = "2023-01-01T00:05:00Z" // You might want to use actual timestamp
+	status.DownloadURL = exportDownloadURL(projectID)
+	status.ExpiresAt = exportArtifactExpiry()
 	updateExportStatus(projectID, status)
 
 	sendExportProgress(projectID, ExportProgress{
@@ -1639,81 +3144,177 @@ func asyncExportAIToolkit(projectID string, project *Project) {
 		FilePath:   zipPath,
 	})
 
-	// Clean up temporary directory (but keep zip file for download)
-	go func() {
-		os.RemoveAll(exportDir)
-	}()
-
-	exportCountMu.Lock()
-	finalExportCount := exportCount
-	exportCountMu.Unlock()
-	
 	logger.Info("AI-toolkit export completed",
 		"project_id", projectID,
-		"exported_pairs", finalExportCount)
+		"exported_pairs", exportCount)
+}
+
+// AIToolkitLayout controls the folder and file names of an AI-toolkit archive
+type AIToolkitLayout struct {
+	SourceFolder string // Folder holding image A of each pair
+	TargetFolder string // Folder holding image B of each pair
+	NamePattern  string // Pair base name with a single %d verb for the 1-based pair number
+}
+
+var defaultAIToolkitLayout = AIToolkitLayout{
+	SourceFolder: "source",
+	TargetFolder: "target",
+	NamePattern:  "pair_%04d",
+}
+
+// parseAIToolkitLayout reads the sourceFolder, targetFolder and namePattern
+// query parameters, falling back to the default layout for any left out
+func parseAIToolkitLayout(r *http.Request) (AIToolkitLayout, error) {
+	layout := defaultAIToolkitLayout
+	query := r.URL.Query()
+	if value := query.Get("sourceFolder"); value != "" {
+		layout.SourceFolder = value
+	}
+	if value := query.Get("targetFolder"); value != "" {
+		layout.TargetFolder = value
+	}
+	if value := query.Get("namePattern"); value != "" {
+		layout.NamePattern = value
+	}
+
+	if err := validateArchiveName(layout.SourceFolder); err != nil {
+		return layout, fmt.Errorf("invalid sourceFolder: %v", err)
+	}
+	if err := validateArchiveName(layout.TargetFolder); err != nil {
+		return layout, fmt.Errorf("invalid targetFolder: %v", err)
+	}
+	if layout.SourceFolder == layout.TargetFolder {
+		return layout, fmt.Errorf("sourceFolder and targetFolder must differ")
+	}
+	if err := validateNamePattern(layout.NamePattern); err != nil {
+		return layout, fmt.Errorf("invalid namePattern: %v", err)
+	}
+	return layout, nil
+}
+
+// validateArchiveName checks that name is a single path element, so it can't
+// escape its folder when the archive is extracted
+func validateArchiveName(name string) error {
+	if name == "." || name == ".." {
+		return fmt.Errorf("%q is not allowed", name)
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-' || c == '.') {
+			return fmt.Errorf("%q may only contain letters, digits, '_', '-' and '.'", name)
+		}
+	}
+	return nil
+}
+
+// validateNamePattern checks that pattern has exactly one %d verb, optionally
+// zero padded (e.g. %04d), and renders to a valid archive name
+func validateNamePattern(pattern string) error {
+	if strings.Count(pattern, "%") != 1 {
+		return fmt.Errorf("must contain exactly one %%d placeholder")
+	}
+	verb := pattern[strings.Index(pattern, "%")+1:]
+	verb = strings.TrimLeft(verb, "0123456789")
+	if !strings.HasPrefix(verb, "d") {
+		return fmt.Errorf("placeholder must be %%d or a zero padded form such as %%04d")
+	}
+	return validateArchiveName(fmt.Sprintf(pattern, 1))
 }
 
-func processTaskForAIToolkit(task Task, imageMap map[string]*Image, projectID, sourceDir, targetDir string, exportCount int) bool {
+// writeAIToolkitPair adds one source/target pair with its caption files to the
+// archive. Pairs whose images can't be opened are skipped (written is false)
+// before anything is written, so the archive stays consistent. With originals
+// set, images archived by keepOriginal are written at full size.
+func writeAIToolkitPair(zipWriter *zip.Writer, task Task, imageMap map[string]*Image, exportCount int, layout AIToolkitLayout, originals bool) (bool, error) {
 	imageA := imageMap[task.ImageAID]
 	imageB := imageMap[task.ImageBId.String]
 	if imageA == nil || imageB == nil {
-		return false
+		return false, nil
 	}
 
-	// Generate unique filename for this pair
-	baseName := fmt.Sprintf("pair_%04d", exportCount+1)
-
-	// Copy source image
-	sourceImagePath := filepath.Join("data", "projects", projectID, imageA.Path)
-	destSourcePath := filepath.Join(sourceDir, baseName+filepath.Ext(imageA.Path))
-	if err := copyFile(sourceImagePath, destSourcePath); err != nil {
-		logger.Error("Failed to copy source image", "error", err)
-		return false
+	sourceFile, err := os.Open(exportImageFilePath(imageA, originals))
+	if err != nil {
+		logger.Error("Failed to open source image", "error", err)
+		return false, nil
 	}
+	defer sourceFile.Close()
 
-	// Copy target image
-	targetImagePath := filepath.Join("data", "projects", projectID, imageB.Path)
-	destTargetPath := filepath.Join(targetDir, baseName+filepath.Ext(imageB.Path))
-	if err := copyFile(targetImagePath, destTargetPath); err != nil {
-		logger.Error("Failed to copy target image", "error", err)
-		return false
+	targetFile, err := os.Open(exportImageFilePath(imageB, originals))
+	if err != nil {
+		logger.Error("Failed to open target image", "error", err)
+		return false, nil
 	}
+	defer targetFile.Close()
 
-	// Write caption files in both source and target folders
-	sourceCaptionPath := filepath.Join(sourceDir, baseName+".txt")
-	targetCaptionPath := filepath.Join(targetDir, baseName+".txt")
-
+	// Generate unique filename for this pair
+	baseName := fmt.Sprintf(layout.NamePattern, exportCount+1)
 	captionContent := []byte(task.Prompt.String)
-
-	if err := os.WriteFile(sourceCaptionPath, captionContent, 0644); err != nil {
-		logger.Error("Failed to write source caption file", "error", err)
-		return false
+	sourcePrefix := layout.SourceFolder + "/" + baseName
+	targetPrefix := layout.TargetFolder + "/" + baseName
+
+	type archiveEntry struct {
+		name   string
+		source io.Reader
+	}
+	entries := []archiveEntry{
+		{sourcePrefix + filepath.Ext(exportImagePath(imageA, originals)), sourceFile},
+		{sourcePrefix + ".txt", bytes.NewReader(captionContent)},
+		{targetPrefix + filepath.Ext(exportImagePath(imageB, originals)), targetFile},
+		{targetPrefix + ".txt", bytes.NewReader(captionContent)},
+	}
+	if task.NegativePrompt.Valid {
+		negativeContent := []byte(task.NegativePrompt.String)
+		entries = append(entries,
+			archiveEntry{sourcePrefix + ".negative.txt", bytes.NewReader(negativeContent)},
+			archiveEntry{targetPrefix + ".negative.txt", bytes.NewReader(negativeContent)},
+		)
+	}
+	// Metadata is only attached when the export asked for it
+	for _, side := range []struct {
+		prefix string
+		image  *Image
+	}{{sourcePrefix, imageA}, {targetPrefix, imageB}} {
+		if len(side.image.Metadata) == 0 {
+			continue
+		}
+		metadataContent, err := json.MarshalIndent(side.image.Metadata, "", "  ")
+		if err != nil {
+			return false, err
+		}
+		entries = append(entries, archiveEntry{side.prefix + ".metadata.json", bytes.NewReader(metadataContent)})
 	}
 
-	if err := os.WriteFile(targetCaptionPath, captionContent, 0644); err != nil {
-		logger.Error("Failed to write target caption file", "error", err)
-		return false
+	buffer := make([]byte, 64*1024) // 64KB buffer
+	for _, entry := range entries {
+		entryWriter, err := zipWriter.CreateHeader(&zip.FileHeader{
+			Name:   entry.name,
+			Method: zip.Deflate,
+		})
+		if err != nil {
+			return false, err
+		}
+		if _, err := io.CopyBuffer(entryWriter, entry.source, buffer); err != nil {
+			return false, fmt.Errorf("failed to write %s: %v", entry.name, err)
+		}
 	}
 
-	return true
+	return true, nil
 }
 
 func exportImageTextPairsHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "GET" {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
 	projectID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/projects/"), "/export/image-text-pairs")
 	if projectID == "" {
-		http.Error(w, "Project ID is required", http.StatusBadRequest)
+		writeError(w, r, "Project ID is required", http.StatusBadRequest)
 		return
 	}
 
 	// Check if there's already an active export
 	if status := getExportStatus(projectID); status != nil && status.Status == "processing" {
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]interface{}{
+		writeJSON(w, r, http.StatusOK, map[string]interface{}{
 			"message": "Export already in progress",
 			"status":  status,
 		})
@@ -1723,34 +3324,52 @@ func exportImageTextPairsHandler(w http.ResponseWriter, r *http.Request) {
 	// Check if project exists
 	project, err := getProject(projectID)
 	if err != nil {
-		http.Error(w, "Failed to get project", http.StatusInternalServerError)
+		writeError(w, r, "Failed to get project", http.StatusInternalServerError)
 		logError(r.Context(), "Failed to get project for image-text-pairs export", err, slog.String("project_id", projectID))
 		return
 	}
 	if project == nil {
-		http.Error(w, "Project not found", http.StatusNotFound)
+		writeError(w, r, "Project not found", http.StatusNotFound)
 		return
 	}
 
 	// Image-text-pairs export is only available for caption projects
 	if project.ProjectType != "caption" {
-		http.Error(w, "Image-text-pairs export is only available for caption projects", http.StatusBadRequest)
+		writeError(w, r, "Image-text-pairs export is only available for caption projects", http.StatusBadRequest)
+		return
+	}
+
+	statuses, err := parseCaptionStatusFilter(r)
+	if err != nil {
+		writeError(w, r, err.Error(), http.StatusBadRequest)
 		return
 	}
+	order, err := parseCaptionExportOrder(r)
+	if err != nil {
+		writeError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	originals := r.URL.Query().Get("originals") == "true"
+	withMetadata := r.URL.Query().Get("metadata") == "true"
 
 	// Start async export
-	go asyncExportImageTextPairs(projectID, project)
+	go asyncExportImageTextPairs(projectID, project, statuses, order, originals, withMetadata)
 
 	// Return immediate response
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"message":   "Export started",
-		"projectId": projectID,
-		"type":      "image-text-pairs",
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"message":     "Export started",
+		"projectId":   projectID,
+		"type":        "image-text-pairs",
+		"downloadUrl": exportDownloadURL(projectID), // Serves the archive once export-status reports completed
 	})
 }
 
-func asyncExportImageTextPairs(projectID string, project *Project) {
+// asyncExportImageTextPairs builds a caption project's image-text-pairs archive.
+// statuses, if not nil, limits it to caption tasks with those statuses, and
+// order sets the order pairs are numbered in, and originals picks images
+// archived by keepOriginal over their downscaled versions. With withMetadata,
+// each image's metadata is written next to it as N.metadata.json.
+func asyncExportImageTextPairs(projectID string, project *Project, statuses map[string]bool, order string, originals, withMetadata bool) {
 	startTime := "2023-01-01T00:00:00Z" // You might want to use actual timestamp
 	
 	// Initialize export status
@@ -1771,7 +3390,7 @@ func asyncExportImageTextPairs(projectID string, project *Project) {
 	})
 
 	// Get completed caption tasks
-	captionTasks, err := getCaptionTasksByProjectID(projectID)
+	captionTasks, err := getCaptionTasksForExport(projectID, order)
 	if err != nil {
 		status.Status = "error"
 		status.Error = err.Error()
@@ -1784,9 +3403,18 @@ func asyncExportImageTextPairs(projectID string, project *Project) {
 		})
 		return
 	}
+	if statuses != nil {
+		var filtered []CaptionTask
+		for _, task := range captionTasks {
+			if statuses[task.Status] {
+				filtered = append(filtered, task)
+			}
+		}
+		captionTasks = filtered
+	}
 
 	// Get all images for path lookup
-	images, err := getImagesByProjectID(projectID)
+	images, err := loadProjectImages(projectID, withMetadata)
 	if err != nil {
 		status.Status = "error"
 		status.Error = err.Error()
@@ -1800,10 +3428,12 @@ func asyncExportImageTextPairs(projectID string, project *Project) {
 		return
 	}
 
-	// Create image lookup map
+	// Create image lookup map; excluded images are left out, and their tasks with them
 	imageMap := make(map[string]*Image)
 	for i := range images {
-		imageMap[images[i].ID] = &images[i]
+		if !images[i].Excluded {
+			imageMap[images[i].ID] = &images[i]
+		}
 	}
 
 	// Count valid tasks for progress tracking
@@ -1818,7 +3448,7 @@ func asyncExportImageTextPairs(projectID string, project *Project) {
 	updateExportStatus(projectID, status)
 
 	// Create temporary export directory
-	exportDir := filepath.Join("data", "exports", projectID+"-image-text-pairs")
+	exportDir := filepath.Join(exportsDir, projectID+"-image-text-pairs")
 
 	// Clean and create directory
 	os.RemoveAll(exportDir)
@@ -1856,7 +3486,7 @@ func asyncExportImageTextPairs(projectID string, project *Project) {
 				exportCount++
 				exportCountMu.Unlock()
 				
-				success := processTaskForImageTextPairs(task, imageMap, projectID, exportDir, currentCount)
+				success := processTaskForImageTextPairs(task, imageMap, projectID, exportDir, currentCount, originals)
 				resultChan <- success
 			}
 		}()
@@ -1895,7 +3525,7 @@ func asyncExportImageTextPairs(projectID string, project *Project) {
 	})
 
 	// Create ZIP archive with progress
-	zipPath := filepath.Join("data", "exports", project.Name+"_image-text-pairs.zip")
+	zipPath := exportArtifactPath(projectID, "image-text-pairs")
 	if err := createZipArchiveWithProgress(exportDir, zipPath, projectID); err != nil {
 		status.Status = "error"
 		status.Error = err.Error()
@@ -1913,6 +3543,8 @@ func asyncExportImageTextPairs(projectID string, project *Project) {
 	status.Status = "completed"
 	status.FilePath = zipPath
 	status.CompletedAt = "2023-01-01T00:05:00Z" // You might want to use actual timestamp
+	status.DownloadURL = exportDownloadURL(projectID)
+	status.ExpiresAt = exportArtifactExpiry()
 	updateExportStatus(projectID, status)
 
 	sendExportProgress(projectID, ExportProgress{
@@ -1936,7 +3568,7 @@ func asyncExportImageTextPairs(projectID string, project *Project) {
 		"exported_pairs", finalExportCount)
 }
 
-func processTaskForImageTextPairs(task CaptionTask, imageMap map[string]*Image, projectID, exportDir string, exportCount int) bool {
+func processTaskForImageTextPairs(task CaptionTask, imageMap map[string]*Image, projectID, exportDir string, exportCount int, originals bool) bool {
 	image := imageMap[task.ImageID]
 	if image == nil {
 		return false
@@ -1947,7 +3579,7 @@ func processTaskForImageTextPairs(task CaptionTask, imageMap map[string]*Image,
 	textFileName := fmt.Sprintf("%d.txt", exportCount+1)
 
 	// Read and convert image to PNG
-	sourceImagePath := filepath.Join("data", "projects", projectID, image.Path)
+	sourceImagePath := exportImageFilePath(image, originals)
 	destImagePath := filepath.Join(exportDir, imageFileName)
 	
 	if err := convertImageToPNG(sourceImagePath, destImagePath); err != nil {
@@ -1964,6 +3596,20 @@ func processTaskForImageTextPairs(task CaptionTask, imageMap map[string]*Image,
 		return false
 	}
 
+	// Metadata is only attached when the export asked for it
+	if len(image.Metadata) > 0 {
+		metadataContent, err := json.MarshalIndent(image.Metadata, "", "  ")
+		if err != nil {
+			logger.Error("Failed to encode image metadata", "error", err)
+			return false
+		}
+		metadataFilePath := filepath.Join(exportDir, fmt.Sprintf("%d.metadata.json", exportCount+1))
+		if err := os.WriteFile(metadataFilePath, metadataContent, 0644); err != nil {
+			logger.Error("Failed to write metadata file", "error", err)
+			return false
+		}
+	}
+
 	return true
 }
 
@@ -2016,10 +3662,13 @@ func convertImageWithOptions(sourcePath, destPath string, preserveFormat bool) e
 	}
 
 	// Only do actual PNG conversion for WebP or other formats that benefit from it
-	sourceFile.Seek(0, 0) // Reset file pointer
+	content, err := os.ReadFile(sourcePath)
+	if err != nil {
+		return fmt.Errorf("failed to read source image: %v", err)
+	}
 
-	// Decode image (supports JPEG, PNG, WebP)
-	img, _, err := image.Decode(sourceFile)
+	// Decode image (supports JPEG, PNG, WebP, GIF; animations use the first frame)
+	img, _, _, err := decodeFirstFrame(content)
 	if err != nil {
 		return fmt.Errorf("failed to decode image: %v", err)
 	}
@@ -2193,13 +3842,13 @@ func createZipArchiveWithProgress(sourceDir, zipPath, projectID string) error {
 
 func exportProgressHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
 	projectID := r.URL.Query().Get("projectId")
 	if projectID == "" {
-		http.Error(w, "Project ID is required", http.StatusBadRequest)
+		writeError(w, r, "Project ID is required", http.StatusBadRequest)
 		return
 	}
 
@@ -2238,55 +3887,53 @@ func exportProgressHandler(w http.ResponseWriter, r *http.Request) {
 
 func getExportStatusHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
 	projectID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/projects/"), "/export-status")
 	if projectID == "" {
-		http.Error(w, "Project ID is required", http.StatusBadRequest)
+		writeError(w, r, "Project ID is required", http.StatusBadRequest)
 		return
 	}
 
 	status := getExportStatus(projectID)
 	if status == nil {
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]string{"status": "none"})
+		writeJSON(w, r, http.StatusOK, map[string]string{"status": "none"})
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(status)
+	writeJSON(w, r, http.StatusOK, status)
 }
 
 func downloadExportHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
 	projectID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/projects/"), "/export/download")
 	if projectID == "" {
-		http.Error(w, "Project ID is required", http.StatusBadRequest)
+		writeError(w, r, "Project ID is required", http.StatusBadRequest)
 		return
 	}
 
 	status := getExportStatus(projectID)
 	if status == nil || status.Status != "completed" || status.FilePath == "" {
-		http.Error(w, "No completed export found", http.StatusNotFound)
+		writeError(w, r, "No completed export found", http.StatusNotFound)
 		return
 	}
 
 	// Check if file exists
 	if _, err := os.Stat(status.FilePath); os.IsNotExist(err) {
-		http.Error(w, "Export file not found", http.StatusNotFound)
+		writeError(w, r, "Export file not found; it may have expired, so run the export again", http.StatusNotFound)
 		return
 	}
 
 	// Get project info for filename
 	project, err := getProject(projectID)
 	if err != nil {
-		http.Error(w, "Failed to get project", http.StatusInternalServerError)
+		writeError(w, r, "Failed to get project", http.StatusInternalServerError)
 		return
 	}
 
@@ -2306,38 +3953,38 @@ type ForkProjectRequest struct {
 
 func forkProjectHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
 	projectID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/projects/"), "/fork")
 	if projectID == "" {
-		http.Error(w, "Project ID is required", http.StatusBadRequest)
+		writeError(w, r, "Project ID is required", http.StatusBadRequest)
 		return
 	}
 
 	// Check if source project exists
 	sourceProject, err := getProject(projectID)
 	if err != nil {
-		http.Error(w, "Failed to get source project", http.StatusInternalServerError)
+		writeError(w, r, "Failed to get source project", http.StatusInternalServerError)
 		logError(r.Context(), "Failed to get source project for fork", err, slog.String("project_id", projectID))
 		return
 	}
 	if sourceProject == nil {
-		http.Error(w, "Source project not found", http.StatusNotFound)
+		writeError(w, r, "Source project not found", http.StatusNotFound)
 		return
 	}
 
 	// Parse fork request
 	var req ForkProjectRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, err.Error(), http.StatusBadRequest)
 		return
 	}
 
 	// Validate fork request
 	if req.Name == "" {
-		http.Error(w, "Project name is required", http.StatusBadRequest)
+		writeError(w, r, "Project name is required", http.StatusBadRequest)
 		return
 	}
 	if req.Version == "" {
@@ -2354,15 +4001,15 @@ func forkProjectHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := createProject(&forkedProject); err != nil {
-		http.Error(w, "Failed to create forked project", http.StatusInternalServerError)
+		writeError(w, r, "Failed to create forked project", http.StatusInternalServerError)
 		logError(r.Context(), "Failed to create forked project", err, slog.String("source_project_id", projectID))
 		return
 	}
 
 	// Get source images
-	sourceImages, err := getImagesByProjectID(projectID)
+	sourceImages, err := loadProjectImages(projectID, true)
 	if err != nil {
-		http.Error(w, "Failed to get source images", http.StatusInternalServerError)
+		writeError(w, r, "Failed to get source images", http.StatusInternalServerError)
 		logError(r.Context(), "Failed to get source images for fork", err, slog.String("project_id", projectID))
 		return
 	}
@@ -2370,7 +4017,7 @@ func forkProjectHandler(w http.ResponseWriter, r *http.Request) {
 	// Create forked project directory
 	forkedImageDir := filepath.Join("data", "projects", forkedProject.ID, "images")
 	if err := os.MkdirAll(forkedImageDir, 0755); err != nil {
-		http.Error(w, "Failed to create forked project directory", http.StatusInternalServerError)
+		writeError(w, r, "Failed to create forked project directory", http.StatusInternalServerError)
 		logError(r.Context(), "Failed to create forked project directory", err)
 		return
 	}
@@ -2378,33 +4025,67 @@ func forkProjectHandler(w http.ResponseWriter, r *http.Request) {
 	// Copy images and create new image records
 	var forkedImages []Image
 	for _, sourceImage := range sourceImages {
-		// Copy image file
-		sourceImagePath := filepath.Join("data", "projects", projectID, sourceImage.Path)
-		forkedImagePath := filepath.Join("data", "projects", forkedProject.ID, sourceImage.Path)
-		if err := copyFile(sourceImagePath, forkedImagePath); err != nil {
-			logError(r.Context(), "Failed to copy image file", err,
-				slog.String("source", sourceImagePath),
-				slog.String("dest", forkedImagePath))
-			continue
+		// Copy image file; blobs are shared by reference instead
+		if sourceImage.BlobHash == "" {
+			sourceImagePath := filepath.Join("data", "projects", projectID, sourceImage.Path)
+			forkedImagePath := filepath.Join("data", "projects", forkedProject.ID, sourceImage.Path)
+			if err := copyFile(sourceImagePath, forkedImagePath); err != nil {
+				logError(r.Context(), "Failed to copy image file", err,
+					slog.String("source", sourceImagePath),
+					slog.String("dest", forkedImagePath))
+				continue
+			}
+		}
+		// Originals are kept under the project either way
+		var originalPath *string
+		if sourceImage.OriginalPath != nil {
+			forkedOriginalPath := filepath.Join("data", "projects", forkedProject.ID, *sourceImage.OriginalPath)
+			err := os.MkdirAll(filepath.Dir(forkedOriginalPath), 0755)
+			if err == nil {
+				err = copyFile(originalFilePath(&sourceImage), forkedOriginalPath)
+			}
+			if err != nil {
+				logError(r.Context(), "Failed to copy original image file", err,
+					slog.String("source", originalFilePath(&sourceImage)),
+					slog.String("dest", forkedOriginalPath))
+			} else {
+				originalPath = sourceImage.OriginalPath
+			}
 		}
 
 		// Create new image record
 		forkedImage := Image{
-			ID:        uuid.New().String(),
-			ProjectID: forkedProject.ID,
-			Path:      sourceImage.Path,
-			PHash:     sourceImage.PHash,
+			ID:           uuid.New().String(),
+			ProjectID:    forkedProject.ID,
+			Path:         sourceImage.Path,
+			PHash:        sourceImage.PHash,
+			DHash:        sourceImage.DHash,
+			Notes:        sourceImage.Notes,
+			Excluded:     sourceImage.Excluded,
+			Metadata:     sourceImage.Metadata,
+			Animated:     sourceImage.Animated,
+			BlobHash:     sourceImage.BlobHash,
+			ContentHash:  sourceImage.ContentHash,
+			OriginalPath: originalPath,
 		}
 		forkedImages = append(forkedImages, forkedImage)
 	}
 
 	// Store forked images in database
 	if len(forkedImages) > 0 {
-		if err := createImages(forkedImages); err != nil {
-			http.Error(w, "Failed to store forked images", http.StatusInternalServerError)
+		if _, _, err := createImages(forkedImages); err != nil {
+			writeError(w, r, "Failed to store forked images", http.StatusInternalServerError)
 			logError(r.Context(), "Failed to store forked images", err, slog.String("forked_project_id", forkedProject.ID))
 			return
 		}
+		for _, forkedImage := range forkedImages {
+			if len(forkedImage.Metadata) == 0 {
+				continue
+			}
+			if err := replaceImageMetadata(forkedImage.ID, forkedImage.Metadata); err != nil {
+				logError(r.Context(), "Failed to copy image metadata", err, slog.String("image_id", forkedImage.ID))
+			}
+		}
 	}
 
 	// Get source tasks and copy them to forked project
@@ -2431,12 +4112,14 @@ func forkProjectHandler(w http.ResponseWriter, r *http.Request) {
 			}
 
 			forkedTask := Task{
-				ID:        uuid.New().String(),
-				ProjectID: forkedProject.ID,
-				ImageAID:  forkedImageAID,
-				ImageBId:  sourceTask.ImageBId,
-				Prompt:    sourceTask.Prompt,
-				Skipped:   sourceTask.Skipped,
+				ID:             uuid.New().String(),
+				ProjectID:      forkedProject.ID,
+				ImageAID:       forkedImageAID,
+				ImageBId:       sourceTask.ImageBId,
+				Prompt:         sourceTask.Prompt,
+				NegativePrompt: sourceTask.NegativePrompt,
+				Skipped:        sourceTask.Skipped,
+				Region:         sourceTask.Region,
 			}
 
 			// Update ImageBId if it exists and is mapped
@@ -2449,14 +4132,17 @@ func forkProjectHandler(w http.ResponseWriter, r *http.Request) {
 				}
 			}
 
-			// Map candidate B IDs
+			// Map candidate B IDs, keeping their distances
 			var forkedCandidateIDs []string
-			for _, candidateID := range sourceTask.CandidateBIds {
-				if forkedCandidateID, hasCandidate := imageIDMap[candidateID]; hasCandidate {
+			var forkedCandidates []TaskCandidate
+			for _, candidate := range sourceTask.Candidates {
+				if forkedCandidateID, hasCandidate := imageIDMap[candidate.ImageID]; hasCandidate {
 					forkedCandidateIDs = append(forkedCandidateIDs, forkedCandidateID)
+					forkedCandidates = append(forkedCandidates, TaskCandidate{ImageID: forkedCandidateID, Distance: candidate.Distance})
 				}
 			}
 			forkedTask.CandidateBIds = forkedCandidateIDs
+			forkedTask.Candidates = forkedCandidates
 
 			forkedTasks = append(forkedTasks, forkedTask)
 		}
@@ -2487,56 +4173,95 @@ func forkProjectHandler(w http.ResponseWriter, r *http.Request) {
 			slog.Int("images_copied", len(forkedImages)),
 			slog.Int("tasks_copied", 0))
 	}
+	invalidateProjectStats(forkedProject.ID)
+
+	writeJSON(w, r, http.StatusOK, forkedProject)
+}
+
+func generateTaskPromptHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	taskID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/tasks/"), "/generate-prompt")
+	if taskID == "" {
+		writeError(w, r, "Task ID is required", http.StatusBadRequest)
+		return
+	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(forkedProject)
+	task, err := getTask(taskID)
+	if err != nil {
+		writeError(w, r, "Failed to get task", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to get task for prompt generation", err, slog.String("task_id", taskID))
+		return
+	}
+	if task == nil {
+		writeError(w, r, "Task not found", http.StatusNotFound)
+		return
+	}
+	if !task.ImageBId.Valid || task.ImageBId.String == "" {
+		writeError(w, r, "Task has no selected image B", http.StatusBadRequest)
+		return
+	}
+
+	updated, err := GeneratePromptForTask(task)
+	if err != nil {
+		writeError(w, r, "Failed to generate prompt", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to generate prompt", err, slog.String("task_id", taskID))
+		return
+	}
+	recordAudit(r.Context(), task.ProjectID, "update", "task", taskID, task, updated)
+
+	logInfo(r.Context(), "Task prompt generated", slog.String("task_id", taskID))
+
+	writeJSON(w, r, http.StatusOK, updated)
 }
 
 func autoCaptionTaskHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
 	taskID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/caption-tasks/"), "/auto-caption")
 	if taskID == "" {
-		http.Error(w, "Caption task ID is required", http.StatusBadRequest)
+		writeError(w, r, "Caption task ID is required", http.StatusBadRequest)
 		return
 	}
 
 	// Get the task to find the project ID
 	task, err := getCaptionTask(taskID)
 	if err != nil {
-		http.Error(w, "Failed to get caption task", http.StatusInternalServerError)
+		writeError(w, r, "Failed to get caption task", http.StatusInternalServerError)
 		logError(r.Context(), "Failed to get caption task for auto-caption", err, slog.String("task_id", taskID))
 		return
 	}
 	if task == nil {
-		http.Error(w, "Caption task not found", http.StatusNotFound)
+		writeError(w, r, "Caption task not found", http.StatusNotFound)
 		return
 	}
 
 	// Generate caption
 	response, err := GenerateCaptionForTask(task.ProjectID, taskID)
 	if err != nil {
-		http.Error(w, "Failed to generate caption", http.StatusInternalServerError)
+		writeError(w, r, "Failed to generate caption", http.StatusInternalServerError)
 		logError(r.Context(), "Failed to generate caption", err, slog.String("task_id", taskID))
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	writeJSON(w, r, http.StatusOK, response)
 }
 
 func startAutoCaptioningHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
 	projectID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/projects/"), "/auto-caption-batch")
 	if projectID == "" {
-		http.Error(w, "Project ID is required", http.StatusBadRequest)
+		writeError(w, r, "Project ID is required", http.StatusBadRequest)
 		return
 	}
 
@@ -2552,10 +4277,14 @@ func startAutoCaptioningHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// Validate config
-	if req.Config.RPM <= 0 {
+	// Validate config; an omitted rate gets the default, an invalid one is rejected
+	if req.Config.RPM == 0 {
 		req.Config.RPM = 30
 	}
+	if err := req.Config.validate(); err != nil {
+		writeError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if req.Config.MaxRetries <= 0 {
 		req.Config.MaxRetries = 3
 	}
@@ -2566,7 +4295,11 @@ func startAutoCaptioningHandler(w http.ResponseWriter, r *http.Request) {
 	// Start auto captioning
 	err := autoCaptionManager.StartAutoCaptioning(projectID, req.Config)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		status := http.StatusBadRequest
+		if errors.Is(err, errTooManyAutoCaptionSessions) {
+			status = http.StatusTooManyRequests
+		}
+		writeError(w, r, err.Error(), status)
 		logError(r.Context(), "Failed to start auto captioning", err, slog.String("project_id", projectID))
 		return
 	}
@@ -2577,8 +4310,7 @@ func startAutoCaptioningHandler(w http.ResponseWriter, r *http.Request) {
 		slog.Int("max_retries", req.Config.MaxRetries),
 	)
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{
 		"message": "Auto captioning started",
 		"config":  req.Config,
 	})
@@ -2586,63 +4318,98 @@ func startAutoCaptioningHandler(w http.ResponseWriter, r *http.Request) {
 
 func cancelAutoCaptioningHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
 	projectID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/projects/"), "/auto-caption-cancel")
 	if projectID == "" {
-		http.Error(w, "Project ID is required", http.StatusBadRequest)
+		writeError(w, r, "Project ID is required", http.StatusBadRequest)
 		return
 	}
 
 	err := autoCaptionManager.CancelAutoCaptioning(projectID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, err.Error(), http.StatusBadRequest)
 		logError(r.Context(), "Failed to cancel auto captioning", err, slog.String("project_id", projectID))
 		return
 	}
 
 	logInfo(r.Context(), "Cancelled auto captioning", slog.String("project_id", projectID))
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{
 		"message": "Auto captioning cancelled",
 	})
 }
 
+// pauseAutoCaptioningHandler handles POST /projects/{id}/auto-caption-pause
+// and /auto-caption-resume
+func pauseAutoCaptioningHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	resume := strings.HasSuffix(r.URL.Path, "/auto-caption-resume")
+	projectID := strings.TrimPrefix(r.URL.Path, "/projects/")
+	projectID = strings.TrimSuffix(strings.TrimSuffix(projectID, "/auto-caption-pause"), "/auto-caption-resume")
+	if projectID == "" {
+		writeError(w, r, "Project ID is required", http.StatusBadRequest)
+		return
+	}
+
+	var err error
+	if resume {
+		err = autoCaptionManager.ResumeAutoCaptioning(projectID)
+	} else {
+		err = autoCaptionManager.PauseAutoCaptioning(projectID)
+	}
+	if err != nil {
+		writeError(w, r, err.Error(), http.StatusConflict)
+		return
+	}
+
+	status, _ := autoCaptionManager.GetAutoCaptionStatus(projectID)
+	if resume {
+		logInfo(r.Context(), "Resumed auto captioning", slog.String("project_id", projectID))
+	} else {
+		logInfo(r.Context(), "Paused auto captioning", slog.String("project_id", projectID))
+	}
+
+	writeJSON(w, r, http.StatusOK, status)
+}
+
 func getAutoCaptionStatusHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
 	projectID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/projects/"), "/auto-caption-status")
 	if projectID == "" {
-		http.Error(w, "Project ID is required", http.StatusBadRequest)
+		writeError(w, r, "Project ID is required", http.StatusBadRequest)
 		return
 	}
 
 	status, err := autoCaptionManager.GetAutoCaptionStatus(projectID)
 	if err != nil {
-		http.Error(w, "Failed to get auto caption status", http.StatusInternalServerError)
+		writeError(w, r, "Failed to get auto caption status", http.StatusInternalServerError)
 		logError(r.Context(), "Failed to get auto caption status", err, slog.String("project_id", projectID))
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(status)
+	writeJSON(w, r, http.StatusOK, status)
 }
 
 func autoCaptionProgressHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
 	projectID := r.URL.Query().Get("projectId")
 	if projectID == "" {
-		http.Error(w, "Project ID is required", http.StatusBadRequest)
+		writeError(w, r, "Project ID is required", http.StatusBadRequest)
 		return
 	}
 
@@ -2676,86 +4443,350 @@ func autoCaptionProgressHandler(w http.ResponseWriter, r *http.Request) {
 
 func approveCaptionTaskHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "PUT" {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
 	taskID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/caption-tasks/"), "/approve")
 	if taskID == "" {
-		http.Error(w, "Caption task ID is required", http.StatusBadRequest)
+		writeError(w, r, "Caption task ID is required", http.StatusBadRequest)
 		return
 	}
 
 	// Get existing task
 	task, err := getCaptionTask(taskID)
 	if err != nil {
-		http.Error(w, "Failed to get caption task", http.StatusInternalServerError)
+		writeError(w, r, "Failed to get caption task", http.StatusInternalServerError)
 		logError(r.Context(), "Failed to get caption task for approval", err, slog.String("task_id", taskID))
 		return
 	}
 	if task == nil {
-		http.Error(w, "Caption task not found", http.StatusNotFound)
+		writeError(w, r, "Caption task not found", http.StatusNotFound)
 		return
 	}
 
 	// Update status to reviewed/completed
+	before := *task
 	task.Status = "completed"
-	if err := updateCaptionTask(task); err != nil {
-		http.Error(w, "Failed to approve caption", http.StatusInternalServerError)
+	if err := updateCaptionTask(task, "manual"); err != nil {
+		writeError(w, r, "Failed to approve caption", http.StatusInternalServerError)
 		logError(r.Context(), "Failed to approve caption task", err, slog.String("task_id", taskID))
 		return
 	}
+	recordAudit(r.Context(), task.ProjectID, "update", "caption_task", taskID, &before, task)
+	invalidateProjectStats(task.ProjectID)
 
 	logInfo(r.Context(), "Caption task approved", slog.String("task_id", taskID))
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(task)
+	writeJSON(w, r, http.StatusOK, task)
 }
 
 func rejectCaptionTaskHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "PUT" {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
 	taskID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/caption-tasks/"), "/reject")
 	if taskID == "" {
-		http.Error(w, "Caption task ID is required", http.StatusBadRequest)
+		writeError(w, r, "Caption task ID is required", http.StatusBadRequest)
 		return
 	}
 
 	// Get existing task
 	task, err := getCaptionTask(taskID)
 	if err != nil {
-		http.Error(w, "Failed to get caption task", http.StatusInternalServerError)
+		writeError(w, r, "Failed to get caption task", http.StatusInternalServerError)
 		logError(r.Context(), "Failed to get caption task for rejection", err, slog.String("task_id", taskID))
 		return
 	}
 	if task == nil {
-		http.Error(w, "Caption task not found", http.StatusNotFound)
+		writeError(w, r, "Caption task not found", http.StatusNotFound)
 		return
 	}
 
 	// Reset to pending status and clear caption
+	before := *task
 	task.Status = "pending"
 	task.Caption = sql.NullString{Valid: false}
-	if err := updateCaptionTask(task); err != nil {
-		http.Error(w, "Failed to reject caption", http.StatusInternalServerError)
+	if err := updateCaptionTask(task, "manual"); err != nil {
+		writeError(w, r, "Failed to reject caption", http.StatusInternalServerError)
 		logError(r.Context(), "Failed to reject caption task", err, slog.String("task_id", taskID))
 		return
 	}
+	recordAudit(r.Context(), task.ProjectID, "update", "caption_task", taskID, &before, task)
+	invalidateProjectStats(task.ProjectID)
 
 	logInfo(r.Context(), "Caption task rejected", slog.String("task_id", taskID))
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(task)
+	writeJSON(w, r, http.StatusOK, task)
+}
+
+func getCaptionHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	taskID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/caption-tasks/"), "/history")
+	if taskID == "" {
+		writeError(w, r, "Caption task ID is required", http.StatusBadRequest)
+		return
+	}
+
+	task, err := getCaptionTask(taskID)
+	if err != nil {
+		writeError(w, r, "Failed to get caption task", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to get caption task for history", err, slog.String("task_id", taskID))
+		return
+	}
+	if task == nil {
+		writeError(w, r, "Caption task not found", http.StatusNotFound)
+		return
+	}
+
+	history, err := getCaptionHistory(taskID)
+	if err != nil {
+		writeError(w, r, "Failed to get caption history", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to get caption history", err, slog.String("task_id", taskID))
+		return
+	}
+	if history == nil {
+		history = []CaptionHistoryEntry{}
+	}
+
+	writeJSON(w, r, http.StatusOK, history)
+}
+
+type RevertCaptionRequest struct {
+	HistoryID int64 `json:"historyId"`
+}
+
+// revertCaptionTaskHandler restores a caption from the task's history. The
+// restored value is itself recorded, so a revert can be undone.
+func revertCaptionTaskHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	taskID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/caption-tasks/"), "/revert")
+	if taskID == "" {
+		writeError(w, r, "Caption task ID is required", http.StatusBadRequest)
+		return
+	}
+
+	var req RevertCaptionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.HistoryID <= 0 {
+		writeError(w, r, "historyId is required", http.StatusBadRequest)
+		return
+	}
+
+	task, err := getCaptionTask(taskID)
+	if err != nil {
+		writeError(w, r, "Failed to get caption task", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to get caption task for revert", err, slog.String("task_id", taskID))
+		return
+	}
+	if task == nil {
+		writeError(w, r, "Caption task not found", http.StatusNotFound)
+		return
+	}
+
+	entry, err := getCaptionHistoryEntry(req.HistoryID)
+	if err != nil {
+		writeError(w, r, "Failed to get caption history", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to get caption history entry", err, slog.String("task_id", taskID))
+		return
+	}
+	if entry == nil || entry.CaptionTaskID != taskID {
+		writeError(w, r, "History entry not found", http.StatusNotFound)
+		return
+	}
+
+	before := *task
+	task.Caption = sql.NullString{String: entry.Caption, Valid: true}
+	if err := updateCaptionTask(task, "revert"); err != nil {
+		writeError(w, r, "Failed to revert caption", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to revert caption task", err, slog.String("task_id", taskID))
+		return
+	}
+	recordAudit(r.Context(), task.ProjectID, "update", "caption_task", taskID, &before, task)
+	invalidateProjectStats(task.ProjectID)
+
+	logInfo(r.Context(), "Caption task reverted",
+		slog.String("task_id", taskID),
+		slog.Int64("history_id", req.HistoryID))
+
+	writeJSON(w, r, http.StatusOK, task)
+}
+
+type GenerateCaptionCandidatesRequest struct {
+	N            int       `json:"n"`            // Number of candidates, spread over the default temperatures
+	Temperatures []float64 `json:"temperatures"` // Explicit temperatures, one candidate each; overrides n
+}
+
+const maxCaptionCandidates = 8
+
+// captionCandidatesHandler lists a caption task's candidates (GET) or
+// generates a new set, replacing the old one (POST)
+func captionCandidatesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	taskID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/caption-tasks/"), "/candidates")
+	if taskID == "" {
+		writeError(w, r, "Caption task ID is required", http.StatusBadRequest)
+		return
+	}
+
+	var temperatures []float64
+	if r.Method == http.MethodPost {
+		var req GenerateCaptionCandidatesRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			writeError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+		temperatures = req.Temperatures
+		if len(temperatures) == 0 {
+			if req.N == 0 {
+				req.N = 3
+			}
+			if req.N < 1 || req.N > maxCaptionCandidates {
+				writeError(w, r, fmt.Sprintf("n must be between 1 and %d", maxCaptionCandidates), http.StatusBadRequest)
+				return
+			}
+			temperatures = captionCandidateTemperatures(req.N)
+		}
+		if len(temperatures) > maxCaptionCandidates {
+			writeError(w, r, fmt.Sprintf("At most %d candidates can be generated at once", maxCaptionCandidates), http.StatusBadRequest)
+			return
+		}
+		for _, temperature := range temperatures {
+			if temperature < 0 || temperature > 2 {
+				writeError(w, r, "temperatures must be between 0 and 2", http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	task, err := getCaptionTask(taskID)
+	if err != nil {
+		writeError(w, r, "Failed to get caption task", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to get caption task for candidates", err, slog.String("task_id", taskID))
+		return
+	}
+	if task == nil {
+		writeError(w, r, "Caption task not found", http.StatusNotFound)
+		return
+	}
+
+	var candidates []CaptionCandidate
+	if r.Method == http.MethodPost {
+		candidates, err = GenerateCaptionCandidatesForTask(task, temperatures)
+		if err != nil {
+			writeError(w, r, "Failed to generate caption candidates", http.StatusInternalServerError)
+			logError(r.Context(), "Failed to generate caption candidates", err, slog.String("task_id", taskID))
+			return
+		}
+		logInfo(r.Context(), "Caption candidates generated",
+			slog.String("task_id", taskID),
+			slog.Int("count", len(candidates)))
+	} else {
+		candidates, err = getCaptionCandidates(taskID)
+		if err != nil {
+			writeError(w, r, "Failed to get caption candidates", http.StatusInternalServerError)
+			logError(r.Context(), "Failed to get caption candidates", err, slog.String("task_id", taskID))
+			return
+		}
+	}
+	if candidates == nil {
+		candidates = []CaptionCandidate{}
+	}
+
+	writeJSON(w, r, http.StatusOK, candidates)
+}
+
+type SelectCaptionCandidateRequest struct {
+	CandidateID int64 `json:"candidateId"`
+}
+
+// selectCaptionCandidateHandler copies the chosen candidate into the task's
+// caption and marks it reviewed
+func selectCaptionCandidateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	taskID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/caption-tasks/"), "/select-candidate")
+	if taskID == "" {
+		writeError(w, r, "Caption task ID is required", http.StatusBadRequest)
+		return
+	}
+
+	var req SelectCaptionCandidateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.CandidateID <= 0 {
+		writeError(w, r, "candidateId is required", http.StatusBadRequest)
+		return
+	}
+
+	task, err := getCaptionTask(taskID)
+	if err != nil {
+		writeError(w, r, "Failed to get caption task", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to get caption task for candidate selection", err, slog.String("task_id", taskID))
+		return
+	}
+	if task == nil {
+		writeError(w, r, "Caption task not found", http.StatusNotFound)
+		return
+	}
+
+	candidate, err := getCaptionCandidate(req.CandidateID)
+	if err != nil {
+		writeError(w, r, "Failed to get caption candidate", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to get caption candidate", err, slog.String("task_id", taskID))
+		return
+	}
+	if candidate == nil || candidate.CaptionTaskID != taskID {
+		writeError(w, r, "Candidate not found", http.StatusNotFound)
+		return
+	}
+
+	before := *task
+	task.Caption = sql.NullString{String: candidate.Caption, Valid: true}
+	task.Status = "reviewed"
+	if err := updateCaptionTask(task, "candidate"); err != nil {
+		writeError(w, r, "Failed to update caption task", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to apply caption candidate", err, slog.String("task_id", taskID))
+		return
+	}
+	recordAudit(r.Context(), task.ProjectID, "update", "caption_task", taskID, &before, task)
+	invalidateProjectStats(task.ProjectID)
+
+	logInfo(r.Context(), "Caption candidate selected",
+		slog.String("task_id", taskID),
+		slog.Int64("candidate_id", req.CandidateID))
+
+	writeJSON(w, r, http.StatusOK, task)
 }
 
 func corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*") // Allow all origins for now
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Idempotency-Key, X-Response-Envelope")
+		w.Header().Set("Access-Control-Expose-Headers", "X-Total-Count, X-Next-Cursor, X-Request-ID, X-Auto-Caption-Sessions, X-Auto-Caption-Session-Limit")
 
 		if r.Method == "OPTIONS" {
 			w.WriteHeader(http.StatusOK)
@@ -2773,15 +4804,33 @@ func main() {
 		os.Exit(1)
 	}
 
+	logger.Info("Effective configuration", "config", effectiveConfig())
+
 	// Initialize database
 	if err := initDatabase(); err != nil {
 		logger.Error("Failed to initialize database", "error", err)
 		os.Exit(1)
 	}
+
+	// Rollback runs are one-shot; start the matching older build afterwards
+	if appConfig.MigrateDownTo > 0 {
+		logger.Info("Schema rollback finished, exiting", "target_version", appConfig.MigrateDownTo)
+		closeDatabase()
+		return
+	}
 	defer closeDatabase()
 
+	startExportSweeper(appConfig.ExportArtifactTTL)
+	startBulkDeleteJobSweeper(appConfig.BulkDeleteJobTTL)
+
 	mux := http.NewServeMux()
 	mux.HandleFunc("/ping", pingHandler)
+	mux.HandleFunc("/admin/schema-version", withAdminAuth(schemaVersionHandler))
+	mux.HandleFunc("/admin/jobs", withAdminAuth(listActiveJobsHandler))
+	mux.HandleFunc("/admin/db-stats", withAdminAuth(dbStatsHandler))
+	mux.HandleFunc("/admin/config", withAdminAuth(configHandler))
+	mux.HandleFunc("/admin/debug/stats", withAdminAuth(debugStatsHandler))
+	mux.HandleFunc("/admin/logs", withAdminAuth(logTailHandler))
 	mux.HandleFunc("/projects", func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		case http.MethodPost:
@@ -2789,10 +4838,26 @@ func main() {
 		case http.MethodGet:
 			listProjectsHandler(w, r)
 		default:
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
 		}
 	})
 	mux.HandleFunc("/projects/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/projects/stats" {
+			listProjectsWithStatsHandler(w, r)
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/stats") && r.Method == http.MethodGet {
+			getProjectStatsHandler(w, r)
+			return
+		}
+		if r.URL.Path == "/projects/delete" {
+			bulkDeleteProjectsHandler(w, r)
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/projects/delete/") {
+			getBulkDeleteJobHandler(w, r)
+			return
+		}
 		if strings.HasSuffix(r.URL.Path, "/generate-tasks") && r.Method == http.MethodPost {
 			generateTasksHandler(w, r)
 			return
@@ -2801,6 +4866,10 @@ func main() {
 			getTasksHandler(w, r)
 			return
 		}
+		if strings.HasSuffix(r.URL.Path, "/tasks/skip-remaining") || strings.HasSuffix(r.URL.Path, "/tasks/unskip") {
+			skipRemainingTasksHandler(w, r)
+			return
+		}
 		if strings.HasSuffix(r.URL.Path, "/caption-tasks") && r.Method == http.MethodGet {
 			getCaptionTasksHandler(w, r)
 			return
@@ -2809,6 +4878,20 @@ func main() {
 			forkProjectHandler(w, r)
 			return
 		}
+		if strings.HasSuffix(r.URL.Path, "/audit") && r.Method == http.MethodGet {
+			getAuditLogHandler(w, r)
+			return
+		}
+		// Must precede the image file route, which would treat "sample" and
+		// "missing-files" as filenames
+		if strings.HasSuffix(r.URL.Path, "/images/sample") && r.Method == http.MethodGet {
+			sampleImagesHandler(w, r)
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/images/missing-files") && r.Method == http.MethodGet {
+			missingFilesHandler(w, r)
+			return
+		}
 		if strings.Contains(r.URL.Path, "/images/") {
 			if r.Method == http.MethodGet {
 				serveImageHandler(w, r)
@@ -2818,16 +4901,68 @@ func main() {
 				return
 			}
 		}
-		if strings.HasSuffix(r.URL.Path, "/export/jsonl") && r.Method == http.MethodGet {
-			exportJSONLHandler(w, r)
+		if strings.HasSuffix(r.URL.Path, "/progress-snapshot") && r.Method == http.MethodGet {
+			progressSnapshotHandler(w, r)
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/distances") && r.Method == http.MethodPost {
+			distancesHandler(w, r)
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/validate") && r.Method == http.MethodGet {
+			validateProjectHandler(w, r)
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/duplicates") && r.Method == http.MethodGet {
+			duplicatesHandler(w, r)
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/rehash") {
+			rehashProjectHandler(w, r)
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/next-task") && r.Method == http.MethodGet {
+			getNextTaskHandler(w, r)
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/export-token") {
+			exportTokensHandler(w, r)
+			return
+		}
+		if strings.Contains(r.URL.Path, "/export-token/") {
+			revokeExportTokenHandler(w, r)
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/prompt-buttons/export") && r.Method == http.MethodGet {
+			exportPromptButtonsHandler(w, r)
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/prompt-buttons/import") && r.Method == http.MethodPost {
+			importPromptButtonsHandler(w, r)
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/import/captions") && r.Method == http.MethodPost {
+			importCaptionsHandler(w, r)
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/exports") && r.Method == http.MethodGet {
+			exportManifestHandler(w, r)
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/export") && r.Method == http.MethodGet {
+			exportHandler(w, r)
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/export/jsonl") && (r.Method == http.MethodGet || r.Method == http.MethodPost) {
+			withExportToken(exportJSONLHandler)(w, r)
 			return
 		}
 		if strings.HasSuffix(r.URL.Path, "/export/ai-toolkit") && r.Method == http.MethodGet {
-			exportAIToolkitHandler(w, r)
+			withExportToken(exportAIToolkitHandler)(w, r)
 			return
 		}
 		if strings.HasSuffix(r.URL.Path, "/export/image-text-pairs") && r.Method == http.MethodGet {
-			exportImageTextPairsHandler(w, r)
+			withExportToken(exportImageTextPairsHandler)(w, r)
 			return
 		}
 		if strings.HasSuffix(r.URL.Path, "/auto-caption-batch") && r.Method == http.MethodPost {
@@ -2838,16 +4973,20 @@ func main() {
 			cancelAutoCaptioningHandler(w, r)
 			return
 		}
+		if (strings.HasSuffix(r.URL.Path, "/auto-caption-pause") || strings.HasSuffix(r.URL.Path, "/auto-caption-resume")) && r.Method == http.MethodPost {
+			pauseAutoCaptioningHandler(w, r)
+			return
+		}
 		if strings.HasSuffix(r.URL.Path, "/auto-caption-status") && r.Method == http.MethodGet {
 			getAutoCaptionStatusHandler(w, r)
 			return
 		}
 		if strings.HasSuffix(r.URL.Path, "/export-status") && r.Method == http.MethodGet {
-			getExportStatusHandler(w, r)
+			withExportToken(getExportStatusHandler)(w, r)
 			return
 		}
 		if strings.HasSuffix(r.URL.Path, "/export/download") && r.Method == http.MethodGet {
-			downloadExportHandler(w, r)
+			withExportToken(downloadExportHandler)(w, r)
 			return
 		}
 		switch r.Method {
@@ -2858,20 +4997,69 @@ func main() {
 		case http.MethodDelete:
 			deleteProjectHandler(w, r)
 		default:
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
 		}
 	})
 	mux.HandleFunc("/upload", uploadHandler)
+	mux.HandleFunc("/upload/url", uploadURLHandler)
 	mux.HandleFunc("/progress", progressHandler)
+	mux.HandleFunc("/progress/snapshot", uploadProgressSnapshotHandler)
 	mux.HandleFunc("/images", getImagesHandler)
+	mux.HandleFunc("/images/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPatch && !strings.Contains(strings.TrimPrefix(r.URL.Path, "/images/"), "/") {
+			updateImageHandler(w, r)
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/metadata") {
+			imageMetadataHandler(w, r)
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/move") && r.Method == http.MethodPost {
+			moveImageHandler(w, r)
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/rotate") && r.Method == http.MethodPost {
+			rotateImageHandler(w, r)
+			return
+		}
+		http.NotFound(w, r)
+	})
 	mux.HandleFunc("/tasks/", func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/candidates") && r.Method == http.MethodGet {
+			getTaskCandidatesHandler(w, r)
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/select-candidate") {
+			selectCandidateHandler(w, r)
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/generate-prompt") {
+			generateTaskPromptHandler(w, r)
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/claim") {
+			claimTaskHandler(w, r)
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/release") {
+			releaseTaskHandler(w, r)
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/diff") {
+			getTaskDiffHandler(w, r)
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/upload-b") {
+			uploadImageBHandler(w, r)
+			return
+		}
 		switch r.Method {
 		case http.MethodGet:
 			getTaskHandler(w, r)
-		case http.MethodPut:
+		case http.MethodPut, http.MethodPatch:
 			updateTaskHandler(w, r)
 		default:
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
 		}
 	})
 	mux.HandleFunc("/caption-tasks/", func(w http.ResponseWriter, r *http.Request) {
@@ -2887,20 +5075,37 @@ func main() {
 			rejectCaptionTaskHandler(w, r)
 			return
 		}
+		if strings.HasSuffix(r.URL.Path, "/history") {
+			getCaptionHistoryHandler(w, r)
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/revert") {
+			revertCaptionTaskHandler(w, r)
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/candidates") {
+			captionCandidatesHandler(w, r)
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/select-candidate") {
+			selectCaptionCandidateHandler(w, r)
+			return
+		}
 		switch r.Method {
 		case http.MethodGet:
 			getCaptionTaskHandler(w, r)
 		case http.MethodPut:
 			updateCaptionTaskHandler(w, r)
 		default:
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
 		}
 	})
 	mux.HandleFunc("/auto-caption-progress", autoCaptionProgressHandler)
 	mux.HandleFunc("/export-progress", exportProgressHandler)
+	mux.HandleFunc("/delete-progress", bulkDeleteProgressHandler)
 
-	logger.Info("Server starting", "port", 8080)
-	if err := http.ListenAndServe(":8080", loggingMiddleware(corsMiddleware(mux))); err != nil {
+	logger.Info("Server starting", "port", 8080, "read_only", appConfig.ReadOnly)
+	if err := http.ListenAndServe(":8080", loggingMiddleware(corsMiddleware(readOnlyMiddleware(mux)))); err != nil {
 		logger.Error("Server failed", "error", err)
 		os.Exit(1)
 	}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestMain(m *testing.M) {
+	logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	os.Exit(m.Run())
+}
+
+// setupTestDB points the package at a fresh, migrated in-memory database and
+// runs the test from an empty directory, so files written under data/ are
+// thrown away with it
+func setupTestDB(t testing.TB) {
+	t.Helper()
+	t.Chdir(t.TempDir())
+
+	name := strings.NewReplacer("/", "_", " ", "_").Replace(t.Name())
+	testDB, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?mode=memory&cache=shared&_foreign_keys=on", name))
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	previous := db
+	db = testDB
+	t.Cleanup(func() {
+		testDB.Close()
+		db = previous
+	})
+
+	if err := runMigrations(); err != nil {
+		t.Fatalf("run migrations: %v", err)
+	}
+}
+
+func createTestProject(t testing.TB) *Project {
+	t.Helper()
+	project := &Project{ID: uuid.New().String(), Name: "test", Version: "1", ProjectType: "edit", PromptButtons: []string{}}
+	if err := createProject(project); err != nil {
+		t.Fatalf("create project: %v", err)
+	}
+	return project
+}
+
+func createTestImage(t testing.TB, projectID, path, pHash string) *Image {
+	t.Helper()
+	image := &Image{ID: uuid.New().String(), ProjectID: projectID, Path: path, PHash: pHash}
+	if err := createImage(image); err != nil {
+		t.Fatalf("create image: %v", err)
+	}
+	return image
+}
+
+func createTestTask(t testing.TB, task Task) *Task {
+	t.Helper()
+	if task.ID == "" {
+		task.ID = uuid.New().String()
+	}
+	if err := createTask(&task); err != nil {
+		t.Fatalf("create task: %v", err)
+	}
+	created, err := getTask(task.ID)
+	if err != nil || created == nil {
+		t.Fatalf("get task: %v", err)
+	}
+	return created
+}
+
+// serve runs handler on a request with body and returns the recorder
+func serve(handler http.HandlerFunc, method, target, body string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, target, strings.NewReader(body))
+	recorder := httptest.NewRecorder()
+	handler(recorder, request)
+	return recorder
+}
+
+func decodeResponse(t *testing.T, recorder *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if err := json.NewDecoder(bytes.NewReader(recorder.Body.Bytes())).Decode(v); err != nil {
+		t.Fatalf("decode response %q: %v", recorder.Body.String(), err)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// MissingImageFile is an image row whose file can't be found on disk
+type MissingImageFile struct {
+	ImageID string `json:"imageId"`
+	Path    string `json:"path"`
+	Error   string `json:"error,omitempty"` // Set when the file exists but couldn't be checked
+}
+
+type MissingFilesResponse struct {
+	ProjectID string             `json:"projectId"`
+	Checked   int                `json:"checked"`
+	Missing   []MissingImageFile `json:"missing"`
+}
+
+// findMissingImageFiles stats the file behind each image and returns those
+// that are absent or can't be checked
+func findMissingImageFiles(images []Image) []MissingImageFile {
+	missing := []MissingImageFile{}
+	for i := range images {
+		_, err := os.Stat(imageFilePath(&images[i]))
+		if err == nil {
+			continue
+		}
+		entry := MissingImageFile{ImageID: images[i].ID, Path: images[i].Path}
+		if !os.IsNotExist(err) {
+			entry.Error = err.Error()
+		}
+		missing = append(missing, entry)
+	}
+	return missing
+}
+
+// missingFilesHandler handles GET /projects/{id}/images/missing-files. Rows it
+// reports can be removed with DELETE /projects/{id}/images/{imageId} or
+// restored by uploading the file again.
+func missingFilesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	projectID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/projects/"), "/images/missing-files")
+	if projectID == "" {
+		writeError(w, r, "Project ID is required", http.StatusBadRequest)
+		return
+	}
+
+	project, err := getProject(projectID)
+	if err != nil {
+		writeError(w, r, "Failed to get project", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to get project for missing file check", err, slog.String("project_id", projectID))
+		return
+	}
+	if project == nil {
+		writeError(w, r, "Project not found", http.StatusNotFound)
+		return
+	}
+
+	images, err := getImagesByProjectID(projectID)
+	if err != nil {
+		writeError(w, r, "Failed to get images", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to get images for missing file check", err, slog.String("project_id", projectID))
+		return
+	}
+
+	missing := findMissingImageFiles(images)
+	if len(missing) > 0 {
+		logInfo(r.Context(), "Image files missing",
+			slog.String("project_id", projectID),
+			slog.Int("checked", len(images)),
+			slog.Int("missing", len(missing)))
+	}
+
+	writeJSON(w, r, http.StatusOK, MissingFilesResponse{
+		ProjectID: projectID,
+		Checked:   len(images),
+		Missing:   missing,
+	})
+}
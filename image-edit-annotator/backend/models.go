@@ -2,6 +2,7 @@ package main
 
 import (
 	"database/sql"
+	"encoding/json"
 	"time"
 )
 
@@ -15,6 +16,21 @@ type Project struct {
 	CaptionAPI         *string   `json:"captionApi" db:"caption_api"`   // JSON configuration for caption API
 	SystemPrompt       *string   `json:"systemPrompt" db:"system_prompt"` // Custom system prompt for captioning
 	AutoCaptionConfig  *string   `json:"autoCaptionConfig" db:"auto_caption_config"` // JSON configuration for auto captioning
+	CaptionLanguage    *string   `json:"captionLanguage" db:"caption_language"` // Language captions should be written in, e.g. "French"
+	DefaultSimilarityThreshold *int `json:"defaultSimilarityThreshold" db:"default_similarity_threshold"` // Used when generate-tasks omits similarityThreshold
+	DefaultMaxCandidates       *int `json:"defaultMaxCandidates" db:"default_max_candidates"`             // Used when generate-tasks omits maxCandidates
+	PreservePromptWhitespace   bool `json:"preservePromptWhitespace" db:"preserve_prompt_whitespace"` // Keep internal whitespace runs in prompts instead of collapsing them
+	MinWidth                   *int     `json:"minWidth" db:"min_width"`              // Uploads narrower than this are rejected
+	MinHeight                  *int     `json:"minHeight" db:"min_height"`            // Uploads shorter than this are rejected
+	MinAspectRatio             *float64 `json:"minAspectRatio" db:"min_aspect_ratio"` // Lowest accepted width/height ratio
+	MaxAspectRatio             *float64 `json:"maxAspectRatio" db:"max_aspect_ratio"` // Highest accepted width/height ratio
+	EditPromptSystemPrompt     *string  `json:"editPromptSystemPrompt" db:"edit_prompt_system_prompt"` // System prompt for generating edit instructions from image pairs
+	MaxDimension               *int     `json:"maxDimension" db:"max_dimension"`        // Uploads with a longer edge are downscaled to it; nil keeps full resolution
+	CaptionTemplate            *string  `json:"captionTemplate" db:"caption_template"`  // Wraps generated captions, e.g. "{trigger}, {caption}"
+	TriggerWord                *string  `json:"triggerWord" db:"trigger_word"`          // Value of {trigger} in the caption template
+	KeepOriginal               bool     `json:"keepOriginal" db:"keep_original"`        // Archive uploads under originals/ before they are downscaled
+	AutoGenerateTasks          bool     `json:"autoGenerateTasks" db:"auto_generate_tasks"` // Create tasks for newly uploaded images once each upload completes
+	CaptionStripPatterns       []string `json:"captionStripPatterns" db:"caption_strip_patterns"` // Regexes whose matches are removed from generated captions before the template is applied
 	CreatedAt          time.Time `json:"createdAt" db:"created_at"`
 	UpdatedAt          time.Time `json:"updatedAt" db:"updated_at"`
 }
@@ -24,19 +40,48 @@ type Image struct {
 	ProjectID string    `json:"projectId" db:"project_id"`
 	Path      string    `json:"path" db:"path"`
 	PHash     string    `json:"pHash" db:"phash"`
+	Animated  bool      `json:"animated" db:"animated"` // Hash and previews use the first frame
+	BlobHash  string    `json:"blobHash,omitempty" db:"blob_hash"` // SHA-256 of the shared blob holding the file, empty if stored under the project
+	ContentHash string  `json:"contentHash,omitempty" db:"content_hash"` // SHA-256 of the stored file, empty for images stored before it was recorded
+	DHash       string  `json:"dHash,omitempty" db:"dhash"` // Difference hash, combined with the pHash when generation weights it; empty until rehashed for older images
+	OriginalWidth  *int `json:"originalWidth" db:"original_width"`   // Size as uploaded, before any downscaling; nil for images stored before it was recorded
+	OriginalHeight *int `json:"originalHeight" db:"original_height"`
+	OriginalPath   *string `json:"originalPath" db:"original_path"` // Project-relative path of the archived full-size upload, nil unless it was downscaled with keepOriginal set
+	Notes     *string   `json:"notes" db:"notes"` // Annotator's free-text note on the image, nil when there is none
+	Excluded  bool      `json:"excluded" db:"excluded"` // Never paired by task generation nor exported, e.g. logos and placeholders
+	Metadata  map[string]string `json:"metadata,omitempty"` // Provenance such as source URL or license; kept in image_metadata and loaded by GET /images and the metadata endpoints
 	CreatedAt time.Time `json:"createdAt" db:"created_at"`
 }
 
 type Task struct {
-	ID            string         `json:"id" db:"id"`
-	ProjectID     string         `json:"projectId" db:"project_id"`
-	ImageAID      string         `json:"imageAId" db:"image_a_id"`
-	ImageBId      sql.NullString `json:"imageBId" db:"image_b_id"`
-	Prompt        sql.NullString `json:"prompt" db:"prompt"`
-	Skipped       bool           `json:"skipped" db:"skipped"`
-	CandidateBIds []string       `json:"candidateBIds"`
-	CreatedAt     time.Time      `json:"createdAt" db:"created_at"`
-	UpdatedAt     time.Time      `json:"updatedAt" db:"updated_at"`
+	ID             string          `json:"id" db:"id"`
+	ProjectID      string          `json:"projectId" db:"project_id"`
+	ImageAID       string          `json:"imageAId" db:"image_a_id"`
+	ImageBId       sql.NullString  `json:"imageBId" db:"image_b_id"`
+	Prompt         sql.NullString  `json:"prompt" db:"prompt"`
+	NegativePrompt sql.NullString  `json:"negativePrompt" db:"negative_prompt"` // Optional; exported alongside the prompt when set
+	Skipped        bool            `json:"skipped" db:"skipped"`
+	CandidateBIds  []string        `json:"candidateBIds"`
+	Candidates     []TaskCandidate `json:"candidates"`                // Same images as CandidateBIds, with distances, closest first
+	Region         *TaskRegion     `json:"region" db:"region"`        // Area of image A to edit, nil for the whole image
+	ClaimedBy      *string         `json:"claimedBy" db:"claimed_by"` // Annotator working on the task; the claim lapses after TASK_CLAIM_TTL_SECONDS
+	ClaimedAt      *time.Time      `json:"claimedAt" db:"claimed_at"`
+	CreatedAt      time.Time       `json:"createdAt" db:"created_at"`
+	UpdatedAt      time.Time       `json:"updatedAt" db:"updated_at"`
+}
+
+// TaskCandidate is a candidate B image with the perceptual hash distance that selected it
+type TaskCandidate struct {
+	ImageID  string `json:"imageId" db:"image_id"`
+	Distance *int   `json:"distance" db:"distance"` // nil for candidates stored before distances were recorded
+}
+
+// TaskRegion is a bounding box in normalized coordinates (0-1) relative to image A
+type TaskRegion struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+	W float64 `json:"w"`
+	H float64 `json:"h"`
 }
 
 type CaptionTask struct {
@@ -50,11 +95,46 @@ type CaptionTask struct {
 	UpdatedAt   time.Time      `json:"updatedAt" db:"updated_at"`
 }
 
+// CaptionHistoryEntry is one recorded value of a caption task's caption
+type CaptionHistoryEntry struct {
+	ID            int64     `json:"id" db:"id"`
+	CaptionTaskID string    `json:"captionTaskId" db:"caption_task_id"`
+	Caption       string    `json:"caption" db:"caption"`
+	Source        string    `json:"source" db:"source"` // "auto_generated", "manual", "revert", "import" or "candidate"
+	CreatedAt     time.Time `json:"createdAt" db:"created_at"`
+}
+
+// CaptionCandidate is one of several generated captions offered for review
+type CaptionCandidate struct {
+	ID            int64     `json:"id" db:"id"`
+	CaptionTaskID string    `json:"captionTaskId" db:"caption_task_id"`
+	Caption       string    `json:"caption" db:"caption"`
+	Temperature   *float64  `json:"temperature" db:"temperature"` // Sampling temperature it was generated at, nil for the provider default
+	CreatedAt     time.Time `json:"createdAt" db:"created_at"`
+}
+
+type AuditLogEntry struct {
+	ID         int64           `json:"id" db:"id"`
+	ProjectID  string          `json:"projectId" db:"project_id"`
+	Action     string          `json:"action" db:"action"`           // "create", "update", "delete"
+	EntityType string          `json:"entityType" db:"entity_type"`  // "project", "task", "caption_task"
+	EntityID   string          `json:"entityId" db:"entity_id"`
+	RequestID  string          `json:"requestId" db:"request_id"`
+	Diff       json.RawMessage `json:"diff" db:"diff"`               // Changed fields as {"field": {"old": ..., "new": ...}}
+	CreatedAt  time.Time       `json:"createdAt" db:"created_at"`
+}
+
+
 type CaptionAPIConfig struct {
-	Provider string `json:"provider"` // "gemini", "openai", etc.
-	APIKey   string `json:"apiKey"`
-	Endpoint string `json:"endpoint,omitempty"`
-	Model    string `json:"model,omitempty"`
+	Provider         string            `json:"provider"` // "gemini", "openai", etc.
+	APIKey           string            `json:"apiKey"`
+	Endpoint         string            `json:"endpoint,omitempty"`
+	Model            string            `json:"model,omitempty"`
+	Temperature      *float64          `json:"temperature,omitempty"`      // Sampling temperature, provider default when unset
+	TopP             *float64          `json:"topP,omitempty"`             // Nucleus sampling cutoff, provider default when unset
+	MaxTokens        *int              `json:"maxTokens,omitempty"`        // Output token cap, provider default when unset
+	RequestTimeoutMs *int              `json:"requestTimeoutMs,omitempty"` // Deadline for each provider call, 60 seconds when unset
+	Headers          map[string]string `json:"headers,omitempty"`          // Extra headers sent with every provider call, e.g. for a gateway or proxy
 }
 
 type CaptionRequest struct {
@@ -68,15 +148,16 @@ type CaptionResponse struct {
 }
 
 type AutoCaptionConfig struct {
-	RPM              int    `json:"rpm"`              // Requests per minute
-	MaxRetries       int    `json:"maxRetries"`       // Maximum retry attempts
-	RetryDelayMs     int    `json:"retryDelayMs"`     // Base retry delay in milliseconds
-	ConcurrentTasks  int    `json:"concurrentTasks"`  // Number of concurrent processing tasks
+	RPM                    int `json:"rpm"`                    // Requests per minute
+	MaxRetries             int `json:"maxRetries"`             // Maximum retry attempts
+	RetryDelayMs           int `json:"retryDelayMs"`           // Base retry delay in milliseconds
+	ConcurrentTasks        int `json:"concurrentTasks"`        // Number of concurrent processing tasks
+	MaxConsecutiveFailures int `json:"maxConsecutiveFailures"` // Abort the run after this many failed tasks in a row; 0 uses the default of 5
 }
 
 type AutoCaptionProgress struct {
 	ProjectID    string `json:"projectId"`
-	Status       string `json:"status"`       // "running", "completed", "cancelled", "error"
+	Status       string `json:"status"`       // "running", "paused", "completed", "cancelled", "error"
 	Total        int    `json:"total"`
 	Processed    int    `json:"processed"`
 	Successful   int    `json:"successful"`
@@ -85,6 +166,10 @@ type AutoCaptionProgress struct {
 	ErrorMessage string `json:"errorMessage,omitempty"`
 	StartedAt    string `json:"startedAt,omitempty"`
 	CompletedAt  string `json:"completedAt,omitempty"`
+	AverageMsPerTask      int64   `json:"averageMsPerTask,omitempty"`      // Mean time spent captioning a task, including retries
+	EstimatedCompletionAt string  `json:"estimatedCompletionAt,omitempty"` // Projected from the observed pace, including rate limiting
+	CurrentRPM            float64 `json:"currentRPM,omitempty"`            // Tasks finished over the last minute
+	PausedAt              string  `json:"pausedAt,omitempty"`              // Set while the session is paused
 }
 
 type AutoCaptionRequest struct {
@@ -92,6 +177,42 @@ type AutoCaptionRequest struct {
 }
 
 type AutoCaptionStatusResponse struct {
-	Progress *AutoCaptionProgress `json:"progress"`
-	IsActive bool                 `json:"isActive"`
-}
\ No newline at end of file
+	Progress       *AutoCaptionProgress `json:"progress"`
+	IsActive       bool                 `json:"isActive"`
+	ActiveSessions int                  `json:"activeSessions"` // Sessions active across all projects
+	MaxSessions    int                  `json:"maxSessions"`    // Server-wide limit on active sessions
+}
+
+// IdempotencyRecord stores the response of a create request so a retry with the
+// same Idempotency-Key replays it instead of creating a duplicate
+type IdempotencyRecord struct {
+	Key         string          `db:"key"`
+	Scope       string          `db:"scope"`        // Endpoint (and parent entity) the key applies to
+	RequestHash string          `db:"request_hash"` // SHA-256 of the request body; empty for records made before it was stored
+	EntityID    string          `db:"entity_id"`
+	StatusCode  int             `db:"status_code"`
+	Response    json.RawMessage `db:"response"`
+}
+
+// ExportToken grants access to one project's export endpoints until it expires
+// or is revoked. Only the ID is stored; the token string itself is signed.
+type ExportToken struct {
+	ID        string     `json:"id" db:"id"`
+	ProjectID string     `json:"projectId" db:"project_id"`
+	ExpiresAt time.Time  `json:"expiresAt" db:"expires_at"`
+	RevokedAt *time.Time `json:"revokedAt" db:"revoked_at"`
+	CreatedAt time.Time  `json:"createdAt" db:"created_at"`
+}
+
+// SchemaMigration is a row of the schema_version table
+type SchemaMigration struct {
+	Version   int       `json:"version" db:"version"`
+	AppliedAt time.Time `json:"appliedAt" db:"applied_at"`
+}
+
+// ProjectStats summarises a project's progress for the project list
+type ProjectStats struct {
+	ImageCount         int `json:"imageCount"`
+	TaskCount          int `json:"taskCount"`
+	CompletedTaskCount int `json:"completedTaskCount"`
+}
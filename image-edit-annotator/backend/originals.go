@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Projects with keepOriginal set archive each upload that gets downscaled
+// under data/projects/{id}/originals, mirroring its path under images/. The
+// working image and its hashes still use the downscaled version; the original
+// is only read by exports asked for originals. Originals are plain files even
+// with content-addressed storage, since only the working images are shared.
+
+// storeOriginalFile writes an upload's untouched content under the project's
+// originals directory and returns its project-relative path
+func storeOriginalFile(projectID, filename string, content []byte) (string, error) {
+	originalPath := filepath.Join("originals", filename)
+	filePath := filepath.Join("data", "projects", projectID, originalPath)
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return "", fmt.Errorf("Error creating originals directory: %v", err)
+	}
+	if err := os.WriteFile(filePath, content, 0644); err != nil {
+		return "", fmt.Errorf("Error writing original file: %v", err)
+	}
+	return originalPath, nil
+}
+
+// originalFilePath returns where an image's archived original lives on disk,
+// or "" if none was kept
+func originalFilePath(image *Image) string {
+	if image.OriginalPath == nil {
+		return ""
+	}
+	return filepath.Join("data", "projects", image.ProjectID, *image.OriginalPath)
+}
+
+// exportImagePath returns the project-relative path an export should name for
+// image: its original if originals were asked for and one was kept
+func exportImagePath(image *Image, originals bool) string {
+	if originals && image.OriginalPath != nil {
+		return *image.OriginalPath
+	}
+	return image.Path
+}
+
+// exportImageFilePath returns the file an export should read for image,
+// following the same choice as exportImagePath
+func exportImageFilePath(image *Image, originals bool) string {
+	if originals && image.OriginalPath != nil {
+		return originalFilePath(image)
+	}
+	return imageFilePath(image)
+}
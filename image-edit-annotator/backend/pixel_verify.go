@@ -0,0 +1,130 @@
+package main
+
+import (
+	"container/list"
+	"fmt"
+	"image"
+	"sync"
+)
+
+const (
+	// defaultMaxPixelDifference is the largest pixelDifference a verified
+	// candidate may have when the request doesn't set one
+	defaultMaxPixelDifference = 0.1
+
+	// pixelVerifierCacheSize is how many thumbnails a verifier keeps, about
+	// 16 KB each. It covers a target's candidates several times over, so
+	// images near each other in a run are only decoded once.
+	pixelVerifierCacheSize = 256
+)
+
+// pixelVerifier is an optional second pass over pHash matches. It decodes
+// both images of each pair and drops candidates whose thumbnails differ by
+// more than maxDifference, catching hash collisions between unrelated
+// images. The most recently used thumbnails are cached, so one verifier
+// should be shared across a generation run.
+type pixelVerifier struct {
+	maxDifference float64
+
+	mu         sync.Mutex
+	thumbnails map[string]*list.Element // Image ID to its entry in recent
+	recent     *list.List               // cachedThumbnail entries, most recently used first
+}
+
+type cachedThumbnail struct {
+	imageID   string
+	thumbnail *image.RGBA
+}
+
+func newPixelVerifier(maxDifference float64) *pixelVerifier {
+	return &pixelVerifier{
+		maxDifference: maxDifference,
+		thumbnails:    make(map[string]*list.Element),
+		recent:        list.New(),
+	}
+}
+
+func (v *pixelVerifier) thumbnail(img *Image) (*image.RGBA, error) {
+	v.mu.Lock()
+	if element, ok := v.thumbnails[img.ID]; ok {
+		v.recent.MoveToFront(element)
+		v.mu.Unlock()
+		return element.Value.(*cachedThumbnail).thumbnail, nil
+	}
+	v.mu.Unlock()
+
+	thumbnail, err := loadThumbnail(img)
+	if err != nil {
+		return nil, err
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	// Another worker may have loaded it meanwhile
+	if element, ok := v.thumbnails[img.ID]; ok {
+		v.recent.MoveToFront(element)
+		return element.Value.(*cachedThumbnail).thumbnail, nil
+	}
+	v.thumbnails[img.ID] = v.recent.PushFront(&cachedThumbnail{imageID: img.ID, thumbnail: thumbnail})
+	if v.recent.Len() > pixelVerifierCacheSize {
+		oldest := v.recent.Back()
+		v.recent.Remove(oldest)
+		delete(v.thumbnails, oldest.Value.(*cachedThumbnail).imageID)
+	}
+	return thumbnail, nil
+}
+
+// filter returns the candidates whose pixels are within maxDifference of
+// target, recording each one's difference, along with the number dropped.
+// Candidates that can't be decoded are dropped too.
+func (v *pixelVerifier) filter(target Image, candidates []SimilarImage) ([]SimilarImage, int, error) {
+	if len(candidates) == 0 {
+		return candidates, 0, nil
+	}
+
+	targetThumbnail, err := v.thumbnail(&target)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to load target image: %v", err)
+	}
+
+	var verified []SimilarImage
+	rejected := 0
+	for _, candidate := range candidates {
+		thumbnail, err := v.thumbnail(&candidate.Image)
+		if err != nil {
+			logger.Warn("Failed to load candidate for pixel verification",
+				"error", err,
+				"image_id", candidate.Image.ID,
+			)
+			rejected++
+			continue
+		}
+		difference := pixelDifference(targetThumbnail, thumbnail)
+		if difference > v.maxDifference {
+			logger.Debug("Candidate rejected by pixel verification",
+				"image_id", target.ID,
+				"candidate_id", candidate.Image.ID,
+				"distance", candidate.Distance,
+				"pixel_difference", difference,
+			)
+			rejected++
+			continue
+		}
+		candidate.PixelDifference = &difference
+		verified = append(verified, candidate)
+	}
+	return verified, rejected, nil
+}
+
+// parseMaxPixelDifference validates a requested bound, defaulting to
+// defaultMaxPixelDifference when none was given. An explicit 0 keeps only
+// pixel-identical matches.
+func parseMaxPixelDifference(value *float64) (float64, error) {
+	if value == nil {
+		return defaultMaxPixelDifference, nil
+	}
+	if *value < 0 || *value > 1 {
+		return 0, fmt.Errorf("maxPixelDifference must be between 0 and 1")
+	}
+	return *value, nil
+}
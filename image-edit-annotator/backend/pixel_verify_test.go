@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"net/http"
+	"os"
+	"sort"
+	"testing"
+)
+
+func TestPixelVerifierCacheIsBounded(t *testing.T) {
+	setupTestDB(t)
+	project := createTestProject(t)
+	var images []*Image
+	for i := 0; i < pixelVerifierCacheSize+10; i++ {
+		record := &Image{ID: fmt.Sprintf("image-%03d", i), ProjectID: project.ID, Path: fmt.Sprintf("images/%03d.png", i)}
+		writeTestImageFile(t, record, i%3)
+		images = append(images, record)
+	}
+
+	verifier := newPixelVerifier(defaultMaxPixelDifference)
+	for i, record := range images {
+		if _, err := verifier.thumbnail(record); err != nil {
+			t.Fatal(err)
+		}
+		// Keep the first image in use so it outlives older entries
+		if i%50 == 0 {
+			if _, err := verifier.thumbnail(images[0]); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if len(verifier.thumbnails) != pixelVerifierCacheSize || verifier.recent.Len() != pixelVerifierCacheSize {
+		t.Fatalf("cache holds %d (list %d) thumbnails, want %d", len(verifier.thumbnails), verifier.recent.Len(), pixelVerifierCacheSize)
+	}
+	if _, ok := verifier.thumbnails[images[0].ID]; !ok {
+		t.Error("recently used thumbnail was evicted")
+	}
+	if _, ok := verifier.thumbnails[images[1].ID]; ok {
+		t.Error("least recently used thumbnail was kept")
+	}
+	if _, ok := verifier.thumbnails[images[len(images)-1].ID]; !ok {
+		t.Error("newest thumbnail is missing")
+	}
+}
+
+func TestTaskCandidatesHonourZeroMaxPixelDifference(t *testing.T) {
+	setupTestDB(t)
+	project := createTestProject(t)
+	imageA := createTestImage(t, project.ID, "images/a.png", "p:0000000000000000")
+	same := createTestImage(t, project.ID, "images/same.png", "p:0000000000000000")
+	nearby := createTestImage(t, project.ID, "images/close.png", "p:0000000000000000")
+	for _, record := range []*Image{imageA, same, nearby} {
+		writeTestImageFile(t, record, 0)
+	}
+
+	// nearby differs from image A by one small patch
+	path := imageFilePath(nearby)
+	file, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := png.Decode(bytes.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	patched := image.NewRGBA(decoded.Bounds())
+	draw.Draw(patched, patched.Bounds(), decoded, image.Point{}, draw.Src)
+	draw.Draw(patched, image.Rect(0, 0, 4, 4), image.NewUniform(color.Gray{Y: 128}), image.Point{}, draw.Src)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, patched); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	task := createTestTask(t, Task{ProjectID: project.ID, ImageAID: imageA.ID})
+	candidateIDs := func(query string) []string {
+		t.Helper()
+		rec := serve(getTaskCandidatesHandler, http.MethodGet, "/tasks/"+task.ID+"/candidates?verify=true"+query, "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", query, rec.Code, rec.Body)
+		}
+		var candidates []SimilarImage
+		decodeResponse(t, rec, &candidates)
+		var ids []string
+		for _, candidate := range candidates {
+			ids = append(ids, candidate.Image.ID)
+		}
+		sort.Strings(ids)
+		return ids
+	}
+
+	if ids := candidateIDs(""); len(ids) != 2 {
+		t.Errorf("default bound kept %v, want both same and nearby", ids)
+	}
+	if ids := candidateIDs("&maxPixelDifference=0"); len(ids) != 1 || ids[0] != same.ID {
+		t.Errorf("maxPixelDifference=0 kept %v, want only %s", ids, same.ID)
+	}
+}
+
+func TestParseMaxPixelDifference(t *testing.T) {
+	zero, half, over := 0.0, 0.5, 1.5
+	tests := []struct {
+		name    string
+		value   *float64
+		want    float64
+		wantErr bool
+	}{
+		{"omitted", nil, defaultMaxPixelDifference, false},
+		{"zero", &zero, 0, false},
+		{"in range", &half, 0.5, false},
+		{"too large", &over, 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseMaxPixelDifference(tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("%s: got %v, %v; want %v, error %v", tt.name, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
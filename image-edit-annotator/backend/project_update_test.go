@@ -0,0 +1,119 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// settingsTestProject returns a stored project with every optional setting set
+func settingsTestProject(t *testing.T) *Project {
+	t.Helper()
+	parent := createTestProject(t)
+	project := createTestProject(t)
+	intPtr := func(v int) *int { return &v }
+	floatPtr := func(v float64) *float64 { return &v }
+	stringPtr := func(v string) *string { return &v }
+
+	project.PromptButtons = []string{"add a hat"}
+	project.ParentProjectID = &parent.ID
+	project.CaptionAPI = stringPtr(`{"endpoint":"http://localhost:1234"}`)
+	project.SystemPrompt = stringPtr("Describe the image.")
+	project.AutoCaptionConfig = stringPtr(`{"rpm":30}`)
+	project.CaptionLanguage = stringPtr("French")
+	project.DefaultSimilarityThreshold = intPtr(12)
+	project.DefaultMaxCandidates = intPtr(7)
+	project.PreservePromptWhitespace = true
+	project.MinWidth = intPtr(256)
+	project.MinHeight = intPtr(128)
+	project.MinAspectRatio = floatPtr(0.5)
+	project.MaxAspectRatio = floatPtr(2)
+	project.EditPromptSystemPrompt = stringPtr("Describe the edit.")
+	project.MaxDimension = intPtr(1024)
+	project.CaptionTemplate = stringPtr("{trigger}, {caption}")
+	project.TriggerWord = stringPtr("ohwx")
+	project.KeepOriginal = true
+	project.AutoGenerateTasks = true
+	project.CaptionStripPatterns = []string{`^a photo of\s+`}
+	if err := updateProject(project); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := getProject(project.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stored
+}
+
+func TestUpdateProjectKeepsOmittedSettings(t *testing.T) {
+	setupTestDB(t)
+	project := settingsTestProject(t)
+
+	// The shapes App.tsx sends when editing prompt buttons and
+	// ProjectSettingsModal sends when saving
+	bodies := []string{
+		`{"name":"renamed","version":"1","promptButtons":["add a hat","remove the hat"],"projectType":"edit"}`,
+		`{"name":"renamed","version":"1","promptButtons":["add a hat","remove the hat"],"parentProjectId":"` + *project.ParentProjectID + `","projectType":"edit","captionApi":"{\"endpoint\":\"http://localhost:1234\"}","systemPrompt":"Describe the image.","autoCaptionConfig":"{\"rpm\":30}"}`,
+	}
+	for _, body := range bodies {
+		recorder := serve(updateProjectHandler, http.MethodPut, "/projects/"+project.ID, body)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("PUT returned %d: %s", recorder.Code, recorder.Body.String())
+		}
+
+		updated, err := getProject(project.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if updated.Name != "renamed" || len(updated.PromptButtons) != 2 {
+			t.Errorf("sent fields weren't applied: name %q, prompt buttons %v", updated.Name, updated.PromptButtons)
+		}
+
+		want := *project
+		want.Name, want.PromptButtons = updated.Name, updated.PromptButtons
+		want.CreatedAt, want.UpdatedAt = updated.CreatedAt, updated.UpdatedAt
+		wantValue, gotValue := reflect.ValueOf(want), reflect.ValueOf(*updated)
+		for i := 0; i < wantValue.NumField(); i++ {
+			if !reflect.DeepEqual(wantValue.Field(i).Interface(), gotValue.Field(i).Interface()) {
+				t.Errorf("%s changed from %v to %v", wantValue.Type().Field(i).Name, wantValue.Field(i), gotValue.Field(i))
+			}
+		}
+	}
+
+	// Sending a field still changes it, and null clears it
+	recorder := serve(updateProjectHandler, http.MethodPut, "/projects/"+project.ID,
+		`{"name":"renamed","version":"1","projectType":"edit","keepOriginal":false,"captionStripPatterns":[],"maxDimension":null}`)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("PUT returned %d: %s", recorder.Code, recorder.Body.String())
+	}
+	updated, _ := getProject(project.ID)
+	if updated.KeepOriginal {
+		t.Error("keepOriginal was not cleared")
+	}
+	if len(updated.CaptionStripPatterns) != 0 {
+		t.Errorf("captionStripPatterns = %v, want none", updated.CaptionStripPatterns)
+	}
+	if updated.MaxDimension != nil {
+		t.Errorf("maxDimension = %d, want it cleared", *updated.MaxDimension)
+	}
+	if !updated.AutoGenerateTasks || updated.TriggerWord == nil {
+		t.Error("omitted settings were reset")
+	}
+}
+
+// Every setting a client can send must be kept when it's omitted, so a new
+// project field can't be reset by older clients again
+func TestProjectSettingsKeptWhenOmittedCoversEveryField(t *testing.T) {
+	always := map[string]bool{"id": true, "name": true, "version": true, "projectType": true, "createdAt": true, "updatedAt": true}
+	fields := reflect.TypeOf(Project{})
+	for i := 0; i < fields.NumField(); i++ {
+		name, _, _ := strings.Cut(fields.Field(i).Tag.Get("json"), ",")
+		if always[name] {
+			continue
+		}
+		if _, ok := projectSettingsKeptWhenOmitted[name]; !ok {
+			t.Errorf("%s is reset when a PUT omits it", name)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+const maxPromptButtons = 200
+
+// PromptButtonPreset is a project's quick-prompt buttons in a form that can be
+// imported into other projects
+type PromptButtonPreset struct {
+	PromptButtons []string `json:"promptButtons"`
+}
+
+// mergePromptButtons appends the buttons of imported that existing lacks,
+// keeping existing's order first
+func mergePromptButtons(existing, imported []string) []string {
+	merged := append([]string{}, existing...)
+	seen := make(map[string]bool, len(existing))
+	for _, button := range existing {
+		seen[button] = true
+	}
+	for _, button := range imported {
+		if !seen[button] {
+			seen[button] = true
+			merged = append(merged, button)
+		}
+	}
+	return merged
+}
+
+// normalizePromptButtons trims each imported button, dropping blank ones and
+// repeats
+func normalizePromptButtons(buttons []string) ([]string, error) {
+	var trimmed []string
+	for _, button := range buttons {
+		if button = strings.TrimSpace(button); button != "" {
+			trimmed = append(trimmed, button)
+		}
+	}
+	normalized := mergePromptButtons(nil, trimmed)
+	if len(normalized) > maxPromptButtons {
+		return nil, fmt.Errorf("promptButtons must have at most %d buttons", maxPromptButtons)
+	}
+	return normalized, nil
+}
+
+// exportPromptButtonsHandler handles GET /projects/{id}/prompt-buttons/export
+func exportPromptButtonsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	projectID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/projects/"), "/prompt-buttons/export")
+	if projectID == "" {
+		writeError(w, r, "Project ID is required", http.StatusBadRequest)
+		return
+	}
+
+	project, err := getProject(projectID)
+	if err != nil {
+		writeError(w, r, "Failed to get project", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to get project for prompt button export", err, slog.String("project_id", projectID))
+		return
+	}
+	if project == nil {
+		writeError(w, r, "Project not found", http.StatusNotFound)
+		return
+	}
+
+	buttons := project.PromptButtons
+	if buttons == nil {
+		buttons = []string{}
+	}
+	writeJSON(w, r, http.StatusOK, PromptButtonPreset{PromptButtons: buttons})
+}
+
+// importPromptButtonsHandler handles POST /projects/{id}/prompt-buttons/import,
+// taking a preset as exported by exportPromptButtonsHandler. mode=replace (the
+// default) swaps the project's buttons for the preset's; mode=merge appends
+// those it doesn't have yet. Responds with the updated project.
+func importPromptButtonsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	projectID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/projects/"), "/prompt-buttons/import")
+	if projectID == "" {
+		writeError(w, r, "Project ID is required", http.StatusBadRequest)
+		return
+	}
+
+	mode := r.URL.Query().Get("mode")
+	if mode != "" && mode != "replace" && mode != "merge" {
+		writeError(w, r, "mode must be \"replace\" or \"merge\"", http.StatusBadRequest)
+		return
+	}
+
+	var preset PromptButtonPreset
+	if err := json.NewDecoder(r.Body).Decode(&preset); err != nil {
+		writeError(w, r, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if preset.PromptButtons == nil {
+		writeError(w, r, "promptButtons is required", http.StatusBadRequest)
+		return
+	}
+	imported, err := normalizePromptButtons(preset.PromptButtons)
+	if err != nil {
+		writeError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	existingProject, err := getProject(projectID)
+	if err != nil {
+		writeError(w, r, "Failed to get project", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to get project for prompt button import", err, slog.String("project_id", projectID))
+		return
+	}
+	if existingProject == nil {
+		writeError(w, r, "Project not found", http.StatusNotFound)
+		return
+	}
+
+	updatedProject := *existingProject
+	updatedProject.PromptButtons = imported
+	if mode == "merge" {
+		updatedProject.PromptButtons = mergePromptButtons(existingProject.PromptButtons, imported)
+		if len(updatedProject.PromptButtons) > maxPromptButtons {
+			writeError(w, r, fmt.Sprintf("Merged promptButtons would exceed %d buttons", maxPromptButtons), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := updateProject(&updatedProject); err != nil {
+		writeError(w, r, "Failed to update project", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to import prompt buttons", err, slog.String("project_id", projectID))
+		return
+	}
+
+	// Return the updated project, with the timestamps the database set
+	project, err := getProject(projectID)
+	if err != nil {
+		writeError(w, r, "Failed to get updated project", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to get updated project", err, slog.String("project_id", projectID))
+		return
+	}
+	recordAudit(r.Context(), projectID, "update", "project", projectID, existingProject, project)
+
+	logInfo(r.Context(), "Prompt buttons imported",
+		slog.String("project_id", projectID),
+		slog.String("mode", mode),
+		slog.Int("imported", len(imported)),
+		slog.Int("buttons", len(project.PromptButtons)))
+
+	writeJSON(w, r, http.StatusOK, project)
+}
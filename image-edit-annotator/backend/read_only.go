@@ -0,0 +1,36 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// readOnlyMiddleware rejects every request that could change data when
+// READ_ONLY is set, so an instance can be exposed as a viewer. Only GET, HEAD
+// and OPTIONS pass, plus POSTs whose body only carries a query: the JSONL
+// export's task subset and the pairs to compare for distances.
+func readOnlyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !appConfig.ReadOnly || !isMutatingRequest(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		writeError(w, r, "Server is in read-only mode", http.StatusForbidden)
+		logInfo(r.Context(), "Request rejected in read-only mode",
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path))
+	})
+}
+
+func isMutatingRequest(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	case http.MethodPost:
+		return !strings.HasSuffix(r.URL.Path, "/export/jsonl") && !strings.HasSuffix(r.URL.Path, "/distances")
+	default:
+		return true
+	}
+}
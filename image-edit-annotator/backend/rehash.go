@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/corona10/goimagehash"
+)
+
+// RehashFailure is an image whose hash couldn't be regenerated
+type RehashFailure struct {
+	ImageID string `json:"imageId"`
+	Error   string `json:"error"`
+}
+
+type RehashResult struct {
+	Checked  int             `json:"checked"`
+	Stale    int             `json:"stale"`    // Stored hashes that can't be compared with current ones, or missing dHashes
+	Rehashed int             `json:"rehashed"` // Hashes regenerated from the image files
+	Failed   []RehashFailure `json:"failed"`
+}
+
+// currentHashKind is the kind of hash ingestImage stores
+const currentHashKind = goimagehash.PHash
+
+// isHashCurrent reports whether a stored hash can be compared with hashes
+// computed by this build. Hashes written by an older or different hashing
+// algorithm either fail to parse or have another kind, and Distance rejects them.
+func isHashCurrent(hashString string) bool {
+	hash, err := parseImageHash(hashString)
+	return err == nil && hash.GetKind() == currentHashKind
+}
+
+// isDHashCurrent is isHashCurrent for the dHash, which older images lack
+func isDHashCurrent(hashString string) bool {
+	hash, err := parseImageHash(hashString)
+	return err == nil && hash.GetKind() == goimagehash.DHash
+}
+
+// computeImageHash hashes an image file the way ingestImage does, using the
+// first frame of animated images, and returns its pHash and dHash
+func computeImageHash(image *Image) (string, string, error) {
+	content, err := os.ReadFile(imageFilePath(image))
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read image: %v", err)
+	}
+	img, _, _, err := decodeFirstFrame(content)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to decode image: %v", err)
+	}
+	pHash, dHash, err := computePerceptualHashes(img)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to compute hash: %v", err)
+	}
+	return pHash.ToString(), dHash.ToString(), nil
+}
+
+// rehashProjectImages regenerates stale hashes from the image files, or every
+// hash when all is set. With dryRun it only counts the stale hashes.
+func rehashProjectImages(projectID string, all, dryRun bool) (*RehashResult, error) {
+	images, err := getImagesByProjectID(projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &RehashResult{Failed: []RehashFailure{}}
+	for i := range images {
+		image := &images[i]
+		result.Checked++
+		if !isHashCurrent(image.PHash) || !isDHashCurrent(image.DHash) {
+			result.Stale++
+		} else if !all {
+			continue
+		}
+		if dryRun {
+			continue
+		}
+
+		phash, dhash, err := computeImageHash(image)
+		if err == nil {
+			err = updateImagePerceptualHashes(image.ID, phash, dhash)
+		}
+		if err != nil {
+			result.Failed = append(result.Failed, RehashFailure{ImageID: image.ID, Error: err.Error()})
+			logger.Warn("Failed to rehash image",
+				"error", err,
+				"project_id", projectID,
+				"image_id", image.ID,
+			)
+			continue
+		}
+		result.Rehashed++
+	}
+
+	return result, nil
+}
+
+// rehashProjectHandler handles POST /projects/{id}/rehash. By default only
+// hashes that can't be compared with current ones are regenerated; all=true
+// regenerates every hash and dryRun=true just reports how many are stale.
+func rehashProjectHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	projectID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/projects/"), "/rehash")
+	if projectID == "" {
+		writeError(w, r, "Project ID is required", http.StatusBadRequest)
+		return
+	}
+
+	project, err := getProject(projectID)
+	if err != nil {
+		writeError(w, r, "Failed to get project", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to get project for rehash", err, slog.String("project_id", projectID))
+		return
+	}
+	if project == nil {
+		writeError(w, r, "Project not found", http.StatusNotFound)
+		return
+	}
+
+	all := r.URL.Query().Get("all") == "true"
+	dryRun := r.URL.Query().Get("dryRun") == "true"
+	result, err := rehashProjectImages(projectID, all, dryRun)
+	if err != nil {
+		writeError(w, r, "Failed to rehash images", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to rehash images", err, slog.String("project_id", projectID))
+		return
+	}
+
+	logInfo(r.Context(), "Project images rehashed",
+		slog.String("project_id", projectID),
+		slog.Int("stale", result.Stale),
+		slog.Int("rehashed", result.Rehashed),
+		slog.Int("failed", len(result.Failed)),
+		slog.Bool("dry_run", dryRun))
+
+	writeJSON(w, r, http.StatusOK, result)
+}
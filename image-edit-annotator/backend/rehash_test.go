@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// writeTestImageFile stores a distinct PNG for record under its project path
+func writeTestImageFile(t *testing.T, record *Image, variant int) {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			shade := uint8(0)
+			if (x/(8+variant*8)+y/(8+variant*8))%2 == 0 {
+				shade = 255
+			}
+			img.Set(x, y, color.Gray{Y: shade})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	path := imageFilePath(record)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// hashDistance compares two images' stored pHashes the way findSimilarImages does
+func hashDistance(a, b *Image) (int, error) {
+	hashA, err := parseImageHash(a.PHash)
+	if err != nil {
+		return 0, err
+	}
+	hashB, err := parseImageHash(b.PHash)
+	if err != nil {
+		return 0, err
+	}
+	return hashA.Distance(hashB)
+}
+
+func TestRehashOnlyRecomputesLegacyHashes(t *testing.T) {
+	setupTestDB(t)
+	project := createTestProject(t)
+
+	newImage := func(name string, variant int) *Image {
+		image := &Image{ID: uuid.New().String(), ProjectID: project.ID, Path: "images/" + name}
+		writeTestImageFile(t, image, variant)
+		return image
+	}
+	current := newImage("current.png", 0)
+	unprefixed := newImage("unprefixed.png", 1)
+	otherKind := newImage("other-kind.png", 2)
+
+	// The current row holds valid current-format hashes of another file, so a
+	// rehash that touched it would change them
+	foreignPHash, foreignDHash, err := computeImageHash(otherKind)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current.PHash, current.DHash = foreignPHash, foreignDHash
+	// Legacy rows: a bare hex hash from before kinds were recorded, and an
+	// average hash from a different algorithm, both without a dHash
+	unprefixed.PHash = "c3c3c3c33c3c3c3c"
+	otherKind.PHash = "a:ff00ff00ff00ff00"
+	for _, image := range []*Image{current, unprefixed, otherKind} {
+		if err := createImage(image); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := hashDistance(current, unprefixed); err == nil {
+		t.Error("distance to an unprefixed legacy hash should fail before rehashing")
+	}
+	if _, err := hashDistance(current, otherKind); err == nil {
+		t.Error("distance to a hash of another kind should fail before rehashing")
+	}
+
+	dryRun, err := rehashProjectImages(project.ID, false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dryRun.Checked != 3 || dryRun.Stale != 2 || dryRun.Rehashed != 0 {
+		t.Errorf("dry run = %+v, want 3 checked, 2 stale, 0 rehashed", dryRun)
+	}
+
+	result, err := rehashProjectImages(project.ID, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Stale != 2 || result.Rehashed != 2 || len(result.Failed) != 0 {
+		t.Errorf("rehash = %+v, want 2 stale and 2 rehashed", result)
+	}
+
+	images, err := getImagesByProjectID(project.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byID := make(map[string]Image)
+	for _, image := range images {
+		byID[image.ID] = image
+	}
+
+	if got := byID[current.ID]; got.PHash != foreignPHash || got.DHash != foreignDHash {
+		t.Errorf("current-format hashes were recomputed: %s %s", got.PHash, got.DHash)
+	}
+	for _, legacy := range []*Image{unprefixed, otherKind} {
+		got := byID[legacy.ID]
+		wantPHash, wantDHash, err := computeImageHash(legacy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.PHash != wantPHash || got.DHash != wantDHash {
+			t.Errorf("%s: hashes %s %s, want %s %s", legacy.Path, got.PHash, got.DHash, wantPHash, wantDHash)
+		}
+	}
+
+	// Distances now work across the rows that were and weren't rehashed
+	for _, pair := range [][2]string{{current.ID, unprefixed.ID}, {current.ID, otherKind.ID}, {unprefixed.ID, otherKind.ID}} {
+		a, b := byID[pair[0]], byID[pair[1]]
+		if _, err := hashDistance(&a, &b); err != nil {
+			t.Errorf("distance %s to %s: %v", a.Path, b.Path, err)
+		}
+	}
+	similar, err := findSimilarImages(byID[unprefixed.ID], images, 64, defaultHashWeights)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(similar) != 2 {
+		t.Errorf("found %d similar images across formats, want 2", len(similar))
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"image"
+
+	"golang.org/x/image/draw"
+)
+
+// resizedImage is a downscaled upload along with its re-encoded file content
+type resizedImage struct {
+	img     image.Image
+	content []byte
+}
+
+// downscaleImage shrinks img so its long edge is at most maxDimension,
+// preserving the aspect ratio, and re-encodes it in its original format. It
+// returns nil when the image already fits or its format can't be re-encoded
+// without loss of fidelity (only PNG and JPEG are resized).
+func downscaleImage(img image.Image, format string, maxDimension int) (*resizedImage, error) {
+	if format != "png" && format != "jpeg" {
+		return nil, nil
+	}
+
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= maxDimension && height <= maxDimension {
+		return nil, nil
+	}
+
+	newWidth, newHeight := maxDimension, maxDimension
+	if width >= height {
+		newHeight = max(1, (height*maxDimension+width/2)/width)
+	} else {
+		newWidth = max(1, (width*maxDimension+height/2)/height)
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, newWidth, newHeight))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Src, nil)
+
+	content, err := encodeImage(dst, format)
+	if err != nil {
+		return nil, err
+	}
+	return &resizedImage{img: dst, content: content}, nil
+}
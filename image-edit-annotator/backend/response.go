@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+// responseEnvelopeHeader lets a client opt into enveloped responses per request
+// when RESPONSE_ENVELOPE isn't enabled server-wide
+const responseEnvelopeHeader = "X-Response-Envelope"
+
+// responseEnvelope wraps JSON responses so clients can report the request ID
+// without reading headers. Exactly one of Data and Error is set.
+type responseEnvelope struct {
+	Data      interface{} `json:"data,omitempty"`
+	Error     string      `json:"error,omitempty"`
+	RequestID string      `json:"requestId"`
+}
+
+func useEnvelope(r *http.Request) bool {
+	if appConfig.ResponseEnvelope {
+		return true
+	}
+	enabled, _ := strconv.ParseBool(r.Header.Get(responseEnvelopeHeader))
+	return enabled
+}
+
+// writeJSON sends v as the response body with the given status. Enveloped
+// responses carry v under "data" alongside the request ID.
+func writeJSON(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	if useEnvelope(r) {
+		v = responseEnvelope{Data: v, RequestID: getRequestID(r.Context())}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError sends an error response. Without the envelope this is the plain
+// text body of http.Error, as clients have always received.
+func writeError(w http.ResponseWriter, r *http.Request, message string, status int) {
+	if !useEnvelope(r) {
+		http.Error(w, message, status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(responseEnvelope{Error: message, RequestID: getRequestID(r.Context())})
+}
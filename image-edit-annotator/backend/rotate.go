@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/math/f64"
+)
+
+// rotateImage turns img clockwise by a multiple of 90 degrees. Nearest
+// neighbour sampling on a quarter-turn transform copies pixels exactly.
+func rotateImage(img image.Image, degrees int) image.Image {
+	bounds := img.Bounds()
+	w, h := float64(bounds.Dx()), float64(bounds.Dy())
+	minX, minY := float64(bounds.Min.X), float64(bounds.Min.Y)
+
+	var dstRect image.Rectangle
+	var s2d f64.Aff3
+	switch degrees {
+	case 90:
+		dstRect = image.Rect(0, 0, bounds.Dy(), bounds.Dx())
+		s2d = f64.Aff3{0, -1, h + minY, 1, 0, -minX}
+	case 180:
+		dstRect = image.Rect(0, 0, bounds.Dx(), bounds.Dy())
+		s2d = f64.Aff3{-1, 0, w + minX, 0, -1, h + minY}
+	default: // 270
+		dstRect = image.Rect(0, 0, bounds.Dy(), bounds.Dx())
+		s2d = f64.Aff3{0, 1, -minY, -1, 0, w + minX}
+	}
+
+	// Keep paletted images paletted so GIFs don't get re-quantised
+	var dst draw.Image
+	if paletted, ok := img.(*image.Paletted); ok {
+		dst = image.NewPaletted(dstRect, paletted.Palette)
+	} else {
+		dst = image.NewRGBA(dstRect)
+	}
+	draw.NearestNeighbor.Transform(dst, s2d, img, bounds, draw.Src, nil)
+	return dst
+}
+
+// encodeImage writes img in the given format. WebP has no encoder available.
+func encodeImage(img image.Image, format string) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case "png":
+		err = png.Encode(&buf, img)
+	case "jpeg":
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 95})
+	case "gif":
+		err = gif.Encode(&buf, img, nil)
+	default:
+		return nil, fmt.Errorf("rotating %s images is not supported", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func rotateImageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	imageID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/images/"), "/rotate")
+	if imageID == "" {
+		writeError(w, r, "Image ID is required", http.StatusBadRequest)
+		return
+	}
+
+	var degrees int
+	switch r.URL.Query().Get("degrees") {
+	case "90":
+		degrees = 90
+	case "180":
+		degrees = 180
+	case "270":
+		degrees = 270
+	default:
+		writeError(w, r, "degrees must be 90, 180 or 270", http.StatusBadRequest)
+		return
+	}
+
+	imageRecord, err := getImage(imageID)
+	if err != nil {
+		writeError(w, r, "Failed to get image", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to get image for rotation", err, slog.String("image_id", imageID))
+		return
+	}
+	if imageRecord == nil {
+		writeError(w, r, "Image not found", http.StatusNotFound)
+		return
+	}
+	if imageRecord.Animated {
+		writeError(w, r, "Animated images can't be rotated", http.StatusBadRequest)
+		return
+	}
+
+	filePath := imageFilePath(imageRecord)
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		writeError(w, r, "Failed to read image", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to read image for rotation", err, slog.String("image_id", imageID))
+		return
+	}
+
+	img, format, _, err := decodeFirstFrame(content)
+	if err != nil {
+		writeError(w, r, "Failed to decode image", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to decode image for rotation", err, slog.String("image_id", imageID))
+		return
+	}
+
+	rotated := rotateImage(img, degrees)
+	rotatedContent, err := encodeImage(rotated, format)
+	if err != nil {
+		writeError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	pHash, dHash, err := computePerceptualHashes(rotated)
+	if err != nil {
+		writeError(w, r, "Failed to hash rotated image", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to hash rotated image", err, slog.String("image_id", imageID))
+		return
+	}
+	hashes := imageHashes{pHash: pHash.ToString(), dHash: dHash.ToString(), contentHash: hashContent(rotatedContent)}
+
+	// Blobs may be shared with other images, so a rotated blob image gets a new
+	// blob rather than having its file rewritten
+	oldBlobHash := imageRecord.BlobHash
+	if oldBlobHash != "" {
+		if err := replaceBlobImageContent(imageRecord, rotatedContent, hashes); err != nil {
+			writeError(w, r, "Failed to save rotated image", http.StatusInternalServerError)
+			logError(r.Context(), "Failed to save rotated image", err, slog.String("image_id", imageID))
+			return
+		}
+	} else {
+		tmpFile, err := os.CreateTemp(filepath.Dir(filePath), ".rotate-*")
+		if err != nil {
+			writeError(w, r, "Failed to save rotated image", http.StatusInternalServerError)
+			logError(r.Context(), "Failed to create temp file for rotation", err, slog.String("image_id", imageID))
+			return
+		}
+		_, writeErr := tmpFile.Write(rotatedContent)
+		closeErr := tmpFile.Close()
+		if writeErr != nil || closeErr != nil {
+			os.Remove(tmpFile.Name())
+			writeError(w, r, "Failed to save rotated image", http.StatusInternalServerError)
+			logError(r.Context(), "Failed to write rotated image", fmt.Errorf("%v %v", writeErr, closeErr), slog.String("image_id", imageID))
+			return
+		}
+
+		if err := replaceImageFile(imageID, hashes, tmpFile.Name(), filePath); err != nil {
+			os.Remove(tmpFile.Name())
+			writeError(w, r, "Failed to save rotated image", http.StatusInternalServerError)
+			logError(r.Context(), "Failed to replace rotated image", err, slog.String("image_id", imageID))
+			return
+		}
+		imageRecord.PHash, imageRecord.DHash, imageRecord.ContentHash = hashes.pHash, hashes.dHash, hashes.contentHash
+	}
+
+	removeCachedThumbnails(imageRecord.ProjectID, strings.TrimPrefix(imageRecord.Path, "images/"))
+
+	logInfo(r.Context(), "Image rotated",
+		slog.String("image_id", imageID),
+		slog.Int("degrees", degrees))
+
+	writeJSON(w, r, http.StatusOK, imageRecord)
+}
+
+// replaceBlobImageContent stores content as a new blob and points the image at
+// it, releasing the old blob if nothing else references it
+func replaceBlobImageContent(imageRecord *Image, content []byte, hashes imageHashes) error {
+	oldBlobHash := imageRecord.BlobHash
+
+	blobMu.RLock()
+	newBlobHash, err := storeBlob(content)
+	if err == nil {
+		err = updateImageContent(imageRecord.ID, hashes, newBlobHash)
+	}
+	blobMu.RUnlock()
+
+	if err != nil {
+		if newBlobHash != "" {
+			releaseBlobs([]string{newBlobHash})
+		}
+		return err
+	}
+
+	imageRecord.PHash, imageRecord.DHash, imageRecord.ContentHash = hashes.pHash, hashes.dHash, hashes.contentHash
+	imageRecord.BlobHash = newBlobHash
+	releaseBlobs([]string{oldBlobHash})
+	return nil
+}
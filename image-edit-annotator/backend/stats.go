@@ -0,0 +1,136 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProjectWithStats is a project together with its image and task counts
+type ProjectWithStats struct {
+	Project
+	ProjectStats
+}
+
+// statsCacheEntry holds one project's stats. ready is closed once the first
+// request has loaded them, so concurrent requests wait instead of querying.
+type statsCacheEntry struct {
+	ready   chan struct{}
+	stats   *ProjectStats
+	err     error
+	expires time.Time
+}
+
+var (
+	statsCache   = make(map[string]*statsCacheEntry)
+	statsCacheMu sync.Mutex
+)
+
+// getCachedProjectStats returns a project's stats, loading them at most once
+// per TTL however many requests arrive together
+func getCachedProjectStats(project *Project) (*ProjectStats, error) {
+	statsCacheMu.Lock()
+	entry, exists := statsCache[project.ID]
+	if exists {
+		select {
+		case <-entry.ready:
+			if time.Now().After(entry.expires) {
+				exists = false
+			}
+		default:
+			// Still loading; wait for it below
+		}
+	}
+	if !exists {
+		entry = &statsCacheEntry{ready: make(chan struct{})}
+		statsCache[project.ID] = entry
+		statsCacheMu.Unlock()
+
+		entry.stats, entry.err = getProjectStats(project.ID, project.ProjectType)
+		entry.expires = time.Now().Add(appConfig.StatsCacheTTL)
+		close(entry.ready)
+
+		// Don't cache failures
+		if entry.err != nil {
+			statsCacheMu.Lock()
+			if statsCache[project.ID] == entry {
+				delete(statsCache, project.ID)
+			}
+			statsCacheMu.Unlock()
+		}
+		return entry.stats, entry.err
+	}
+	statsCacheMu.Unlock()
+
+	<-entry.ready
+	return entry.stats, entry.err
+}
+
+// invalidateProjectStats drops cached stats after a project's images or tasks change
+func invalidateProjectStats(projectID string) {
+	statsCacheMu.Lock()
+	delete(statsCache, projectID)
+	statsCacheMu.Unlock()
+}
+
+func listProjectsWithStatsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	projects, err := listProjects()
+	if err != nil {
+		writeError(w, r, "Failed to list projects", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to list projects for stats", err)
+		return
+	}
+
+	result := make([]ProjectWithStats, 0, len(projects))
+	for _, project := range projects {
+		stats, err := getCachedProjectStats(&project)
+		if err != nil {
+			writeError(w, r, "Failed to get project stats", http.StatusInternalServerError)
+			logError(r.Context(), "Failed to get project stats", err, slog.String("project_id", project.ID))
+			return
+		}
+		result = append(result, ProjectWithStats{Project: project, ProjectStats: *stats})
+	}
+
+	writeJSON(w, r, http.StatusOK, result)
+}
+
+func getProjectStatsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	projectID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/projects/"), "/stats")
+	if projectID == "" {
+		writeError(w, r, "Project ID is required", http.StatusBadRequest)
+		return
+	}
+
+	project, err := getProject(projectID)
+	if err != nil {
+		writeError(w, r, "Failed to get project", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to get project for stats", err, slog.String("project_id", projectID))
+		return
+	}
+	if project == nil {
+		writeError(w, r, "Project not found", http.StatusNotFound)
+		return
+	}
+
+	stats, err := getCachedProjectStats(project)
+	if err != nil {
+		writeError(w, r, "Failed to get project stats", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to get project stats", err, slog.String("project_id", projectID))
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, stats)
+}
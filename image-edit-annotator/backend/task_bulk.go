@@ -0,0 +1,60 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// skipRemainingTasksHandler handles POST /projects/{id}/tasks/skip-remaining
+// and its inverse POST /projects/{id}/tasks/unskip. Only tasks with neither an
+// image B nor a prompt are touched, so unskip also reverses tasks skipped one
+// at a time before any annotation.
+func skipRemainingTasksHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	skip := strings.HasSuffix(r.URL.Path, "/tasks/skip-remaining")
+	projectID := strings.TrimPrefix(r.URL.Path, "/projects/")
+	projectID = strings.TrimSuffix(strings.TrimSuffix(projectID, "/tasks/skip-remaining"), "/tasks/unskip")
+	if projectID == "" {
+		writeError(w, r, "Project ID is required", http.StatusBadRequest)
+		return
+	}
+
+	project, err := getProject(projectID)
+	if err != nil {
+		writeError(w, r, "Failed to get project", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to get project for bulk skip", err, slog.String("project_id", projectID))
+		return
+	}
+	if project == nil {
+		writeError(w, r, "Project not found", http.StatusNotFound)
+		return
+	}
+
+	count, err := setUnannotatedTasksSkipped(projectID, skip)
+	if err != nil {
+		writeError(w, r, "Failed to update tasks", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to bulk update skipped tasks", err,
+			slog.String("project_id", projectID),
+			slog.Bool("skipped", skip))
+		return
+	}
+	invalidateProjectStats(projectID)
+
+	action, key := "bulk_unskip", "unskipped"
+	if skip {
+		action, key = "bulk_skip", "skipped"
+	}
+	recordAudit(r.Context(), projectID, action, "task", projectID, nil, map[string]int64{"count": count})
+
+	logInfo(r.Context(), "Unannotated tasks updated",
+		slog.String("project_id", projectID),
+		slog.Bool("skipped", skip),
+		slog.Int64("count", count))
+
+	writeJSON(w, r, http.StatusOK, map[string]int64{key: count})
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// claimTaskHandler handles POST /tasks/{id}/claim?annotator=..., assigning the
+// task to the annotator so others working on the project skip it
+func claimTaskHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	taskID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/tasks/"), "/claim")
+	if taskID == "" {
+		writeError(w, r, "Task ID is required", http.StatusBadRequest)
+		return
+	}
+	annotator := strings.TrimSpace(r.URL.Query().Get("annotator"))
+	if annotator == "" {
+		writeError(w, r, "annotator is required", http.StatusBadRequest)
+		return
+	}
+
+	task, err := getTask(taskID)
+	if err != nil {
+		writeError(w, r, "Failed to get task", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to get task for claim", err, slog.String("task_id", taskID))
+		return
+	}
+	if task == nil {
+		writeError(w, r, "Task not found", http.StatusNotFound)
+		return
+	}
+
+	claimed, err := claimTask(taskID, annotator, appConfig.TaskClaimTTL)
+	if err != nil {
+		writeError(w, r, "Failed to claim task", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to claim task", err, slog.String("task_id", taskID))
+		return
+	}
+	if !claimed {
+		writeJSON(w, r, http.StatusConflict, map[string]interface{}{
+			"error":     "Task is claimed by another annotator",
+			"claimedBy": task.ClaimedBy,
+			"claimedAt": task.ClaimedAt,
+		})
+		return
+	}
+
+	// Reload so the response carries the stored claim time
+	task, err = getTask(taskID)
+	if err != nil || task == nil {
+		writeError(w, r, "Failed to get task", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to reload claimed task", err, slog.String("task_id", taskID))
+		return
+	}
+
+	logInfo(r.Context(), "Task claimed",
+		slog.String("task_id", taskID),
+		slog.String("annotator", annotator))
+
+	writeJSON(w, r, http.StatusOK, task)
+}
+
+// releaseTaskHandler handles POST /tasks/{id}/release?annotator=..., clearing
+// the annotator's claim. force=true clears a claim held by anyone.
+func releaseTaskHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	taskID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/tasks/"), "/release")
+	if taskID == "" {
+		writeError(w, r, "Task ID is required", http.StatusBadRequest)
+		return
+	}
+	annotator := strings.TrimSpace(r.URL.Query().Get("annotator"))
+	force := r.URL.Query().Get("force") == "true"
+	if annotator == "" && !force {
+		writeError(w, r, "annotator is required", http.StatusBadRequest)
+		return
+	}
+
+	task, err := getTask(taskID)
+	if err != nil {
+		writeError(w, r, "Failed to get task", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to get task for release", err, slog.String("task_id", taskID))
+		return
+	}
+	if task == nil {
+		writeError(w, r, "Task not found", http.StatusNotFound)
+		return
+	}
+	if task.ClaimedBy == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	released, err := releaseTask(taskID, annotator, force)
+	if err != nil {
+		writeError(w, r, "Failed to release task", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to release task", err, slog.String("task_id", taskID))
+		return
+	}
+	if !released {
+		writeError(w, r, "Task is claimed by another annotator", http.StatusConflict)
+		return
+	}
+
+	logInfo(r.Context(), "Task released",
+		slog.String("task_id", taskID),
+		slog.String("annotator", annotator),
+		slog.Bool("forced", force))
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getNextTaskHandler handles GET /projects/{id}/next-task?annotator=..., which
+// returns the oldest unfinished task that no other annotator holds. It doesn't
+// claim the task; clients claim it next and move on if that conflicts.
+func getNextTaskHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	projectID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/projects/"), "/next-task")
+	if projectID == "" {
+		writeError(w, r, "Project ID is required", http.StatusBadRequest)
+		return
+	}
+	annotator := strings.TrimSpace(r.URL.Query().Get("annotator"))
+
+	task, err := getNextTask(projectID, annotator, appConfig.TaskClaimTTL)
+	if err != nil {
+		writeError(w, r, "Failed to get next task", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to get next task", err, slog.String("project_id", projectID))
+		return
+	}
+	if task == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, task)
+}
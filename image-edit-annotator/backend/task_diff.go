@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+// pixelDiffSize is the side length both images are scaled to before their
+// pixels are compared
+const pixelDiffSize = 64
+
+type TaskDiffResponse struct {
+	TaskID          string   `json:"taskId"`
+	ImageAID        string   `json:"imageAId"`
+	ImageBID        string   `json:"imageBId"`
+	HashDistance    *int     `json:"hashDistance"`              // Hamming distance between the pHashes, nil if either hash is stale
+	PixelDifference *float64 `json:"pixelDifference,omitempty"` // Mean absolute channel difference from 0 (identical) to 1, with ?pixels=true
+}
+
+// loadThumbnail decodes an image file and scales it to a
+// pixelDiffSize x pixelDiffSize square, ignoring the aspect ratio
+func loadThumbnail(img *Image) (*image.RGBA, error) {
+	content, err := os.ReadFile(imageFilePath(img))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image: %v", err)
+	}
+	decoded, _, _, err := decodeFirstFrame(content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %v", err)
+	}
+	thumbnail := image.NewRGBA(image.Rect(0, 0, pixelDiffSize, pixelDiffSize))
+	draw.ApproxBiLinear.Scale(thumbnail, thumbnail.Bounds(), decoded, decoded.Bounds(), draw.Src, nil)
+	return thumbnail, nil
+}
+
+// pixelDifference returns the mean absolute difference of the RGB channels of
+// two images of the same size, scaled to [0, 1]
+func pixelDifference(a, b *image.RGBA) float64 {
+	var total int
+	for i := 0; i < len(a.Pix); i += 4 {
+		for c := 0; c < 3; c++ {
+			d := int(a.Pix[i+c]) - int(b.Pix[i+c])
+			if d < 0 {
+				d = -d
+			}
+			total += d
+		}
+	}
+	return float64(total) / float64(len(a.Pix)/4*3*255)
+}
+
+// getTaskDiffHandler handles GET /tasks/{id}/diff, comparing a task's image A
+// with its selected image B
+func getTaskDiffHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	taskID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/tasks/"), "/diff")
+	if taskID == "" {
+		writeError(w, r, "Task ID is required", http.StatusBadRequest)
+		return
+	}
+
+	task, err := getTask(taskID)
+	if err != nil {
+		writeError(w, r, "Failed to get task", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to get task for diff", err, slog.String("task_id", taskID))
+		return
+	}
+	if task == nil {
+		writeError(w, r, "Task not found", http.StatusNotFound)
+		return
+	}
+	if !task.ImageBId.Valid {
+		writeError(w, r, "Task has no image B selected", http.StatusConflict)
+		return
+	}
+
+	imageA, err := getImage(task.ImageAID)
+	if err != nil {
+		writeError(w, r, "Failed to get image", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to get image A for diff", err, slog.String("task_id", taskID))
+		return
+	}
+	imageB, err := getImage(task.ImageBId.String)
+	if err != nil {
+		writeError(w, r, "Failed to get image", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to get image B for diff", err, slog.String("task_id", taskID))
+		return
+	}
+	if imageA == nil || imageB == nil {
+		writeError(w, r, "Image not found", http.StatusNotFound)
+		return
+	}
+
+	response := TaskDiffResponse{
+		TaskID:   task.ID,
+		ImageAID: imageA.ID,
+		ImageBID: imageB.ID,
+	}
+
+	hashA, errA := parseImageHash(imageA.PHash)
+	hashB, errB := parseImageHash(imageB.PHash)
+	if errA == nil && errB == nil {
+		if distance, err := hashA.Distance(hashB); err == nil {
+			response.HashDistance = &distance
+		}
+	}
+	if response.HashDistance == nil {
+		logger.Warn("Failed to compare image hashes; POST /projects/{id}/rehash regenerates stale hashes",
+			"task_id", task.ID,
+			"image_a_id", imageA.ID,
+			"image_b_id", imageB.ID,
+		)
+	}
+
+	if r.URL.Query().Get("pixels") == "true" {
+		var thumbnails [2]*image.RGBA
+		for i, img := range []*Image{imageA, imageB} {
+			thumbnails[i], err = loadThumbnail(img)
+			if err != nil {
+				writeError(w, r, "Failed to compare images", http.StatusInternalServerError)
+				logError(r.Context(), "Failed to load image for diff", err,
+					slog.String("task_id", taskID),
+					slog.String("image_id", img.ID))
+				return
+			}
+		}
+		difference := pixelDifference(thumbnails[0], thumbnails[1])
+		response.PixelDifference = &difference
+	}
+
+	writeJSON(w, r, http.StatusOK, response)
+}
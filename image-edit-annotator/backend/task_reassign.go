@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// ReassignTaskRequest moves a task onto a different image A. Image B, the
+// prompt and the rest of the annotation are kept.
+type ReassignTaskRequest struct {
+	ImageAID            string `json:"imageAId"`
+	RecomputeCandidates bool   `json:"recomputeCandidates"` // Replace the candidates with the new image A's closest matches
+	SimilarityThreshold int    `json:"similarityThreshold"` // With recomputeCandidates; defaults as for generate-tasks
+	MaxCandidates       int    `json:"maxCandidates"`       // With recomputeCandidates; defaults as for generate-tasks
+}
+
+// reassignTaskHandler handles PATCH /tasks/{id} bodies with imageAId, which
+// updateTaskHandler hands over, pointing a task generated from the wrong
+// source image at another image of its project. The new image A must not
+// already have a task, be excluded, or be the task's image B. Without
+// recomputeCandidates the stored candidates are kept, less the new image A
+// itself.
+func reassignTaskHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPatch {
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	taskID := strings.TrimPrefix(r.URL.Path, "/tasks/")
+	if taskID == "" {
+		writeError(w, r, "Task ID is required", http.StatusBadRequest)
+		return
+	}
+
+	var req ReassignTaskRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.ImageAID == "" {
+		writeError(w, r, "imageAId is required", http.StatusBadRequest)
+		return
+	}
+
+	existingTask, err := getTask(taskID)
+	if err != nil {
+		writeError(w, r, "Failed to get task", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to get task for reassignment", err, slog.String("task_id", taskID))
+		return
+	}
+	if existingTask == nil {
+		writeError(w, r, "Task not found", http.StatusNotFound)
+		return
+	}
+
+	imageA, err := getImage(req.ImageAID)
+	if err != nil {
+		writeError(w, r, "Failed to get image", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to get new image A", err, slog.String("task_id", taskID))
+		return
+	}
+	if imageA == nil || imageA.ProjectID != existingTask.ProjectID {
+		writeError(w, r, "imageAId must be an image in the task's project", http.StatusBadRequest)
+		return
+	}
+	if imageA.Excluded {
+		writeError(w, r, "imageAId is excluded from pairing", http.StatusBadRequest)
+		return
+	}
+	if existingTask.ImageBId.Valid && existingTask.ImageBId.String == imageA.ID {
+		writeError(w, r, "imageAId must be a different image from the task's image B", http.StatusBadRequest)
+		return
+	}
+	if imageA.ID != existingTask.ImageAID {
+		taken, err := getTaskImageAIDs(existingTask.ProjectID)
+		if err != nil {
+			writeError(w, r, "Failed to get tasks", http.StatusInternalServerError)
+			logError(r.Context(), "Failed to get task images for reassignment", err, slog.String("task_id", taskID))
+			return
+		}
+		if taken[imageA.ID] {
+			writeError(w, r, "imageAId already has a task", http.StatusConflict)
+			return
+		}
+	}
+
+	var candidates []TaskCandidate
+	if req.RecomputeCandidates {
+		project, err := getProject(existingTask.ProjectID)
+		if err != nil {
+			writeError(w, r, "Failed to get project", http.StatusInternalServerError)
+			logError(r.Context(), "Failed to get project for reassignment", err, slog.String("task_id", taskID))
+			return
+		}
+
+		// Omitted parameters use the project defaults, then the server defaults
+		if req.SimilarityThreshold <= 0 {
+			req.SimilarityThreshold = 10
+			if project.DefaultSimilarityThreshold != nil {
+				req.SimilarityThreshold = *project.DefaultSimilarityThreshold
+			}
+		}
+		if req.MaxCandidates <= 0 {
+			req.MaxCandidates = 5
+			if project.DefaultMaxCandidates != nil {
+				req.MaxCandidates = *project.DefaultMaxCandidates
+			}
+		}
+		req.MaxCandidates = clampMaxCandidates(r.Context(), existingTask.ProjectID, req.MaxCandidates)
+
+		images, err := getImagesByProjectID(existingTask.ProjectID)
+		if err != nil {
+			writeError(w, r, "Failed to get images", http.StatusInternalServerError)
+			logError(r.Context(), "Failed to get images for reassignment", err, slog.String("project_id", existingTask.ProjectID))
+			return
+		}
+		similar, err := findSimilarImages(*imageA, images, req.SimilarityThreshold, defaultHashWeights)
+		if err != nil {
+			writeError(w, r, "Failed to find similar images", http.StatusInternalServerError)
+			logError(r.Context(), "Failed to find similar images", err, slog.String("task_id", taskID))
+			return
+		}
+		for _, candidate := range selectCandidates(similar, req.MaxCandidates, req.SimilarityThreshold, candidateStrategyNearest) {
+			distance := candidate.Distance
+			candidates = append(candidates, TaskCandidate{ImageID: candidate.Image.ID, Distance: &distance})
+		}
+	}
+
+	if err := reassignTaskImageA(taskID, imageA.ID, req.RecomputeCandidates, candidates); err != nil {
+		writeError(w, r, "Failed to reassign task", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to reassign task", err, slog.String("task_id", taskID))
+		return
+	}
+
+	// Return the updated task
+	task, err := getTask(taskID)
+	if err != nil {
+		writeError(w, r, "Failed to get updated task", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to get updated task", err, slog.String("task_id", taskID))
+		return
+	}
+	recordAudit(r.Context(), existingTask.ProjectID, "update", "task", taskID, existingTask, task)
+
+	logInfo(r.Context(), "Task image A reassigned",
+		slog.String("task_id", taskID),
+		slog.String("project_id", existingTask.ProjectID),
+		slog.String("previous_image_a_id", existingTask.ImageAID),
+		slog.String("image_a_id", imageA.ID),
+		slog.Bool("recomputed_candidates", req.RecomputeCandidates),
+		slog.Int("candidates", len(task.CandidateBIds)))
+
+	writeJSON(w, r, http.StatusOK, task)
+}
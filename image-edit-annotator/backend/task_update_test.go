@@ -0,0 +1,126 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestPatchTaskKeepsOmittedFields(t *testing.T) {
+	setupTestDB(t)
+	project := createTestProject(t)
+	imageA := createTestImage(t, project.ID, "images/a.png", "0000000000000000")
+	imageB := createTestImage(t, project.ID, "images/b.png", "0000000000000001")
+	task := createTestTask(t, Task{
+		ProjectID:      project.ID,
+		ImageAID:       imageA.ID,
+		ImageBId:       sql.NullString{String: imageB.ID, Valid: true},
+		Prompt:         sql.NullString{String: "add a hat", Valid: true},
+		NegativePrompt: sql.NullString{String: "blurry", Valid: true},
+	})
+
+	recorder := serve(updateTaskHandler, http.MethodPatch, "/tasks/"+task.ID, `{"skipped":true}`)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("PATCH returned %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	updated, err := getTask(task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !updated.Skipped {
+		t.Error("skipped was not set")
+	}
+	if updated.ImageBId != task.ImageBId {
+		t.Errorf("imageBId changed from %v to %v", task.ImageBId, updated.ImageBId)
+	}
+	if updated.Prompt != task.Prompt {
+		t.Errorf("prompt changed from %v to %v", task.Prompt, updated.Prompt)
+	}
+	if updated.NegativePrompt != task.NegativePrompt {
+		t.Errorf("negativePrompt changed from %v to %v", task.NegativePrompt, updated.NegativePrompt)
+	}
+
+	// An explicit null still clears a field
+	recorder = serve(updateTaskHandler, http.MethodPatch, "/tasks/"+task.ID, `{"prompt":null}`)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("PATCH returned %d: %s", recorder.Code, recorder.Body.String())
+	}
+	updated, _ = getTask(task.ID)
+	if updated.Prompt.Valid {
+		t.Errorf("prompt = %v, want it cleared", updated.Prompt)
+	}
+	if updated.ImageBId != task.ImageBId || !updated.Skipped {
+		t.Errorf("clearing the prompt changed other fields: %+v", updated)
+	}
+}
+
+func TestPatchTaskWithImageAIDReassigns(t *testing.T) {
+	setupTestDB(t)
+	project := createTestProject(t)
+	imageA := createTestImage(t, project.ID, "images/a.png", "0000000000000000")
+	imageB := createTestImage(t, project.ID, "images/b.png", "0000000000000001")
+	free := createTestImage(t, project.ID, "images/c.png", "0000000000000003")
+	task := createTestTask(t, Task{
+		ProjectID:     project.ID,
+		ImageAID:      imageA.ID,
+		ImageBId:      sql.NullString{String: imageB.ID, Valid: true},
+		Prompt:        sql.NullString{String: "add a hat", Valid: true},
+		CandidateBIds: []string{imageB.ID, free.ID},
+	})
+
+	recorder := serve(updateTaskHandler, http.MethodPatch, "/tasks/"+task.ID, fmt.Sprintf(`{"imageAId":%q}`, free.ID))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("PATCH returned %d: %s", recorder.Code, recorder.Body.String())
+	}
+	var response Task
+	decodeResponse(t, recorder, &response)
+	if response.ImageAID != free.ID {
+		t.Errorf("imageAId = %s, want %s", response.ImageAID, free.ID)
+	}
+	// reassignTaskHandler drops the new image A from the candidates
+	for _, candidateID := range response.CandidateBIds {
+		if candidateID == free.ID {
+			t.Errorf("candidates still include the new image A: %v", response.CandidateBIds)
+		}
+	}
+	if response.Prompt != task.Prompt || response.ImageBId != task.ImageBId {
+		t.Errorf("reassignment changed the annotation: %+v", response)
+	}
+
+	// Reassignment can't be mixed with annotation fields
+	recorder = serve(updateTaskHandler, http.MethodPatch, "/tasks/"+task.ID, fmt.Sprintf(`{"imageAId":%q,"skipped":true}`, imageA.ID))
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("mixed PATCH returned %d, want 400", recorder.Code)
+	}
+}
+
+func TestUpdateTaskRejectsSelfPair(t *testing.T) {
+	setupTestDB(t)
+	project := createTestProject(t)
+	imageA := createTestImage(t, project.ID, "images/a.png", "0000000000000000")
+	imageB := createTestImage(t, project.ID, "images/b.png", "0000000000000001")
+	task := createTestTask(t, Task{
+		ProjectID: project.ID,
+		ImageAID:  imageA.ID,
+		ImageBId:  sql.NullString{String: imageB.ID, Valid: true},
+		Prompt:    sql.NullString{String: "add a hat", Valid: true},
+	})
+
+	body := fmt.Sprintf(`{"imageBId":{"String":%q,"Valid":true},"prompt":{"String":"changed","Valid":true}}`, imageA.ID)
+	for _, method := range []string{http.MethodPut, http.MethodPatch} {
+		recorder := serve(updateTaskHandler, method, "/tasks/"+task.ID, body)
+		if recorder.Code != http.StatusBadRequest {
+			t.Errorf("%s with imageBId == imageAId returned %d, want 400", method, recorder.Code)
+		}
+
+		unchanged, err := getTask(task.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if unchanged.ImageBId != task.ImageBId || unchanged.Prompt != task.Prompt || !unchanged.UpdatedAt.Equal(task.UpdatedAt) {
+			t.Errorf("%s changed the task: %+v", method, unchanged)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// uploadImageBHandler handles POST /tasks/{id}/upload-b, adding a multipart
+// "file" to the task's project through the regular upload path and selecting
+// it as the task's image B
+func uploadImageBHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	taskID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/tasks/"), "/upload-b")
+	if taskID == "" {
+		writeError(w, r, "Task ID is required", http.StatusBadRequest)
+		return
+	}
+
+	existingTask, err := getTask(taskID)
+	if err != nil {
+		writeError(w, r, "Failed to get task", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to get task for image B upload", err, slog.String("task_id", taskID))
+		return
+	}
+	if existingTask == nil {
+		writeError(w, r, "Task not found", http.StatusNotFound)
+		return
+	}
+
+	project, err := getProject(existingTask.ProjectID)
+	if err != nil || project == nil {
+		writeError(w, r, "Failed to get project", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to get project for image B upload", err, slog.String("task_id", taskID))
+		return
+	}
+
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		writeError(w, r, "Error parsing multipart form", http.StatusBadRequest)
+		return
+	}
+	file, fileHeader, err := r.FormFile("file")
+	if err != nil {
+		writeError(w, r, "No file provided", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	projectDir := filepath.Join("data", "projects", project.ID, "images")
+	if err := os.MkdirAll(projectDir, 0755); err != nil {
+		writeError(w, r, "Error creating project directory", http.StatusInternalServerError)
+		return
+	}
+
+	// The upload becomes this task's image B, not a new image A
+	uploadProject := *project
+	uploadProject.AutoGenerateTasks = false
+
+	results := processUploads(&uploadProject, []uploadSource{{
+		Label:    fileHeader.Filename,
+		Filename: fileHeader.Filename,
+		Read: func() ([]byte, error) {
+			content, err := io.ReadAll(file)
+			if err != nil {
+				return nil, fmt.Errorf("Error reading file: %v", err)
+			}
+			return content, nil
+		},
+	}}, projectDir)
+
+	result := results[0]
+	switch result.Status {
+	case "created":
+	case "skipped":
+		writeError(w, r, result.Error+"; select the existing image instead", http.StatusConflict)
+		return
+	case "rejected":
+		writeError(w, r, result.Error, http.StatusBadRequest)
+		return
+	default:
+		status := http.StatusInternalServerError
+		if strings.HasPrefix(result.Error, "Invalid image format") {
+			status = http.StatusBadRequest
+		}
+		writeError(w, r, result.Error, status)
+		return
+	}
+
+	updatedTask := *existingTask
+	updatedTask.ImageBId = sql.NullString{String: result.Image.ID, Valid: true}
+	if err := updateTask(&updatedTask); err != nil {
+		writeError(w, r, "Failed to update task", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to set uploaded image B", err,
+			slog.String("task_id", taskID),
+			slog.String("image_id", result.Image.ID))
+		return
+	}
+	invalidateProjectStats(project.ID)
+
+	task, err := getTask(taskID)
+	if err != nil {
+		writeError(w, r, "Failed to get updated task", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to get updated task", err, slog.String("task_id", taskID))
+		return
+	}
+	recordAudit(r.Context(), project.ID, "update", "task", taskID, existingTask, task)
+
+	logInfo(r.Context(), "Image B uploaded",
+		slog.String("task_id", taskID),
+		slog.String("image_id", result.Image.ID))
+
+	writeJSON(w, r, http.StatusOK, task)
+}
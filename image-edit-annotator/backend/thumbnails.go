@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/jpeg"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"golang.org/x/image/draw"
+)
+
+const (
+	minThumbSize = 16
+	maxThumbSize = 1024
+)
+
+// thumbSemaphore bounds the number of concurrent decode+resize operations.
+// Cache hits never touch it.
+var thumbSemaphore = make(chan struct{}, appConfig.ThumbConcurrency)
+
+func thumbnailDir(projectID string, size int) string {
+	return filepath.Join("data", "projects", projectID, "thumbnails", strconv.Itoa(size))
+}
+
+func thumbnailPath(projectID, imagePath string, size int) string {
+	// imagePath is relative to the images directory
+	return filepath.Join(thumbnailDir(projectID, size), imagePath+".jpg")
+}
+
+// removeCachedThumbnails deletes every cached thumbnail size for an image
+func removeCachedThumbnails(projectID, imagePath string) {
+	sizeDirs, err := os.ReadDir(filepath.Join("data", "projects", projectID, "thumbnails"))
+	if err != nil {
+		return
+	}
+	for _, sizeDir := range sizeDirs {
+		size, err := strconv.Atoi(sizeDir.Name())
+		if err != nil {
+			continue
+		}
+		os.Remove(thumbnailPath(projectID, imagePath, size))
+	}
+}
+
+// thumbnailIsFresh reports whether a cached thumbnail exists and is newer than its source
+func thumbnailIsFresh(thumbPath, sourcePath string) bool {
+	thumbInfo, err := os.Stat(thumbPath)
+	if err != nil {
+		return false
+	}
+	sourceInfo, err := os.Stat(sourcePath)
+	if err != nil {
+		return false
+	}
+	return !thumbInfo.ModTime().Before(sourceInfo.ModTime())
+}
+
+func serveThumbnail(w http.ResponseWriter, r *http.Request, projectID, imagePath, sourcePath string, size int) {
+	thumbPath := thumbnailPath(projectID, imagePath, size)
+
+	// Cache hits bypass the semaphore entirely
+	if thumbnailIsFresh(thumbPath, sourcePath) {
+		http.ServeFile(w, r, thumbPath)
+		return
+	}
+
+	// Queue for a generation slot, giving up if the queue is saturated
+	timer := time.NewTimer(appConfig.ThumbQueueTimeout)
+	defer timer.Stop()
+	select {
+	case thumbSemaphore <- struct{}{}:
+		defer func() { <-thumbSemaphore }()
+	case <-timer.C:
+		retryAfter := int(appConfig.ThumbQueueTimeout.Seconds())
+		if retryAfter < 1 {
+			retryAfter = 1
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		writeError(w, r, "Thumbnail generation busy, try again later", http.StatusServiceUnavailable)
+		return
+	case <-r.Context().Done():
+		return
+	}
+
+	// Another request may have generated it while we were waiting
+	if !thumbnailIsFresh(thumbPath, sourcePath) {
+		if err := generateThumbnail(sourcePath, thumbPath, size); err != nil {
+			writeError(w, r, "Failed to generate thumbnail", http.StatusInternalServerError)
+			logError(r.Context(), "Failed to generate thumbnail", err,
+				slog.String("project_id", projectID),
+				slog.String("path", imagePath))
+			return
+		}
+	}
+
+	http.ServeFile(w, r, thumbPath)
+}
+
+// generateThumbnail scales the source image so its long edge is at most size
+// pixels and writes it as a JPEG, replacing any existing thumbnail atomically.
+func generateThumbnail(sourcePath, thumbPath string, size int) error {
+	content, err := os.ReadFile(sourcePath)
+	if err != nil {
+		return fmt.Errorf("failed to read source image: %v", err)
+	}
+
+	// Animated images are represented by their first frame
+	src, _, _, err := decodeFirstFrame(content)
+	if err != nil {
+		return fmt.Errorf("failed to decode image: %v", err)
+	}
+
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width > size || height > size {
+		if width >= height {
+			height = max(1, height*size/width)
+			width = size
+		} else {
+			width = max(1, width*size/height)
+			height = size
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.ApproxBiLinear.Scale(dst, dst.Bounds(), src, bounds, draw.Src, nil)
+
+	if err := os.MkdirAll(filepath.Dir(thumbPath), 0755); err != nil {
+		return fmt.Errorf("failed to create thumbnail directory: %v", err)
+	}
+
+	tmpFile, err := os.CreateTemp(filepath.Dir(thumbPath), ".thumb-*")
+	if err != nil {
+		return fmt.Errorf("failed to create thumbnail file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	if err := jpeg.Encode(tmpFile, dst, &jpeg.Options{Quality: 85}); err != nil {
+		tmpFile.Close()
+		return fmt.Errorf("failed to encode thumbnail: %v", err)
+	}
+	if err := tmpFile.Close(); err != nil {
+		return fmt.Errorf("failed to write thumbnail: %v", err)
+	}
+
+	return os.Rename(tmpFile.Name(), thumbPath)
+}
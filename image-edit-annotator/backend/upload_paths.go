@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// maxUploadPathDepth bounds how many folders deep a relative upload path may go
+const maxUploadPathDepth = 16
+
+// relativeUploadPath validates a client-supplied relative path (such as a
+// browser's webkitRelativePath) for a file in a folder upload, returning it
+// cleaned and slash-separated. Paths that are absolute, climb out of the
+// images directory or name hidden entries are rejected.
+func relativeUploadPath(raw string) (string, error) {
+	cleaned := path.Clean(strings.ReplaceAll(strings.TrimSpace(raw), "\\", "/"))
+	if cleaned == "." || cleaned == "" {
+		return "", fmt.Errorf("path %q is empty", raw)
+	}
+	if path.IsAbs(cleaned) || strings.Contains(cleaned, ":") {
+		return "", fmt.Errorf("path %q must be relative", raw)
+	}
+
+	segments := strings.Split(cleaned, "/")
+	if len(segments) > maxUploadPathDepth {
+		return "", fmt.Errorf("path %q is more than %d levels deep", raw, maxUploadPathDepth)
+	}
+	for _, segment := range segments {
+		if segment == ".." {
+			return "", fmt.Errorf("path %q leaves the project directory", raw)
+		}
+		if strings.HasPrefix(segment, ".") {
+			return "", fmt.Errorf("path %q contains a hidden entry", raw)
+		}
+	}
+	return cleaned, nil
+}
+
+// parseUploadPaths validates the optional paths form field, which gives each
+// uploaded file's relative path in the same order as files. It returns nil
+// when no paths were sent, so filenames are used as before.
+func parseUploadPaths(values []string, fileCount int) ([]string, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	if len(values) != fileCount {
+		return nil, fmt.Errorf("paths has %d entries but %d files were uploaded", len(values), fileCount)
+	}
+
+	paths := make([]string, len(values))
+	seen := make(map[string]bool, len(values))
+	for i, value := range values {
+		cleaned, err := relativeUploadPath(value)
+		if err != nil {
+			return nil, err
+		}
+		if seen[cleaned] {
+			return nil, fmt.Errorf("path %q is given for more than one file", cleaned)
+		}
+		seen[cleaned] = true
+		paths[i] = cleaned
+	}
+	return paths, nil
+}
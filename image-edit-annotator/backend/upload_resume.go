@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// restoreImageFile writes an upload back to disk for an image whose row was
+// stored but whose file has since gone missing, as happens when a batch is
+// retried after the files were lost. The upload goes through the same
+// downscaling as at ingestion and must match the stored image (its blob hash,
+// or else its pHash) so a different file can't take over the row.
+func restoreImageFile(project *Project, existing *Image, content []byte) error {
+	img, format, animated, err := decodeFirstFrame(content)
+	if err != nil {
+		return fmt.Errorf("Invalid image format: %v", err)
+	}
+	if project.MaxDimension != nil && !animated {
+		resized, err := downscaleImage(img, format, *project.MaxDimension)
+		if err != nil {
+			return fmt.Errorf("Error resizing image: %v", err)
+		}
+		if resized != nil {
+			img, content = resized.img, resized.content
+		}
+	}
+
+	if existing.BlobHash != "" {
+		if hashContent(content) != existing.BlobHash {
+			return fmt.Errorf("uploaded file differs from the stored image at %s", existing.Path)
+		}
+		if _, err := storeBlob(content); err != nil {
+			return fmt.Errorf("Error writing file: %v", err)
+		}
+		return nil
+	}
+
+	hash, err := computePHash(img)
+	if err != nil {
+		return fmt.Errorf("Error computing hash: %v", err)
+	}
+	if hash.ToString() != existing.PHash {
+		return fmt.Errorf("uploaded file differs from the stored image at %s", existing.Path)
+	}
+
+	filePath := imageFilePath(existing)
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return fmt.Errorf("Error creating directory: %v", err)
+	}
+	if err := os.WriteFile(filePath, content, 0644); err != nil {
+		return fmt.Errorf("Error writing file: %v", err)
+	}
+	return nil
+}
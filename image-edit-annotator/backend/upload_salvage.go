@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+)
+
+// Upload failure policies, for when storing an upload's images in one batch
+// fails after their files were already written
+const (
+	uploadFailureSalvage = "salvage" // Retry each image alone, keeping those that insert
+	uploadFailureDiscard = "discard" // Store none of the batch
+)
+
+// salvageImages inserts images one at a time after their batch insert failed,
+// so one bad row doesn't cost the rest. It returns how many were stored, the
+// IDs skipped as already stored, and the error for each image that wasn't.
+func salvageImages(images []Image) (int, map[string]bool, map[string]error) {
+	inserted := 0
+	duplicates := make(map[string]bool)
+	failures := make(map[string]error)
+	for _, image := range images {
+		n, duplicate, err := createImages([]Image{image})
+		if err != nil {
+			failures[image.ID] = err
+			continue
+		}
+		inserted += n
+		for id := range duplicate {
+			duplicates[id] = true
+		}
+	}
+	return inserted, duplicates, failures
+}
+
+// removeUnstoredImageFiles deletes the files written for an image whose row
+// couldn't be stored, so they don't linger as orphans. Blobs are left to
+// releaseBlobs, since another image may share them.
+func removeUnstoredImageFiles(image *Image) {
+	paths := []string{originalFilePath(image)}
+	if image.BlobHash == "" {
+		paths = append(paths, imageFilePath(image))
+	}
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			logger.Warn("Failed to remove file of unstored image",
+				"error", err,
+				"image_id", image.ID,
+				"path", path,
+			)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// generateTasksForUpload creates tasks for the images an upload just stored,
+// for projects with autoGenerateTasks set: a caption task each in caption
+// projects, and in edit projects an edit task each whose candidates come from
+// the whole project, using the project's generation defaults. It returns how
+// many tasks were created.
+func generateTasksForUpload(project *Project, imageIDs map[string]bool) (int, error) {
+	var response *TaskGenerationResponse
+	var err error
+	if project.ProjectType == "caption" {
+		response, err = generateCaptionTasksForProject(project.ID, imageIDs)
+	} else {
+		threshold := 10
+		if project.DefaultSimilarityThreshold != nil {
+			threshold = *project.DefaultSimilarityThreshold
+		}
+		maxCandidates := 5
+		if project.DefaultMaxCandidates != nil {
+			maxCandidates = *project.DefaultMaxCandidates
+		}
+		maxCandidates = clampMaxCandidates(context.Background(), project.ID, maxCandidates)
+		response, err = generateTasksForProject(project.ID, threshold, maxCandidates, 0, false, time.Time{}, imageIDs, nil, candidateStrategyNearest, defaultHashWeights)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return response.TasksCreated, nil
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+type URLUploadRequest struct {
+	URLs []string `json:"urls"`
+}
+
+// urlFetchClient only connects to public addresses. The check runs on the
+// resolved address at dial time, so it also covers redirects and hostnames
+// that resolve to internal networks. No proxy is used, since the dialer would
+// then only see the proxy's address.
+var urlFetchClient = &http.Client{
+	Timeout: appConfig.URLFetchTimeout,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 30 * time.Second,
+			Control: checkPublicAddress,
+		}).DialContext,
+		TLSHandshakeTimeout: 10 * time.Second,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+			return fmt.Errorf("redirect to unsupported scheme %q", req.URL.Scheme)
+		}
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		return nil
+	},
+}
+
+// checkPublicAddress is the fetch dialer's Control hook, rejecting loopback,
+// private, link-local and other non-routable destinations
+func checkPublicAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil || !isPublicIP(ip) {
+		return fmt.Errorf("refusing to fetch from non-public address %s", host)
+	}
+	return nil
+}
+
+// nonPublicPrefixes are the special-purpose ranges the netip.Addr predicates
+// don't cover
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),      // "This network"
+	netip.MustParsePrefix("100.64.0.0/10"),  // Carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),   // IETF protocol assignments
+	netip.MustParsePrefix("198.18.0.0/15"),  // Benchmarking
+	netip.MustParsePrefix("240.0.0.0/4"),    // Reserved, and broadcast
+	netip.MustParsePrefix("64:ff9b:1::/48"), // Local-use NAT64
+}
+
+// nat64Prefix is the well-known NAT64 prefix, which embeds an IPv4 address
+// in its last 32 bits
+var nat64Prefix = netip.MustParsePrefix("64:ff9b::/96")
+
+func isPublicIP(ip netip.Addr) bool {
+	// IPv4-mapped and NAT64 addresses reach the IPv4 address they embed. A
+	// zone would keep the prefixes below from matching.
+	ip = ip.WithZone("").Unmap()
+	if nat64Prefix.Contains(ip) {
+		embedded := ip.As16()
+		ip = netip.AddrFrom4([4]byte(embedded[12:]))
+	}
+
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	for _, prefix := range nonPublicPrefixes {
+		if prefix.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+func uploadURLHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	projectID := r.URL.Query().Get("projectId")
+	if projectID == "" {
+		writeError(w, r, "Project ID is required", http.StatusBadRequest)
+		return
+	}
+
+	// Check if project exists
+	project, err := getProject(projectID)
+	if err != nil {
+		writeError(w, r, "Failed to get project", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to get project for URL upload", err, slog.String("project_id", projectID))
+		return
+	}
+	if project == nil {
+		writeError(w, r, "Project not found", http.StatusNotFound)
+		return
+	}
+
+	var req URLUploadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(req.URLs) == 0 {
+		writeError(w, r, "No URLs provided", http.StatusBadRequest)
+		return
+	}
+
+	sources := make([]uploadSource, 0, len(req.URLs))
+	for _, rawURL := range req.URLs {
+		parsed, err := url.Parse(rawURL)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			writeError(w, r, fmt.Sprintf("Invalid URL: %s", rawURL), http.StatusBadRequest)
+			return
+		}
+		sources = append(sources, uploadSource{
+			Label:    rawURL,
+			Filename: filenameFromURL(parsed),
+			Read: func() ([]byte, error) {
+				return fetchImageURL(rawURL)
+			},
+		})
+	}
+
+	// Create project directory
+	projectDir := filepath.Join("data", "projects", projectID, "images")
+	if err := os.MkdirAll(projectDir, 0755); err != nil {
+		writeError(w, r, "Error creating project directory", http.StatusInternalServerError)
+		return
+	}
+
+	if r.URL.Query().Get("sync") == "true" {
+		logInfo(r.Context(), "Synchronous URL upload started",
+			slog.String("project_id", projectID),
+			slog.Int("url_count", len(sources)),
+		)
+		writeUploadResults(w, r, processUploads(project, sources, projectDir))
+		return
+	}
+
+	logInfo(r.Context(), "URL upload started",
+		slog.String("project_id", projectID),
+		slog.Int("url_count", len(sources)),
+	)
+	go processUploads(project, sources, projectDir)
+
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{
+		"message": "Upload started",
+		"count":   len(sources),
+	})
+}
+
+// filenameFromURL uses the last path segment of the URL as the stored filename,
+// prefixed with a hash of the whole URL so different URLs ending in the same
+// name don't collide. The same URL always maps to the same name, so a retried
+// upload is still skipped as a duplicate before it's fetched again.
+func filenameFromURL(u *url.URL) string {
+	name := path.Base(u.Path)
+	if name == "." || name == "/" || name == "" {
+		name = u.Host
+	}
+	sum := sha256.Sum256([]byte(u.String()))
+	// Never let a URL escape the project's images directory
+	name = strings.NewReplacer("/", "_", "\\", "_").Replace(filepath.Base(name))
+	return hex.EncodeToString(sum[:6]) + "_" + name
+}
+
+// fetchImageURL downloads an image, enforcing the configured size limit and
+// rejecting responses that aren't served as images
+func fetchImageURL(rawURL string) ([]byte, error) {
+	resp, err := urlFetchClient.Get(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("Error fetching URL: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Error fetching URL: status %d", resp.StatusCode)
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	if !strings.HasPrefix(contentType, "image/") {
+		return nil, fmt.Errorf("URL is not an image (content type %q)", contentType)
+	}
+
+	if resp.ContentLength > appConfig.URLFetchMaxBytes {
+		return nil, fmt.Errorf("Image exceeds the %d byte limit", appConfig.URLFetchMaxBytes)
+	}
+
+	content, err := io.ReadAll(io.LimitReader(resp.Body, appConfig.URLFetchMaxBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("Error reading response: %v", err)
+	}
+	if int64(len(content)) > appConfig.URLFetchMaxBytes {
+		return nil, fmt.Errorf("Image exceeds the %d byte limit", appConfig.URLFetchMaxBytes)
+	}
+
+	return content, nil
+}
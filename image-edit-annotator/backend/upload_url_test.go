@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCheckPublicAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		allowed bool
+	}{
+		{"93.184.216.34:443", true},
+		{"[2606:2800:220:1:248:1893:25c8:1946]:443", true},
+		{"127.0.0.1:80", false},
+		{"[::1]:80", false},
+		{"10.1.2.3:80", false},
+		{"172.16.0.1:80", false},
+		{"192.168.1.10:8080", false},
+		{"169.254.169.254:80", false},
+		{"[fe80::1]:80", false},
+		{"[fc00::1]:80", false},
+		{"0.0.0.0:80", false},
+		{"[::ffff:127.0.0.1]:80", false},
+		{"100.64.0.1:80", false},
+		{"100.127.255.254:80", false},
+		{"0.1.2.3:80", false},
+		{"192.0.0.170:80", false},
+		{"198.18.0.1:80", false},
+		{"198.19.255.255:80", false},
+		{"255.255.255.255:80", false},
+		{"[::ffff:10.0.0.1]:80", false},
+		{"[::ffff:100.64.0.1]:80", false},
+		{"[::ffff:93.184.216.34]:443", true},
+		{"[64:ff9b::a9fe:a9fe]:80", false},
+		{"[64:ff9b::7f00:1]:80", false},
+		{"[64:ff9b::c0a8:10a]:80", false},
+		{"[64:ff9b::5db8:d822]:443", true},
+		{"[64:ff9b:1::a00:1]:80", false},
+		{"[64:ff9b::7f00:1%eth0]:80", false},
+		{"100.128.0.1:80", true},
+		{"198.20.0.1:80", true},
+	}
+	for _, test := range tests {
+		err := checkPublicAddress("tcp", test.address, nil)
+		if test.allowed && err != nil {
+			t.Errorf("%s rejected: %v", test.address, err)
+		}
+		if !test.allowed && err == nil {
+			t.Errorf("%s allowed", test.address)
+		}
+	}
+}
+
+func TestFetchImageURLRefusesLoopback(t *testing.T) {
+	requested := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = true
+		w.Header().Set("Content-Type", "image/png")
+	}))
+	defer server.Close()
+
+	_, err := fetchImageURL(server.URL + "/image.png")
+	if err == nil || !strings.Contains(err.Error(), "non-public address") {
+		t.Fatalf("err = %v, want the address to be refused", err)
+	}
+	if requested {
+		t.Error("the loopback server received the request")
+	}
+}
+
+func TestFilenameFromURLAvoidsCollisions(t *testing.T) {
+	parse := func(raw string) *url.URL {
+		u, err := url.Parse(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return u
+	}
+
+	first := filenameFromURL(parse("https://a.example.com/photos/image.jpg"))
+	second := filenameFromURL(parse("https://b.example.com/other/image.jpg"))
+	if first == second {
+		t.Errorf("both URLs map to %q", first)
+	}
+	if !strings.HasSuffix(first, "_image.jpg") {
+		t.Errorf("filename %q lost the URL's basename", first)
+	}
+	if again := filenameFromURL(parse("https://a.example.com/photos/image.jpg")); again != first {
+		t.Errorf("same URL mapped to %q and %q", first, again)
+	}
+	if name := filenameFromURL(parse("https://example.com/a/..%2f..%2fescape.png")); strings.ContainsAny(name, `/\`) {
+		t.Errorf("filename %q contains a path separator", name)
+	}
+}
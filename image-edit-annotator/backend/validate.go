@@ -0,0 +1,157 @@
+package main
+
+import (
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DanglingReference is a task field pointing at an image that no longer exists
+type DanglingReference struct {
+	TaskID  string `json:"taskId"`
+	Field   string `json:"field"` // imageAId, imageBId or candidate for edit tasks; imageId for caption tasks
+	ImageID string `json:"imageId"`
+}
+
+// InvalidHash is an image whose stored pHash can't be compared with current ones
+type InvalidHash struct {
+	ImageID string `json:"imageId"`
+	PHash   string `json:"pHash"`
+}
+
+type ProjectValidationReport struct {
+	ProjectID            string              `json:"projectId"`
+	Valid                bool                `json:"valid"`
+	IssueCount           int                 `json:"issueCount"`
+	CheckedImages        int                 `json:"checkedImages"`
+	MissingFiles         []MissingImageFile  `json:"missingFiles"`
+	DanglingTasks        []DanglingReference `json:"danglingTasks"`
+	DanglingCaptionTasks []DanglingReference `json:"danglingCaptionTasks"`
+	InvalidHashes        []InvalidHash       `json:"invalidHashes"`
+	OrphanedFiles        []string            `json:"orphanedFiles"` // Files under the project's images directory that no image refers to
+}
+
+// findOrphanedImageFiles lists files in the project's images directory that
+// no image row points at. Images stored as shared blobs live elsewhere and
+// aren't considered.
+func findOrphanedImageFiles(projectID string, images []Image) ([]string, error) {
+	projectDir := filepath.Join("data", "projects", projectID)
+	referenced := make(map[string]bool, len(images))
+	for _, image := range images {
+		if image.BlobHash == "" {
+			referenced[filepath.Clean(image.Path)] = true
+		}
+	}
+
+	orphaned := []string{}
+	err := filepath.WalkDir(filepath.Join(projectDir, "images"), func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		relative, err := filepath.Rel(projectDir, path)
+		if err != nil {
+			return err
+		}
+		if !referenced[relative] {
+			orphaned = append(orphaned, filepath.ToSlash(relative))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(orphaned)
+	return orphaned, nil
+}
+
+// checkProjectIntegrity runs every integrity check against a project. Each
+// check is its own query, so tasks and captions are never loaded in full.
+func checkProjectIntegrity(projectID string) (*ProjectValidationReport, error) {
+	images, err := getImageFileRefs(projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ProjectValidationReport{
+		ProjectID:     projectID,
+		CheckedImages: len(images),
+		MissingFiles:  findMissingImageFiles(images),
+		InvalidHashes: []InvalidHash{},
+	}
+	for _, image := range images {
+		if !isHashCurrent(image.PHash) {
+			report.InvalidHashes = append(report.InvalidHashes, InvalidHash{ImageID: image.ID, PHash: image.PHash})
+		}
+	}
+
+	if report.DanglingTasks, err = getDanglingTaskReferences(projectID); err != nil {
+		return nil, err
+	}
+	if report.DanglingCaptionTasks, err = getDanglingCaptionTasks(projectID); err != nil {
+		return nil, err
+	}
+	if report.OrphanedFiles, err = findOrphanedImageFiles(projectID, images); err != nil {
+		return nil, err
+	}
+
+	report.IssueCount = len(report.MissingFiles) + len(report.DanglingTasks) +
+		len(report.DanglingCaptionTasks) + len(report.InvalidHashes) + len(report.OrphanedFiles)
+	report.Valid = report.IssueCount == 0
+	return report, nil
+}
+
+// validateProjectHandler handles GET /projects/{id}/validate, reporting every
+// integrity problem found in one pass: image rows without files, tasks and
+// caption tasks pointing at deleted images, pHashes that can't be compared
+// (POST /projects/{id}/rehash fixes these) and files no image refers to.
+func validateProjectHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	projectID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/projects/"), "/validate")
+	if projectID == "" {
+		writeError(w, r, "Project ID is required", http.StatusBadRequest)
+		return
+	}
+
+	project, err := getProject(projectID)
+	if err != nil {
+		writeError(w, r, "Failed to get project", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to get project for validation", err, slog.String("project_id", projectID))
+		return
+	}
+	if project == nil {
+		writeError(w, r, "Project not found", http.StatusNotFound)
+		return
+	}
+
+	report, err := checkProjectIntegrity(projectID)
+	if err != nil {
+		writeError(w, r, "Failed to validate project", http.StatusInternalServerError)
+		logError(r.Context(), "Failed to validate project", err, slog.String("project_id", projectID))
+		return
+	}
+
+	logInfo(r.Context(), "Project validated",
+		slog.String("project_id", projectID),
+		slog.Int("issues", report.IssueCount),
+		slog.Int("missing_files", len(report.MissingFiles)),
+		slog.Int("dangling_tasks", len(report.DanglingTasks)),
+		slog.Int("dangling_caption_tasks", len(report.DanglingCaptionTasks)),
+		slog.Int("invalid_hashes", len(report.InvalidHashes)),
+		slog.Int("orphaned_files", len(report.OrphanedFiles)))
+
+	writeJSON(w, r, http.StatusOK, report)
+}
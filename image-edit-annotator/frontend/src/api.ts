@@ -19,6 +19,9 @@ export interface Project {
   captionApi?: string | null;
   systemPrompt?: string | null;
   autoCaptionConfig?: string | null;
+  keepOriginal?: boolean;
+  autoGenerateTasks?: boolean;
+  captionStripPatterns?: string[] | null;
 }
 
 export interface ProjectWithStats {
@@ -42,6 +45,17 @@ export const getProject = (id: string) => api.get<Project>(`/projects/${id}`);
 export const listProjects = () => api.get<Project[]>('/projects');
 export const listProjectsWithStats = () => api.get<ProjectWithStats[]>('/projects/stats');
 export const updateProject = (id: string, project: Omit<Project, 'id'>) => api.put<Project>(`/projects/${id}`, project);
+
+export interface PromptButtonPreset {
+  promptButtons: string[];
+}
+
+export const exportPromptButtons = (projectId: string) =>
+  api.get<PromptButtonPreset>(`/projects/${projectId}/prompt-buttons/export`);
+
+// mode=merge keeps the project's buttons and appends the preset's new ones
+export const importPromptButtons = (projectId: string, preset: PromptButtonPreset, mode: 'replace' | 'merge' = 'replace') =>
+  api.post<Project>(`/projects/${projectId}/prompt-buttons/import`, preset, { params: { mode } });
 export const deleteProject = (id: string) => api.delete(`/projects/${id}`);
 
 export interface Image {
@@ -49,6 +63,11 @@ export interface Image {
   projectId: string;
   path: string;
   pHash: string;
+  contentHash?: string;
+  originalPath?: string | null;
+  notes?: string | null;
+  excluded?: boolean;
+  metadata?: Record<string, string>;
 }
 
 export interface ProgressUpdate {
@@ -58,13 +77,23 @@ export interface ProgressUpdate {
   total: number;
   status: string;
   errorMessage?: string;
+  bytesProcessed?: number;
+  totalBytes?: number; // Omitted when the batch size isn't known up front
+  tasksCreated?: number; // On the completion update, with autoGenerateTasks
 }
 
 export const uploadFiles = (projectId: string, files: FileList) => {
   const formData = new FormData();
-  Array.from(files).forEach(file => {
+  const fileArray = Array.from(files);
+  fileArray.forEach(file => {
     formData.append('files', file);
   });
+  // Files picked from a folder keep their relative paths on the server
+  if (fileArray.length > 0 && fileArray.every(file => file.webkitRelativePath)) {
+    fileArray.forEach(file => {
+      formData.append('paths', file.webkitRelativePath);
+    });
+  }
 
   return api.post(`/upload?projectId=${projectId}`, formData, {
     headers: {
@@ -73,21 +102,62 @@ export const uploadFiles = (projectId: string, files: FileList) => {
   });
 };
 
+export interface CursorPage<T> {
+  items: T[];
+  nextCursor: string | null; // null on the last page
+}
+
 export const getImages = (projectId: string) => api.get<Image[]>(`/images?projectId=${projectId}`);
+export const getImagesAfter = (projectId: string, after: string, limit?: number) =>
+  api.get<CursorPage<Image>>('/images', { params: { projectId, after, limit } });
+export const updateImageNotes = (imageId: string, notes: string | null) =>
+  api.patch<Image>(`/images/${imageId}`, { notes });
+export const setImageExcluded = (imageId: string, excluded: boolean) =>
+  api.patch<Image>(`/images/${imageId}`, { excluded });
+export const getImageMetadata = (imageId: string) => api.get<Image>(`/images/${imageId}/metadata`);
+export const setImageMetadata = (imageId: string, metadata: Record<string, string>) =>
+  api.put<Image>(`/images/${imageId}/metadata`, { metadata });
 export const deleteImage = (projectId: string, imageId: string) => api.delete(`/projects/${projectId}/images/${imageId}`);
 
+export interface DuplicatesResponse {
+  projectId: string;
+  exact: boolean;
+  checked: number;
+  unhashed?: number;
+  groups: { hash: string; images: Image[] }[];
+}
+
+// exact=true groups byte-identical files; otherwise images sharing a pHash
+export const getDuplicates = (projectId: string, exact = false) =>
+  api.get<DuplicatesResponse>(`/projects/${projectId}/duplicates`, { params: { exact } });
+
+export interface DistancesResponse {
+  distances: { imageAId: string; imageBId: string; distance: number }[];
+  skipped: { imageAId: string; imageBId: string; error: string }[];
+}
+
+export const getDistances = (projectId: string, pairs: [string, string][]) =>
+  api.post<DistancesResponse>(`/projects/${projectId}/distances`, { pairs });
+
 export const createProgressEventSource = (projectId: string) => {
   return new EventSource(`${API_BASE_URL}/progress?projectId=${projectId}`);
 };
 
+// Last-known update of each running upload; the event source also replays these on connect
+export const getUploadProgressSnapshot = (projectId: string) =>
+  api.get<ProgressUpdate[]>('/progress/snapshot', { params: { projectId } });
+
 export interface Task {
   id: string;
   projectId: string;
   imageAId: string;
   imageBId: { String: string; Valid: boolean } | null;
   prompt: { String: string; Valid: boolean } | null;
+  negativePrompt?: { String: string; Valid: boolean } | null;
   skipped: boolean;
   candidateBIds: string[] | null;
+  candidates?: { imageId: string; distance: number | null }[] | null;
+  region?: { x: number; y: number; w: number; h: number } | null;
 }
 
 export interface CaptionTask {
@@ -102,19 +172,39 @@ export interface CaptionTask {
 export interface TaskGenerationRequest {
   similarityThreshold?: number;
   maxCandidates?: number;
+  minCandidates?: number;
+  imageAfter?: string;
+  verify?: boolean;
+  maxPixelDifference?: number;
+  candidateStrategy?: 'nearest' | 'diverse';
+  hashWeights?: { pHash: number; dHash: number }; // Combined as a weighted mean; pHash alone when omitted
 }
 
 export interface TaskGenerationResponse {
   tasksCreated: number;
   averageCandidates: number;
+  skippedInsufficientCandidates?: number;
+  rejectedByVerification?: number;
 }
 
 export const generateTasks = (projectId: string, request?: TaskGenerationRequest) =>
   api.post<TaskGenerationResponse>(`/projects/${projectId}/generate-tasks`, request || {});
 
 export const getTasks = (projectId: string) => api.get<Task[]>(`/projects/${projectId}/tasks`);
+export const getTasksPage = (projectId: string, limit: number, offset: number) =>
+  api.get<Task[]>(`/projects/${projectId}/tasks`, { params: { limit, offset } }); // Total count is in the X-Total-Count header
+// Pass after: '' for the first page, then each page's nextCursor until it is null
+export const getTasksAfter = (projectId: string, after: string, limit?: number) =>
+  api.get<CursorPage<Task>>(`/projects/${projectId}/tasks`, { params: { after, limit } });
 export const getTask = (taskId: string) => api.get<Task>(`/tasks/${taskId}`);
 export const updateTask = (taskId: string, task: Partial<Task>) => api.put<Task>(`/tasks/${taskId}`, task);
+// Only the fields given are changed; null clears one
+export const patchTask = (taskId: string, fields: Partial<Task>) => api.patch<Task>(`/tasks/${taskId}`, fields);
+export const reassignTaskImageA = (
+  taskId: string,
+  imageAId: string,
+  options: { recomputeCandidates?: boolean; similarityThreshold?: number; maxCandidates?: number } = {}
+) => api.patch<Task>(`/tasks/${taskId}`, { imageAId, ...options });
 
 export const getCaptionTasks = (projectId: string) => api.get<CaptionTask[]>(`/projects/${projectId}/caption-tasks`);
 export const getCaptionTask = (taskId: string) => api.get<CaptionTask>(`/caption-tasks/${taskId}`);
@@ -133,6 +223,7 @@ export interface CaptionAPIConfig {
   apiKey: string;
   endpoint?: string;
   model?: string;
+  headers?: Record<string, string>; // Sent with every provider call
 }
 
 export interface AutoCaptionResponse {
@@ -145,11 +236,12 @@ export interface AutoCaptionConfig {
   maxRetries: number;
   retryDelayMs: number;
   concurrentTasks: number;
+  maxConsecutiveFailures?: number;
 }
 
 export interface AutoCaptionProgress {
   projectId: string;
-  status: 'running' | 'completed' | 'cancelled' | 'error';
+  status: 'running' | 'paused' | 'completed' | 'cancelled' | 'error';
   total: number;
   processed: number;
   successful: number;
@@ -158,6 +250,10 @@ export interface AutoCaptionProgress {
   errorMessage?: string;
   startedAt?: string;
   completedAt?: string;
+  averageMsPerTask?: number;
+  estimatedCompletionAt?: string;
+  currentRPM?: number;
+  pausedAt?: string;
 }
 
 export interface AutoCaptionRequest {
@@ -167,6 +263,8 @@ export interface AutoCaptionRequest {
 export interface AutoCaptionStatusResponse {
   progress?: AutoCaptionProgress;
   isActive: boolean;
+  activeSessions?: number;
+  maxSessions?: number;
 }
 
 export const autoCaptionTask = (taskId: string) => 
@@ -178,6 +276,12 @@ export const startAutoCaptioning = (projectId: string, config: AutoCaptionConfig
 export const cancelAutoCaptioning = (projectId: string) =>
   api.post<{ message: string }>(`/projects/${projectId}/auto-caption-cancel`);
 
+export const pauseAutoCaptioning = (projectId: string) =>
+  api.post<AutoCaptionStatusResponse>(`/projects/${projectId}/auto-caption-pause`);
+
+export const resumeAutoCaptioning = (projectId: string) =>
+  api.post<AutoCaptionStatusResponse>(`/projects/${projectId}/auto-caption-resume`);
+
 export const getAutoCaptionStatus = (projectId: string) =>
   api.get<AutoCaptionStatusResponse>(`/projects/${projectId}/auto-caption-status`);
 
@@ -215,8 +319,11 @@ export interface ExportStatus {
   completedAt?: string;
 }
 
+// ai-toolkit exports stream by default; async=true asks for the background job with progress events
 export const startExport = (projectId: string, exportType: 'ai-toolkit' | 'image-text-pairs') =>
-  api.get<{ message: string; projectId: string; type: string }>(`/projects/${projectId}/export/${exportType}`);
+  api.get<{ message: string; projectId: string; type: string }>(
+    `/projects/${projectId}/export/${exportType}${exportType === 'ai-toolkit' ? '?async=true' : ''}`
+  );
 
 export const getExportStatus = (projectId: string) =>
   api.get<ExportStatus>(`/projects/${projectId}/export-status`);